@@ -78,6 +78,13 @@ function succeed(stack: Stack): def {
 function fail(stack: Stack): def {
   return [new sfn.Fail(stack, "Fail State"), 0];
 }
+function fail_error(stack: Stack): def {
+  const v = new sfn.Fail(stack, "Fail State", {
+    error: "CustomError",
+    cause: "This is a custom error",
+  });
+  return [v, 0];
+}
 function choice(stack: Stack): def {
   const v = new sfn.Choice(stack, "Choice State")
     .when(sfn.Condition.booleanEquals("$.bool", true), succeed(stack)[0])
@@ -544,6 +551,28 @@ function task_catch(stack: Stack): def {
   });
   return [task, 0];
 }
+function task_catch_resultpath(stack: Stack): def {
+  const p1 = new sfn.Pass(stack, "Pass State1");
+  const task = new ScriptTask(stack, "Task State", {
+    scriptPath: "::", // invalid resource path
+  });
+  task.addCatch(p1, {
+    errors: ["States.TaskFailed"],
+    resultPath: "$.error",
+  });
+  return [task, 0];
+}
+function task_catch_resultpath_null(stack: Stack): def {
+  const p1 = new sfn.Pass(stack, "Pass State1");
+  const task = new ScriptTask(stack, "Task State", {
+    scriptPath: "::", // invalid resource path
+  });
+  task.addCatch(p1, {
+    errors: ["States.TaskFailed"],
+    resultPath: sfn.JsonPath.DISCARD,
+  });
+  return [task, 0];
+}
 function task_ctxobj(stack: Stack): def {
   const v = new ScriptTask(stack, "Task State", {
     scriptPath: "_workflow/script/script1.sh",
@@ -590,6 +619,16 @@ function parallel_ctxobj(stack: Stack): def {
     .branch(s3);
   return [v, 0];
 }
+function parallel_catch(stack: Stack): def {
+  const p1 = new sfn.Pass(stack, "Pass State1");
+  const v = new sfn.Parallel(stack, "Parallel State").branch(
+    fail_error(stack)[0]
+  );
+  v.addCatch(p1, {
+    errors: ["CustomError"],
+  });
+  return [v, 0];
+}
 function map(stack: Stack): def {
   const map = new sfn.Map(stack, "Map State", {
     maxConcurrency: 1,
@@ -646,6 +685,7 @@ const workflows: ((stack: Stack) => def)[] = [
   wait,
   succeed,
   fail,
+  fail_error,
   choice,
   choice_fallback,
   choice_bool,
@@ -654,9 +694,12 @@ const workflows: ((stack: Stack) => def)[] = [
   task_filter,
   task_retry,
   task_catch,
+  task_catch_resultpath,
+  task_catch_resultpath_null,
   task_ctxobj,
   parallel,
   parallel_ctxobj,
+  parallel_catch,
   map,
   map_concurrency,
   map_ctxobj,
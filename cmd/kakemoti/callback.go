@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/w-haibara/kakemoti/config"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+// Task tokens are returned to a running 'kakemoti run' through files in the callback directory.
+// The send-task-* commands write a file for each call, and 'kakemoti run' consumes the files of its own tokens.
+
+const callbackPollInterval = 500 * time.Millisecond
+
+// callbackTTL is how long a callback for an unknown token is left for the other executions.
+// A token is known while its task is waiting, so an older callback is for a finished execution or a wrong token.
+const callbackTTL = 10 * time.Minute
+
+const (
+	callbackTypeSuccess   = "success"
+	callbackTypeFailure   = "failure"
+	callbackTypeHeartbeat = "heartbeat"
+)
+
+type callbackRequest struct {
+	Type      string          `json:"Type"`
+	TaskToken string          `json:"TaskToken"`
+	Output    json.RawMessage `json:"Output,omitempty"`
+	Error     string          `json:"Error,omitempty"`
+	Cause     string          `json:"Cause,omitempty"`
+}
+
+func defaultCallbackDir() string {
+	return filepath.Join(config.ConfigDir(), "callbacks")
+}
+
+func sendTaskSuccessCmd(args []string) int {
+	fs := flag.NewFlagSet("send-task-success", flag.ContinueOnError)
+	token := fs.String("task-token", "", "the task token in $$.Task.Token")
+	output := fs.String("task-output", "", "the JSON output of the task")
+	dir := fs.String("callback-dir", defaultCallbackDir(), "the directory watched by 'kakemoti run'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti send-task-success --task-token <token> --task-output <json>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *token == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if !json.Valid([]byte(*output)) {
+		return fatalf(exitInvalidInput, "%v: %s", worker.ErrInvalidTaskOutput, *output)
+	}
+
+	return sendCallback(*dir, callbackRequest{
+		Type:      callbackTypeSuccess,
+		TaskToken: *token,
+		Output:    json.RawMessage(*output),
+	})
+}
+
+func sendTaskFailureCmd(args []string) int {
+	fs := flag.NewFlagSet("send-task-failure", flag.ContinueOnError)
+	token := fs.String("task-token", "", "the task token in $$.Task.Token")
+	errorName := fs.String("error", "", "the error name of the failure")
+	cause := fs.String("cause", "", "a human-readable description of the failure")
+	dir := fs.String("callback-dir", defaultCallbackDir(), "the directory watched by 'kakemoti run'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti send-task-failure --task-token <token> [--error <name>] [--cause <cause>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *token == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return sendCallback(*dir, callbackRequest{
+		Type:      callbackTypeFailure,
+		TaskToken: *token,
+		Error:     *errorName,
+		Cause:     *cause,
+	})
+}
+
+func sendTaskHeartbeatCmd(args []string) int {
+	fs := flag.NewFlagSet("send-task-heartbeat", flag.ContinueOnError)
+	token := fs.String("task-token", "", "the task token in $$.Task.Token")
+	dir := fs.String("callback-dir", defaultCallbackDir(), "the directory watched by 'kakemoti run'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti send-task-heartbeat --task-token <token>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *token == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return sendCallback(*dir, callbackRequest{
+		Type:      callbackTypeHeartbeat,
+		TaskToken: *token,
+	})
+}
+
+// sendCallback writes req to the callback directory.
+// The file is renamed after it is written, so that it is never read halfway.
+func sendCallback(dir string, req callbackRequest) int {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	f, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fatalf(exitError, "failed to write the callback: %v", err)
+	}
+
+	if err := os.Rename(f.Name(), strings.TrimSuffix(f.Name(), ".tmp")+".json"); err != nil {
+		_ = os.Remove(f.Name())
+		return fatalf(exitError, "failed to write the callback: %v", err)
+	}
+
+	return exitOK
+}
+
+// watchCallbacks passes the callbacks in dir to engine until ctx is done.
+func watchCallbacks(ctx context.Context, engine *worker.Engine, logger *log.Logger, dir string) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Warnln("failed to create the callback directory:", err)
+		return
+	}
+
+	ticker := time.NewTicker(callbackPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			consumeCallbacks(engine, logger, dir)
+		}
+	}
+}
+
+func consumeCallbacks(engine *worker.Engine, logger *log.Logger, dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		logger.Warnln("failed to read the callback directory:", err)
+		return
+	}
+
+	for _, path := range paths {
+		err := consumeCallback(engine, path)
+		if errors.Is(err, worker.ErrTaskTokenNotFound) {
+			// the token may belong to another execution, unless the callback has expired
+			if info, err := os.Stat(path); err != nil || time.Since(info.ModTime()) < callbackTTL {
+				continue
+			}
+			logger.Warnf("expired callback %s: the task token is not found", path)
+		}
+		if err != nil {
+			logger.Warnf("invalid callback %s: %v", path, err)
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warnln("failed to remove the callback:", err)
+		}
+	}
+}
+
+func consumeCallback(engine *worker.Engine, path string) error {
+	b, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return err
+	}
+
+	var req callbackRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	switch req.Type {
+	case callbackTypeSuccess:
+		return engine.SendTaskSuccess(req.TaskToken, req.Output)
+	case callbackTypeFailure:
+		return engine.SendTaskFailure(req.TaskToken, req.Error, req.Cause)
+	case callbackTypeHeartbeat:
+		return engine.SendTaskHeartbeat(req.TaskToken)
+	default:
+		return fmt.Errorf("unknown callback type: %s", req.Type)
+	}
+}
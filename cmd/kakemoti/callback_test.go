@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+func TestSendTaskCmd(t *testing.T) {
+	dir := t.TempDir()
+
+	tokens := make(chan string, 1)
+	tasks := task.FnMap{
+		"notify": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			tokens <- in["token"].(string)
+			return fn.Obj{}, "", nil
+		},
+	}
+	engine := worker.NewEngine(worker.WithTaskRegistry(tasks))
+
+	asl := `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "notify:aaa.waitForTaskToken",
+		"Parameters": {"token.$": "$$.Task.Token"}, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	results := make(chan worker.Result, 1)
+	go func() {
+		res, err := engine.Execute(context.Background(), nil, w, nil)
+		if err != nil {
+			t.Error("Execute() failed:", err)
+		}
+		results <- res
+	}()
+	token := <-tokens
+
+	if got := Main([]string{"send-task-success", "--callback-dir", dir, "--task-token", token, "--task-output", `{`}); got != exitInvalidInput {
+		t.Errorf("Main() = %d, want %d", got, exitInvalidInput)
+	}
+	for _, args := range [][]string{
+		{"send-task-heartbeat", "--callback-dir", dir, "--task-token", token},
+		{"send-task-success", "--callback-dir", dir, "--task-token", "unknown", "--task-output", `{}`},
+	} {
+		if got := Main(args); got != exitOK {
+			t.Fatalf("Main(%v) = %d, want %d", args, got, exitOK)
+		}
+	}
+	consumeCallbacks(engine, log.New(), dir)
+
+	// the callback for the unknown token is left for other executions
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("callbacks = %v, want 1 file", paths)
+	}
+
+	// the callback for the unknown token is removed when it expires
+	past := time.Now().Add(-callbackTTL)
+	if err := os.Chtimes(paths[0], past, past); err != nil {
+		t.Fatal(err)
+	}
+	consumeCallbacks(engine, log.New(), dir)
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(paths) != 0 {
+		t.Errorf("callbacks = %v, want no files", paths)
+	}
+
+	if got := Main([]string{"send-task-success", "--callback-dir", dir, "--task-token", token, "--task-output", `{"ok": true}`}); got != exitOK {
+		t.Fatalf("Main() = %d, want %d", got, exitOK)
+	}
+	consumeCallbacks(engine, log.New(), dir)
+
+	res := <-results
+	if res.Status != worker.ExecutionStatusSucceeded || string(res.Output) != `{"ok":true}` {
+		t.Errorf("Execute() = %#v", res)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/k0kubun/pp"
+)
+
+func describeCmd(args []string) int {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	outputPath := fs.String("output", "", "write the description to this file instead of the standard output")
+	color := fs.Bool("color", false, "colorize the description")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti describe [flags] <asl-file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	w, code := compileFile(context.Background(), fs.Arg(0))
+	if code != exitOK {
+		return code
+	}
+
+	pp.ColoringEnabled = *color
+	s := pp.Sprintln(w)
+
+	if *outputPath == "" {
+		fmt.Fprint(os.Stdout, s)
+		return exitOK
+	}
+
+	if err := os.WriteFile(*outputPath, []byte(s), 0600); err != nil {
+		return fatalf(exitError, "failed to write the description: %v", err)
+	}
+
+	return exitOK
+}
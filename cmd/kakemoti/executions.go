@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+// executionsCmd lists the checkpoints of the unfinished executions saved by `run -checkpoint`, and deletes them.
+func executionsCmd(args []string) int {
+	fs := flag.NewFlagSet("executions", flag.ContinueOnError)
+	storeDir := fs.String("store-dir", defaultStoreDir(), "the directory of the checkpoints of the executions")
+	del := fs.String("delete", "", "delete the checkpoint of the execution of this ID")
+	olderThan := fs.Duration("delete-older-than", 0, "delete the checkpoints not updated for this duration (e.g. 168h)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti executions [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 || (*del != "" && *olderThan > 0) {
+		fs.Usage()
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	store := worker.NewFileStore(*storeDir)
+
+	if *del != "" {
+		// a broken checkpoint can be deleted too
+		if _, err := store.Load(ctx, *del); errors.Is(err, worker.ErrExecutionNotFound) {
+			return fatalf(exitError, "%v", err)
+		}
+		if err := store.Delete(ctx, *del); err != nil {
+			return fatalf(exitError, "%v", err)
+		}
+		return exitOK
+	}
+
+	cps, err := store.List(ctx)
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	if *olderThan > 0 {
+		for _, cp := range cps {
+			if time.Since(cp.UpdateDate) < *olderThan {
+				continue
+			}
+			if err := store.Delete(ctx, cp.ID); err != nil {
+				return fatalf(exitError, "%v", err)
+			}
+			fmt.Fprintln(os.Stdout, cp.ID)
+		}
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tSTARTED\tUPDATED")
+	for _, cp := range cps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cp.ID, cp.StateName, cp.StartDate.Format(time.RFC3339), cp.UpdateDate.Format(time.RFC3339))
+	}
+	if err := tw.Flush(); err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	return exitOK
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+// exit codes of the kakemoti command
+const (
+	exitOK                = 0 // the execution succeeded
+	exitError             = 1 // I/O or internal errors
+	exitUsage             = 2 // invalid command line
+	exitInvalidDefinition = 3 // the state machine could not be compiled
+	exitInvalidInput      = 4 // the input is not a valid JSON text
+	exitFailed            = 5 // the execution failed
+	exitTimedOut          = 6 // the execution exceeded its TimeoutSeconds
+	exitAborted           = 7 // the execution was interrupted
+)
+
+func exitCodeOf(status worker.ExecutionStatus) int {
+	switch status {
+	case worker.ExecutionStatusSucceeded:
+		return exitOK
+	case worker.ExecutionStatusTimedOut:
+		return exitTimedOut
+	case worker.ExecutionStatusAborted:
+		return exitAborted
+	default:
+		return exitFailed
+	}
+}
+
+func fatalf(code int, format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "kakemoti: "+format+"\n", a...)
+	return code
+}
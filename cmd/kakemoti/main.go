@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: kakemoti <command> [flags] [args]
+
+Commands:
+  run        compile and execute a state machine
+  validate   compile a state machine without executing it
+  describe   print the compiled state machine
+  serve      serve the AWS Step Functions API over HTTP
+  executions list or delete the checkpoints of the unfinished executions
+
+  send-task-success     complete a task waiting for its task token
+  send-task-failure     fail a task waiting for its task token
+  send-task-heartbeat   report that a task waiting for its task token is in progress
+
+Run 'kakemoti <command> -h' for the flags of each command.
+`
+
+type command func(args []string) int
+
+var commands = map[string]command{
+	"run":      runCmd,
+	"validate": validateCmd,
+	"describe": describeCmd,
+	"serve":    serveCmd,
+
+	"executions": executionsCmd,
+
+	"send-task-success":   sendTaskSuccessCmd,
+	"send-task-failure":   sendTaskFailureCmd,
+	"send-task-heartbeat": sendTaskHeartbeatCmd,
+}
+
+func main() {
+	os.Exit(Main(os.Args[1:]))
+}
+
+func Main(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	return cmd(args[1:])
+}
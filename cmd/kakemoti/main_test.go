@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+func TestMain_exitCode(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.asl.json")
+	if err := os.WriteFile(invalid, []byte(`{"States": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	failed := filepath.Join(dir, "failed.asl.json")
+	if err := os.WriteFile(failed, []byte(`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:::", "End": true}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	badInput := filepath.Join(dir, "input.json")
+	if err := os.WriteFile(badInput, []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.json")
+	store := filepath.Join(dir, "executions")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", []string{}, exitUsage},
+		{"unknown command", []string{"xxx"}, exitUsage},
+		{"validate", []string{"validate", "../../_workflow/asl/pass.asl.json"}, exitOK},
+		{"validate(invalid)", []string{"validate", invalid}, exitInvalidDefinition},
+		{"validate(not found)", []string{"validate", filepath.Join(dir, "xxx")}, exitError},
+		{"describe", []string{"describe", "--output", output, "../../_workflow/asl/choice.asl.json"}, exitOK},
+		{"run", []string{"run", "--store-dir", store, "--input", "../../_workflow/inputs/input1.json", "--output", output, "../../_workflow/asl/pass.asl.json"}, exitOK},
+		{"run(history)", []string{"run", "--store-dir", store, "--history", filepath.Join(dir, "history.json"), "--output", output, failed}, exitFailed},
+		{"run(invalid input)", []string{"run", "--store-dir", store, "--input", badInput, "../../_workflow/asl/pass.asl.json"}, exitInvalidInput},
+		{"run(failed)", []string{"run", "--store-dir", store, "--output", output, failed}, exitFailed},
+		{"run(checkpoint)", []string{"run", "--checkpoint", "--store-dir", store, "--output", output, failed}, exitFailed},
+		{"serve(unexpected args)", []string{"serve", "xxx"}, exitUsage},
+		{"serve(invalid addr)", []string{"serve", "--addr", "invalid:addr:0"}, exitError},
+		{"run(resume not found)", []string{"run", "--store-dir", store, "--resume", "xxx", "../../_workflow/asl/pass.asl.json"}, exitError},
+		{"executions", []string{"executions", "--store-dir", store}, exitOK},
+		{"executions(delete not found)", []string{"executions", "--store-dir", store, "--delete", "xxx"}, exitError},
+		{"executions(delete older than)", []string{"executions", "--store-dir", store, "--delete-older-than", "1h"}, exitOK},
+		{"executions(unexpected args)", []string{"executions", "xxx"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Main(tt.args); got != tt.want {
+				t.Errorf("Main() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutions_delete(t *testing.T) {
+	dir := t.TempDir()
+	store := worker.NewFileStore(dir)
+	if err := store.Save(context.Background(), worker.Checkpoint{ID: "id", StateName: "S"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := Main([]string{"executions", "--store-dir", dir, "--delete", "id"}); got != exitOK {
+		t.Fatalf("Main() = %d, want %d", got, exitOK)
+	}
+	if _, err := store.Load(context.Background(), "id"); !errors.Is(err, worker.ErrExecutionNotFound) {
+		t.Errorf("Load() error = %v, want %v", err, worker.ErrExecutionNotFound)
+	}
+}
+
+// run saves no checkpoint unless -checkpoint is given.
+func TestRun_noCheckpoint(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "executions")
+	if got := Main([]string{"run", "--store-dir", dir, "--output", filepath.Join(t.TempDir(), "output.json"), "../../_workflow/asl/pass.asl.json"}); got != exitOK {
+		t.Fatalf("Main() = %d, want %d", got, exitOK)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the store directory is created: %v", err)
+	}
+}
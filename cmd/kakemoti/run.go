@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/config"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+func runCmd(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	inputPath := fs.String("input", "", "input JSON file (\"-\" reads the standard input)")
+	outputPath := fs.String("output", "", "write the execution output to this file instead of the standard output")
+	logLevel := fs.String("log-level", "info", "log level (debug, info, warning, error)")
+	callbackDir := fs.String("callback-dir", defaultCallbackDir(), "the directory to receive task tokens sent by the send-task-* commands")
+	s3Dir := fs.String("s3-dir", ".", "the directory that stands in for Amazon S3 in ItemReader and ResultWriter (buckets are its subdirectories)")
+	checkpoint := fs.Bool("checkpoint", false, "save a checkpoint before each state in -store-dir, so that an aborted execution can be resumed")
+	storeDir := fs.String("store-dir", defaultStoreDir(), "the directory to save the checkpoints of the executions")
+	resume := fs.String("resume", "", "resume the unfinished execution of this ID instead of starting a new one (implies -checkpoint)")
+	historyPath := fs.String("history", "", "write the history of the execution to this file, in the format of GetExecutionHistory")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti run [flags] <asl-file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		return fatalf(exitUsage, "%v", err)
+	}
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.SetLevel(level)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w, code := compileFile(ctx, fs.Arg(0))
+	if code != exitOK {
+		return code
+	}
+
+	opts := []worker.Option{
+		worker.WithLogger(logger),
+		worker.WithS3Dir(*s3Dir),
+	}
+	if *checkpoint || *resume != "" {
+		opts = append(opts, worker.WithExecutionStore(worker.NewFileStore(*storeDir)))
+	}
+	if *historyPath != "" {
+		opts = append(opts, worker.WithHistory(1))
+	}
+	engine := worker.NewEngine(opts...)
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go watchCallbacks(watchCtx, engine, logger, *callbackDir)
+
+	var res worker.Result
+	if *resume != "" {
+		res, err = engine.Resume(ctx, w, *resume)
+	} else {
+		input, code := readInput(*inputPath)
+		if code != exitOK {
+			return code
+		}
+
+		coj, cojErr := stateMachineCtxObj(fs.Arg(0))
+		if cojErr != nil {
+			return fatalf(exitError, "%v", cojErr)
+		}
+
+		res, err = engine.Execute(ctx, coj, w, input)
+	}
+	if errors.Is(err, worker.ErrInvalidInput) {
+		return fatalf(exitInvalidInput, "%v", err)
+	}
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	if *historyPath != "" {
+		if code := writeHistory(*historyPath, engine, res.ID); code != exitOK {
+			return code
+		}
+	}
+
+	if res.Status == worker.ExecutionStatusAborted && (*checkpoint || *resume != "") {
+		return fatalf(exitCodeOf(res.Status), "execution %s: %s: %s (resume it with -resume %s)", res.Status, res.Error, res.Cause, res.ID)
+	}
+	if code := exitCodeOf(res.Status); code != exitOK {
+		return fatalf(code, "execution %s: %s: %s", res.Status, res.Error, res.Cause)
+	}
+
+	return writeOutput(*outputPath, res.Output)
+}
+
+func defaultStoreDir() string {
+	return filepath.Join(config.ConfigDir(), "executions")
+}
+
+// stateMachineCtxObj returns the context object that has $$.StateMachine of the state machine at path.
+func stateMachineCtxObj(path string) (*compiler.CtxObj, error) {
+	coj := new(compiler.CtxObj)
+	if path == "-" {
+		return coj, nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.TrimSuffix(name, ".asl")
+
+	return coj.SetByString("$.StateMachine", map[string]interface{}{
+		"Id":   abs,
+		"Name": name,
+	})
+}
+
+func readInput(path string) (*bytes.Buffer, int) {
+	if path == "" {
+		return nil, exitOK
+	}
+
+	b, err := readFile(path)
+	if err != nil {
+		return nil, fatalf(exitError, "failed to read the input: %v", err)
+	}
+
+	return bytes.NewBuffer(b), exitOK
+}
+
+func writeHistory(path string, engine *worker.Engine, id string) int {
+	h, err := engine.History(id)
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		return fatalf(exitError, "failed to write the history: %v", err)
+	}
+
+	return exitOK
+}
+
+func writeOutput(path string, b []byte) int {
+	b = append(b, '\n')
+
+	if path == "" {
+		if _, err := os.Stdout.Write(b); err != nil {
+			return fatalf(exitError, "%v", err)
+		}
+		return exitOK
+	}
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return fatalf(exitError, "failed to write the output: %v", err)
+	}
+
+	return exitOK
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/w-haibara/kakemoti/server"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+// shutdownTimeout is how long the server waits for the requests in progress when it stops.
+const shutdownTimeout = 10 * time.Second
+
+func serveCmd(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8083", "the address to listen on")
+	region := fs.String("region", server.DefaultRegion, "the region in the ARNs")
+	accountID := fs.String("account-id", server.DefaultAccountID, "the account ID in the ARNs")
+	logLevel := fs.String("log-level", "info", "log level (debug, info, warning, error)")
+	historyLimit := fs.Int("history-limit", 1000, "the number of the finished executions kept for DescribeExecution, ListExecutions and GetExecutionHistory")
+	s3Dir := fs.String("s3-dir", ".", "the directory that stands in for Amazon S3 in ItemReader and ResultWriter (buckets are its subdirectories)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti serve [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		return fatalf(exitUsage, "%v", err)
+	}
+	logger := log.New()
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.SetLevel(level)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// no checkpoint is saved, since the state machines to resume the executions with are kept in memory
+	engine := worker.NewEngine(
+		worker.WithLogger(logger),
+		worker.WithS3Dir(*s3Dir),
+		worker.WithHistory(*historyLimit),
+	)
+	srv := server.New(engine,
+		server.WithRegion(*region),
+		server.WithAccountID(*accountID),
+		server.WithExecutionLimit(*historyLimit),
+	)
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fatalf(exitError, "%v", err)
+	}
+
+	hs := &http.Server{Handler: srv}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hs.Serve(ln)
+	}()
+	logger.Infoln("listening on", ln.Addr())
+
+	select {
+	case err := <-errc:
+		return fatalf(exitError, "%v", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := hs.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fatalf(exitError, "%v", err)
+	}
+
+	return exitOK
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+func validateCmd(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: kakemoti validate <asl-file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if _, code := compileFile(context.Background(), fs.Arg(0)); code != exitOK {
+		return code
+	}
+
+	fmt.Fprintf(os.Stdout, "%s: ok\n", fs.Arg(0))
+	return exitOK
+}
+
+func compileFile(ctx context.Context, path string) (*compiler.Workflow, int) {
+	asl, err := readFile(path)
+	if err != nil {
+		return nil, fatalf(exitError, "failed to read the state machine: %v", err)
+	}
+
+	workflow, err := compiler.Compile(ctx, bytes.NewBuffer(asl))
+	if err != nil {
+		return nil, fatalf(exitInvalidDefinition, "invalid state machine: %v", err)
+	}
+
+	return workflow, exitOK
+}
+
+// readFile reads the named file, or the standard input if path is "-".
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path) // #nosec G304
+}
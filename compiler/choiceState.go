@@ -38,7 +38,16 @@ func (raw RawChoiceState) decode(name string) (State, error) {
 			return nil, invalidTypeError()
 		}
 
-		cond, err := decodeBoolExpr(raw)
+		if err := checkRuleFields(raw, true, s.Common().IsJSONata()); err != nil {
+			return nil, err
+		}
+
+		var cond Condition
+		if s.Common().IsJSONata() {
+			cond, err = decodeJSONataCondition(raw)
+		} else {
+			cond, err = decodeBoolExpr(raw)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -47,6 +56,22 @@ func (raw RawChoiceState) decode(name string) (State, error) {
 			Condition: cond,
 			Next:      next,
 		}
+
+		if v, ok := raw["Assign"]; ok {
+			assign, err := NewAssign(v, s.Common().QueryLanguage)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Assign of the Choice rule: %w", err)
+			}
+			choices[i].Assign = assign
+		}
+
+		if v, ok := raw["Output"]; ok {
+			output, err := NewJSONataTemplate(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Output of the Choice rule: %w", err)
+			}
+			choices[i].Output = output
+		}
 	}
 
 	return ChoiceState{
@@ -56,6 +81,70 @@ func (raw RawChoiceState) decode(name string) (State, error) {
 	}, nil
 }
 
+// comparisonOperators are the operators of a data-test expression.
+var comparisonOperators = map[string]bool{
+	"StringEquals": true, "StringEqualsPath": true,
+	"StringLessThan": true, "StringLessThanPath": true,
+	"StringGreaterThan": true, "StringGreaterThanPath": true,
+	"StringLessThanEquals": true, "StringLessThanEqualsPath": true,
+	"StringGreaterThanEquals": true, "StringGreaterThanEqualsPath": true,
+	"StringMatches": true,
+	"NumericEquals": true, "NumericEqualsPath": true,
+	"NumericLessThan": true, "NumericLessThanPath": true,
+	"NumericGreaterThan": true, "NumericGreaterThanPath": true,
+	"NumericLessThanEquals": true, "NumericLessThanEqualsPath": true,
+	"NumericGreaterThanEquals": true, "NumericGreaterThanEqualsPath": true,
+	"BooleanEquals": true, "BooleanEqualsPath": true,
+	"TimestampEquals": true, "TimestampEqualsPath": true,
+	"TimestampLessThan": true, "TimestampLessThanPath": true,
+	"TimestampGreaterThan": true, "TimestampGreaterThanPath": true,
+	"TimestampLessThanEquals": true, "TimestampLessThanEqualsPath": true,
+	"TimestampGreaterThanEquals": true, "TimestampGreaterThanEqualsPath": true,
+	"IsNull":      true,
+	"IsPresent":   true,
+	"IsNumeric":   true,
+	"IsString":    true,
+	"IsBoolean":   true,
+	"IsTimestamp": true,
+}
+
+// checkRuleFields returns an error if a Choice rule has a field that is not allowed, so that a typo is not ignored.
+// Only a top-level rule has Next and Assign, and only a rule of a JSONata state has Condition and Output.
+// ref: https://states-language.net/#choice-state
+func checkRuleFields(m map[string]interface{}, top, jsonata bool) error {
+	for k := range m {
+		switch {
+		case k == "Comment":
+		case top && (k == "Next" || k == "Assign"):
+		case jsonata && k == "Condition":
+		case jsonata && top && k == "Output":
+		case !jsonata && (k == "Variable" || k == "And" || k == "Or" || k == "Not" || comparisonOperators[k]):
+		default:
+			return fmt.Errorf("unknown field of the Choice rule: %q", k)
+		}
+	}
+	return nil
+}
+
+// decodeJSONataCondition decodes a Choice rule of a JSONata state, which has a Condition instead of the operators.
+func decodeJSONataCondition(m map[string]interface{}) (Condition, error) {
+	v, ok := m["Condition"]
+	if !ok {
+		return nil, fmt.Errorf("%w: 'Condition' is needed", ErrNotFound)
+	}
+	str, ok := v.(string)
+	if !ok {
+		return nil, invalidTypeError()
+	}
+
+	expr, err := compileJSONataExpr(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Condition: %w", err)
+	}
+
+	return JSONataRule{expr}, nil
+}
+
 func isExistKey(m map[string]interface{}, k string) bool {
 	_, ok := m[k]
 	return ok
@@ -465,7 +554,7 @@ func decodeDataTestExpr(m map[string]interface{}) (Condition, error) {
 	 * Unknown Operator
 	 */
 	default:
-		panic("Unknown Operator")
+		return nil, fmt.Errorf("%w: a comparison operator is needed (Variable=%s)", ErrNotFound, v)
 	}
 }
 
@@ -481,6 +570,9 @@ func decodeConds(m map[string]interface{}, key string) ([]Condition, error) {
 		if !ok {
 			return nil, invalidTypeError()
 		}
+		if err := checkRuleFields(v, false, false); err != nil {
+			return nil, err
+		}
 
 		c, err := decodeBoolExpr(v)
 		if err != nil {
@@ -515,6 +607,9 @@ func decodeBoolExpr(m map[string]interface{}) (Condition, error) {
 		if !ok {
 			return nil, invalidTypeError()
 		}
+		if err := checkRuleFields(v1, false, false); err != nil {
+			return nil, err
+		}
 
 		c, err := decodeBoolExpr(v1)
 		if err != nil {
@@ -545,7 +640,10 @@ func (state ChoiceState) GetNexts() []string {
 	return nexts
 }
 
+// Choice is a Choice rule. Assign and Output of the rule that matched take the place of the ones of the state.
 type Choice struct {
 	Condition Condition
 	Next      string
+	Assign    *Assign
+	Output    *JSONataTemplate
 }
@@ -1,5 +1,12 @@
 package compiler
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
 const (
 	FieldsType1 = iota
 	FieldsType2
@@ -9,16 +16,31 @@ const (
 )
 
 type CommonState1 struct {
-	StateName string
-	Type      string `json:"Type"`
-	Comment   string `json:"Comment"`
+	StateName     string
+	Type          string `json:"Type"`
+	Comment       string `json:"Comment"`
+	QueryLanguage string `json:"QueryLanguage"`
 }
 
 func (state CommonState1) decode(name string) (State, error) {
+	if err := validateQueryLanguage(state.QueryLanguage); err != nil {
+		return nil, err
+	}
+
 	state.StateName = name
 	return state, nil
 }
 
+// inheritQueryLanguage sets the QueryLanguage of the state machine, which is overridden by the one of the state.
+func (state *CommonState1) inheritQueryLanguage(ql string) {
+	state.QueryLanguage = ql
+}
+
+// IsJSONata reports whether the state uses JSONata instead of JSONPath.
+func (state CommonState1) IsJSONata() bool {
+	return state.QueryLanguage == QueryLanguageJSONata
+}
+
 func (state CommonState1) Name() string {
 	return state.StateName
 }
@@ -49,6 +71,10 @@ type CommonState2 struct {
 	InputPath     *Path
 	RawOutputPath *string `json:"OutputPath"`
 	OutputPath    *Path
+	RawOutput     interface{} `json:"Output"`
+	Output        *JSONataTemplate
+	RawAssign     interface{} `json:"Assign"`
+	Assign        *Assign
 }
 
 func (state CommonState2) decode(name string) (State, error) {
@@ -59,6 +85,26 @@ func (state CommonState2) decode(name string) (State, error) {
 
 	res := CommonState2{CommonState1: s.Common().CommonState1}
 
+	if err := state.validateQueryLanguage(); err != nil {
+		return nil, err
+	}
+
+	if state.RawOutput != nil {
+		v, err := NewJSONataTemplate(state.RawOutput)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Output: %w", err)
+		}
+		res.Output = v
+	}
+
+	if state.RawAssign != nil {
+		v, err := NewAssign(state.RawAssign, state.QueryLanguage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Assign: %w", err)
+		}
+		res.Assign = v
+	}
+
 	if state.RawInputPath != nil {
 		v1, err := NewPath(*state.RawInputPath)
 		if err != nil {
@@ -78,6 +124,23 @@ func (state CommonState2) decode(name string) (State, error) {
 	return res, nil
 }
 
+func (state CommonState2) validateQueryLanguage() error {
+	if state.IsJSONata() {
+		switch {
+		case state.RawInputPath != nil:
+			return unsupportedFieldError("InputPath", state.QueryLanguage)
+		case state.RawOutputPath != nil:
+			return unsupportedFieldError("OutputPath", state.QueryLanguage)
+		}
+		return nil
+	}
+
+	if state.RawOutput != nil {
+		return unsupportedFieldError("Output", state.QueryLanguage)
+	}
+	return nil
+}
+
 func (state CommonState2) FieldsType() int {
 	return FieldsType2
 }
@@ -127,7 +190,8 @@ type CommonState4 struct {
 	CommonState3
 	RawResultPath *string `json:"ResultPath"`
 	ResultPath    *ReferencePath
-	Parameters    interface{} `json:"Parameters"`
+	RawParameters interface{} `json:"Parameters"`
+	Parameters    *PayloadTemplate
 }
 
 func (state CommonState4) FieldsType() int {
@@ -147,39 +211,118 @@ func (state CommonState4) decode(name string) (State, error) {
 	}
 	state.CommonState3 = s.Common().CommonState3
 
+	if state.IsJSONata() {
+		switch {
+		case state.RawResultPath != nil:
+			return nil, unsupportedFieldError("ResultPath", state.QueryLanguage)
+		case state.RawParameters != nil:
+			return nil, unsupportedFieldError("Parameters", state.QueryLanguage)
+		}
+	}
+
 	if state.RawResultPath != nil {
-		v, err := NewReferencePath(*state.RawResultPath)
+		v, err := newResultPath(*state.RawResultPath)
 		if err != nil {
 			return nil, err
 		}
 		state.ResultPath = &v
 	}
 
+	if state.RawParameters != nil {
+		v, err := NewPayloadTemplate(state.RawParameters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Parameters: %w", err)
+		}
+		state.Parameters = v
+	}
+
 	return state, nil
 }
 
+// newResultPath parses ResultPath, which can not point to a variable.
+func newResultPath(path string) (ReferencePath, error) {
+	v, err := NewReferencePath(path)
+	if err != nil {
+		return ReferencePath{}, err
+	}
+	if v.Variable != "" {
+		return ReferencePath{}, fmt.Errorf("ResultPath can not point to a variable: %s", path)
+	}
+	return v, nil
+}
+
 type CommonState5 struct {
 	CommonState4
-	ResultSelector interface{} `json:"ResultSelector"`
-	Retry          []Retry     `json:"Retry"`
-	Catch          []Catch     `json:"Catch"`
+	RawResultSelector interface{} `json:"ResultSelector"`
+	ResultSelector    *PayloadTemplate
+	RawArguments      interface{} `json:"Arguments"`
+	Arguments         *JSONataTemplate
+	Retry             []Retry `json:"Retry"`
+	Catch             []Catch `json:"Catch"`
 }
 
 func (state CommonState5) FieldsType() int {
 	return FieldsType5
 }
 
+const (
+	JitterStrategyFull = "FULL"
+	JitterStrategyNone = "NONE"
+)
+
+var ErrInvalidRetrier = errors.New("invalid retrier")
+
 type Retry struct {
 	ErrorEquals     []string
 	IntervalSeconds *int
 	MaxAttempts     *int
 	BackoffRate     *float64
+	MaxDelaySeconds *int
+	JitterStrategy  string
+}
+
+func (retry Retry) validate() error {
+	if len(retry.ErrorEquals) == 0 {
+		return fmt.Errorf("%w: 'ErrorEquals' is needed", ErrInvalidRetrier)
+	}
+
+	if retry.IntervalSeconds != nil && *retry.IntervalSeconds < 0 {
+		return fmt.Errorf("%w: 'IntervalSeconds' must not be negative", ErrInvalidRetrier)
+	}
+
+	if retry.MaxAttempts != nil && *retry.MaxAttempts < 0 {
+		return fmt.Errorf("%w: 'MaxAttempts' must not be negative", ErrInvalidRetrier)
+	}
+
+	if retry.BackoffRate != nil && *retry.BackoffRate < 1.0 {
+		return fmt.Errorf("%w: 'BackoffRate' must be greater than or equal to 1.0", ErrInvalidRetrier)
+	}
+
+	if retry.MaxDelaySeconds != nil && *retry.MaxDelaySeconds <= 0 {
+		return fmt.Errorf("%w: 'MaxDelaySeconds' must be positive", ErrInvalidRetrier)
+	}
+
+	switch retry.JitterStrategy {
+	case "", JitterStrategyFull, JitterStrategyNone:
+	default:
+		return fmt.Errorf("%w: unknown 'JitterStrategy': %s", ErrInvalidRetrier, retry.JitterStrategy)
+	}
+
+	return nil
 }
 
 type Catch struct {
 	ErrorEquals []string
-	ResultPath  *Path
-	Next        string
+	// RawResultPath is kept raw to tell "ResultPath": null from the omitted field.
+	RawResultPath json.RawMessage `json:"ResultPath"`
+	ResultPath    *ReferencePath
+	// DiscardResult is true if ResultPath is null, which passes the input through in place of the error output.
+	DiscardResult bool
+	RawOutput     interface{} `json:"Output"`
+	Output        *JSONataTemplate
+	RawAssign     interface{} `json:"Assign"`
+	Assign        *Assign
+	Next          string
 }
 
 func (state CommonState5) Common() CommonState5 {
@@ -192,5 +335,82 @@ func (state CommonState5) decode(name string) (State, error) {
 		return nil, err
 	}
 	state.CommonState4 = s.Common().CommonState4
+
+	if state.IsJSONata() {
+		if state.RawResultSelector != nil {
+			return nil, unsupportedFieldError("ResultSelector", state.QueryLanguage)
+		}
+	} else if state.RawArguments != nil {
+		return nil, unsupportedFieldError("Arguments", state.QueryLanguage)
+	}
+
+	if state.RawArguments != nil {
+		v, err := NewJSONataTemplate(state.RawArguments)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Arguments: %w", err)
+		}
+		state.Arguments = v
+	}
+
+	if state.RawResultSelector != nil {
+		v, err := NewPayloadTemplate(state.RawResultSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ResultSelector: %w", err)
+		}
+		state.ResultSelector = v
+	}
+
+	for _, retry := range state.Retry {
+		if err := retry.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	catches := make([]Catch, len(state.Catch))
+	for i, catch := range state.Catch {
+		if state.IsJSONata() && catch.RawResultPath != nil {
+			return nil, unsupportedFieldError("ResultPath", state.QueryLanguage)
+		}
+		if !state.IsJSONata() && catch.RawOutput != nil {
+			return nil, unsupportedFieldError("Output", state.QueryLanguage)
+		}
+
+		if catch.RawOutput != nil {
+			v, err := NewJSONataTemplate(catch.RawOutput)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Output: %w", err)
+			}
+			catch.Output = v
+		}
+
+		if catch.RawAssign != nil {
+			v, err := NewAssign(catch.RawAssign, state.QueryLanguage)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Assign: %w", err)
+			}
+			catch.Assign = v
+		}
+
+		switch {
+		case catch.RawResultPath == nil:
+		case bytes.Equal(catch.RawResultPath, []byte("null")):
+			catch.DiscardResult = true
+		default:
+			var path string
+			if err := json.Unmarshal(catch.RawResultPath, &path); err != nil {
+				return nil, fmt.Errorf("invalid ResultPath: %w", err)
+			}
+			v, err := newResultPath(path)
+			if err != nil {
+				return nil, err
+			}
+			catch.ResultPath = &v
+		}
+		catches[i] = catch
+	}
+	if state.Catch != nil {
+		state.Catch = catches
+	}
+
 	return state, nil
 }
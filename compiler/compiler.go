@@ -38,6 +38,7 @@ type ASL struct {
 	StartAt        *string                    `json:"StartAt"`
 	TimeoutSeconds *int                       `json:"TimeoutSeconds"`
 	Version        *string                    `json:"Version"`
+	QueryLanguage  string                     `json:"QueryLanguage"`
 	States         map[string]json.RawMessage `json:"States"`
 }
 
@@ -71,6 +72,10 @@ func (asl *ASL) validate() error {
 		*asl.TimeoutSeconds = 0
 	}
 
+	if err := validateQueryLanguage(asl.QueryLanguage); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -125,6 +130,7 @@ func (asl *ASL) makeStates() (map[string]State, error) {
 			return nil, err
 		}
 
+		raw.inheritQueryLanguage(asl.QueryLanguage)
 		if err := json.Unmarshal(state, &raw); err != nil {
 			log.Println(err)
 			return nil, err
@@ -136,6 +142,13 @@ func (asl *ASL) makeStates() (map[string]State, error) {
 			return nil, err
 		}
 
+		// JSONPath states are not allowed in a state machine written in JSONata
+		if asl.QueryLanguage == QueryLanguageJSONata && !s.Common().IsJSONata() {
+			err := fmt.Errorf("%w: %s must not use %s in a state machine using %s", ErrInvalidQueryLanguage, name, QueryLanguageJSONPath, QueryLanguageJSONata)
+			log.Println(err)
+			return nil, err
+		}
+
 		states[name] = s
 	}
 
@@ -250,8 +250,8 @@ func TestCompile(t *testing.T) {
 							},
 						},
 						Resouce: TaskResouce{
-							"script",
-							"...",
+							Type: "script",
+							Path: "...",
 						},
 					},
 				},
@@ -285,3 +285,31 @@ func TestCompile(t *testing.T) {
 		})
 	}
 }
+
+func TestCompile_choiceRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		choices string
+		wantErr bool
+	}{
+		{"Assign", `[{"Variable": "$.a", "IsPresent": true, "Assign": {"x.$": "$.a"}, "Next": "P"}]`, false},
+		{"Comment", `[{"Variable": "$.a", "IsPresent": true, "Comment": "c", "Next": "P"}]`, false},
+		{"nested", `[{"And": [{"Variable": "$.a", "IsPresent": true}, {"Not": {"Variable": "$.b", "IsNull": true}}], "Next": "P"}]`, false},
+		{"unknown field", `[{"Variable": "$.a", "IsPresent": true, "Asign": {"x": 1}, "Next": "P"}]`, true},
+		{"unknown operator", `[{"Variable": "$.a", "StringEqual": "x", "Next": "P"}]`, true},
+		{"no operator", `[{"Variable": "$.a", "Next": "P"}]`, true},
+		{"Next in a nested rule", `[{"Not": {"Variable": "$.a", "IsNull": true, "Next": "P"}, "Next": "P"}]`, true},
+		{"Assign in a nested rule", `[{"And": [{"Variable": "$.a", "IsNull": true, "Assign": {"x": 1}}], "Next": "P"}]`, true},
+		{"Output with JSONPath", `[{"Variable": "$.a", "IsPresent": true, "Output": {}, "Next": "P"}]`, true},
+		{"invalid Assign", `[{"Variable": "$.a", "IsPresent": true, "Assign": {"1a": 1}, "Next": "P"}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asl := `{"StartAt": "C", "States": {"C": {"Type": "Choice", "Choices": ` + tt.choices + `}, "P": {"Type": "Pass", "End": true}}}`
+			_, err := Compile(context.Background(), bytes.NewBufferString(asl))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,20 +1,21 @@
 package compiler
 
 import (
+	"context"
 	"errors"
 )
 
 type Condition interface {
-	Eval(coj *CtxObj, input interface{}) (bool, error)
+	Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error)
 }
 
 type AndRule struct {
 	V []Condition
 }
 
-func (r AndRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r AndRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	for _, v := range r.V {
-		b, err := v.Eval(coj, input)
+		b, err := v.Eval(ctx, coj, input)
 		if err != nil {
 			return false, err
 		}
@@ -30,10 +31,10 @@ type OrRule struct {
 	V []Condition
 }
 
-func (r OrRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r OrRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	res := false
 	for _, v := range r.V {
-		b, err := v.Eval(coj, input)
+		b, err := v.Eval(ctx, coj, input)
 		if err != nil {
 			return false, err
 		}
@@ -47,8 +48,8 @@ type NotRule struct {
 	V Condition
 }
 
-func (r NotRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
-	b, err := r.V.Eval(coj, input)
+func (r NotRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
+	b, err := r.V.Eval(ctx, coj, input)
 	if err != nil {
 		return false, err
 	}
@@ -61,7 +62,7 @@ type StringEqualsRule struct {
 	V2 string
 }
 
-func (r StringEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -75,7 +76,7 @@ type StringEqualsPathRule struct {
 	V2 Path
 }
 
-func (r StringEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -94,7 +95,7 @@ type StringLessThanRule struct {
 	V2 string
 }
 
-func (r StringLessThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringLessThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -108,7 +109,7 @@ type StringLessThanPathRule struct {
 	V2 Path
 }
 
-func (r StringLessThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringLessThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -127,7 +128,7 @@ type StringLessThanEqualsRule struct {
 	V2 string
 }
 
-func (r StringLessThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringLessThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -141,7 +142,7 @@ type StringLessThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r StringLessThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringLessThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -160,7 +161,7 @@ type StringGreaterThanRule struct {
 	V2 string
 }
 
-func (r StringGreaterThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringGreaterThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -174,7 +175,7 @@ type StringGreaterThanPathRule struct {
 	V2 Path
 }
 
-func (r StringGreaterThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringGreaterThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -193,7 +194,7 @@ type StringGreaterThanEqualsRule struct {
 	V2 string
 }
 
-func (r StringGreaterThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringGreaterThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -207,7 +208,7 @@ type StringGreaterThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r StringGreaterThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringGreaterThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -228,7 +229,7 @@ type StringMatchesRule struct {
 
 var ErrOpenBackslashFound = errors.New("open backslash found")
 
-func (r StringMatchesRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r StringMatchesRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetString(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -289,7 +290,7 @@ type NumericEqualsRule struct {
 	V2 float64
 }
 
-func (r NumericEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -303,7 +304,7 @@ type NumericEqualsPathRule struct {
 	V2 Path
 }
 
-func (r NumericEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -322,7 +323,7 @@ type NumericLessThanRule struct {
 	V2 float64
 }
 
-func (r NumericLessThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericLessThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -336,7 +337,7 @@ type NumericLessThanPathRule struct {
 	V2 Path
 }
 
-func (r NumericLessThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericLessThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -355,7 +356,7 @@ type NumericLessThanEqualsRule struct {
 	V2 float64
 }
 
-func (r NumericLessThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericLessThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -369,7 +370,7 @@ type NumericLessThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r NumericLessThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericLessThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -388,7 +389,7 @@ type NumericGreaterThanRule struct {
 	V2 float64
 }
 
-func (r NumericGreaterThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericGreaterThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -402,7 +403,7 @@ type NumericGreaterThanPathRule struct {
 	V2 Path
 }
 
-func (r NumericGreaterThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericGreaterThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -421,7 +422,7 @@ type NumericGreaterThanEqualsRule struct {
 	V2 float64
 }
 
-func (r NumericGreaterThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericGreaterThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -435,7 +436,7 @@ type NumericGreaterThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r NumericGreaterThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r NumericGreaterThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetNumeric(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -454,7 +455,7 @@ type BooleanEqualsRule struct {
 	V2 bool
 }
 
-func (r BooleanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r BooleanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetBool(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -468,7 +469,7 @@ type BooleanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r BooleanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r BooleanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetBool(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -487,7 +488,7 @@ type TimestampEqualsRule struct {
 	V2 Timestamp
 }
 
-func (r TimestampEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -501,7 +502,7 @@ type TimestampEqualsPathRule struct {
 	V2 Path
 }
 
-func (r TimestampEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -520,7 +521,7 @@ type TimestampLessThanRule struct {
 	V2 Timestamp
 }
 
-func (r TimestampLessThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampLessThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -534,7 +535,7 @@ type TimestampLessThanPathRule struct {
 	V2 Path
 }
 
-func (r TimestampLessThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampLessThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -553,7 +554,7 @@ type TimestampLessThanEqualsRule struct {
 	V2 Timestamp
 }
 
-func (r TimestampLessThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampLessThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -567,7 +568,7 @@ type TimestampLessThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r TimestampLessThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampLessThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -586,7 +587,7 @@ type TimestampGreaterThanRule struct {
 	V2 Timestamp
 }
 
-func (r TimestampGreaterThanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampGreaterThanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -600,7 +601,7 @@ type TimestampGreaterThanPathRule struct {
 	V2 Path
 }
 
-func (r TimestampGreaterThanPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampGreaterThanPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -619,7 +620,7 @@ type TimestampGreaterThanEqualsRule struct {
 	V2 Timestamp
 }
 
-func (r TimestampGreaterThanEqualsRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampGreaterThanEqualsRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -633,7 +634,7 @@ type TimestampGreaterThanEqualsPathRule struct {
 	V2 Path
 }
 
-func (r TimestampGreaterThanEqualsPathRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r TimestampGreaterThanEqualsPathRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v1, err := GetTimestamp(coj, input, r.V1)
 	if err != nil {
 		return false, err
@@ -651,7 +652,7 @@ type IsNullRule struct {
 	V1 Path
 }
 
-func (r IsNullRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsNullRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, err
@@ -664,7 +665,7 @@ type IsPresentRule struct {
 	V1 Path
 }
 
-func (r IsPresentRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsPresentRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	_, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, nil
@@ -677,7 +678,7 @@ type IsNumericRule struct {
 	V1 Path
 }
 
-func (r IsNumericRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsNumericRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, err
@@ -695,7 +696,7 @@ type IsStringRule struct {
 	V1 Path
 }
 
-func (r IsStringRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsStringRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, err
@@ -709,7 +710,7 @@ type IsBooleanRule struct {
 	V1 Path
 }
 
-func (r IsBooleanRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsBooleanRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, err
@@ -723,7 +724,7 @@ type IsTimestampRule struct {
 	V1 Path
 }
 
-func (r IsTimestampRule) Eval(coj *CtxObj, input interface{}) (bool, error) {
+func (r IsTimestampRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
 	v, err := UnjoinByPath(coj, input, &r.V1)
 	if err != nil {
 		return false, err
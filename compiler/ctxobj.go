@@ -1,7 +1,8 @@
 package compiler
 
 type CtxObj struct {
-	v interface{}
+	v    interface{}
+	vars *Variables
 }
 
 func (c *CtxObj) SetByString(path string, val interface{}) (*CtxObj, error) {
@@ -13,17 +14,19 @@ func (c *CtxObj) SetByString(path string, val interface{}) (*CtxObj, error) {
 	return c.Set(&p, val)
 }
 
+// Set returns a new CtxObj with val placed at path. c is never modified.
 func (c *CtxObj) Set(path *Path, val interface{}) (*CtxObj, error) {
-	if c.v == nil {
-		c.v = make(map[string]interface{})
+	base := c.v
+	if base == nil {
+		base = make(map[string]interface{})
 	}
 
-	v, err := JoinByPath(c, c.v, val, path)
+	v, err := JoinByPath(c, base, val, path)
 	if err != nil {
 		return nil, err
 	}
 
-	return &CtxObj{v}, nil
+	return &CtxObj{v: v, vars: c.vars}, nil
 }
 
 func (c *CtxObj) SetAll(val interface{}) (*CtxObj, error) {
@@ -66,6 +69,23 @@ func (c *CtxObj) GetAll() interface{} {
 	return c.v
 }
 
+// WithVariables returns a copy of c, whose paths starting with $name refer to vars.
+// The variables are not a part of the context object, so they are not in GetAll.
+func (c *CtxObj) WithVariables(vars *Variables) *CtxObj {
+	res := &CtxObj{vars: vars}
+	if c != nil {
+		res.v = c.v
+	}
+	return res
+}
+
+func (c *CtxObj) Variables() *Variables {
+	if c == nil {
+		return nil
+	}
+	return c.vars
+}
+
 func (c *CtxObj) Del(key string) {
 	/*
 		c.mu.Lock()
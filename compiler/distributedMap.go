@@ -0,0 +1,209 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The resources of ItemReader and ResultWriter.
+// Amazon S3 is emulated with a local directory, whose subdirectories are the buckets.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-itemreader.html
+const (
+	ItemReaderResourceGetObject     = "arn:aws:states:::s3:getObject"
+	ItemReaderResourceListObjectsV2 = "arn:aws:states:::s3:listObjectsV2"
+	ResultWriterResourcePutObject   = "arn:aws:states:::s3:putObject"
+)
+
+// The values of ReaderConfig.
+const (
+	InputTypeJSON  = "JSON"
+	InputTypeJSONL = "JSONL"
+	InputTypeCSV   = "CSV"
+
+	CSVHeaderLocationFirstRow = "FIRST_ROW"
+	CSVHeaderLocationGiven    = "GIVEN"
+)
+
+var csvDelimiters = map[string]rune{
+	"":          ',',
+	"COMMA":     ',',
+	"PIPE":      '|',
+	"SEMICOLON": ';',
+	"SPACE":     ' ',
+	"TAB":       '\t',
+}
+
+var (
+	ErrInvalidItemReader   = errors.New("invalid ItemReader")
+	ErrInvalidItemBatcher  = errors.New("invalid ItemBatcher")
+	ErrInvalidResultWriter = errors.New("invalid ResultWriter")
+)
+
+// resourceArgs are the Parameters of a resource with JSONPath, or the Arguments with JSONata.
+type resourceArgs struct {
+	RawParameters interface{} `json:"Parameters"`
+	Parameters    *PayloadTemplate
+	RawArguments  interface{} `json:"Arguments"`
+	Arguments     *JSONataTemplate
+}
+
+func (args resourceArgs) decode(ql string) (resourceArgs, error) {
+	if ql == QueryLanguageJSONata {
+		if args.RawParameters != nil {
+			return resourceArgs{}, unsupportedFieldError("Parameters", ql)
+		}
+		if args.RawArguments != nil {
+			v, err := NewJSONataTemplate(args.RawArguments)
+			if err != nil {
+				return resourceArgs{}, fmt.Errorf("invalid Arguments: %w", err)
+			}
+			args.Arguments = v
+		}
+		return args, nil
+	}
+
+	if args.RawArguments != nil {
+		return resourceArgs{}, unsupportedFieldError("Arguments", ql)
+	}
+	if args.RawParameters != nil {
+		v, err := NewPayloadTemplate(args.RawParameters)
+		if err != nil {
+			return resourceArgs{}, fmt.Errorf("invalid Parameters: %w", err)
+		}
+		args.Parameters = v
+	}
+	return args, nil
+}
+
+// ItemReader reads the items of a Map state from a file, instead of the input of the state.
+type ItemReader struct {
+	Resource     string       `json:"Resource"`
+	ReaderConfig ReaderConfig `json:"ReaderConfig"`
+	resourceArgs
+}
+
+type ReaderConfig struct {
+	InputType         string   `json:"InputType"`
+	CSVHeaderLocation string   `json:"CSVHeaderLocation"`
+	CSVHeaders        []string `json:"CSVHeaders"`
+	CSVDelimiter      string   `json:"CSVDelimiter"`
+	MaxItems          int      `json:"MaxItems"`
+}
+
+// Delimiter returns the delimiter of the CSV file.
+func (config ReaderConfig) Delimiter() rune {
+	return csvDelimiters[config.CSVDelimiter]
+}
+
+func (reader ItemReader) decode(ql string) (*ItemReader, error) {
+	args, err := reader.resourceArgs.decode(ql)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidItemReader, err)
+	}
+	reader.resourceArgs = args
+
+	config := reader.ReaderConfig
+	switch reader.Resource {
+	case ItemReaderResourceGetObject:
+		switch config.InputType {
+		case InputTypeJSON, InputTypeJSONL:
+		case InputTypeCSV:
+			switch config.CSVHeaderLocation {
+			case "", CSVHeaderLocationFirstRow:
+				if len(config.CSVHeaders) != 0 {
+					return nil, fmt.Errorf("%w: 'CSVHeaders' is only for 'CSVHeaderLocation' GIVEN", ErrInvalidItemReader)
+				}
+			case CSVHeaderLocationGiven:
+				if len(config.CSVHeaders) == 0 {
+					return nil, fmt.Errorf("%w: 'CSVHeaders' is needed", ErrInvalidItemReader)
+				}
+			default:
+				return nil, fmt.Errorf("%w: unknown 'CSVHeaderLocation': %s", ErrInvalidItemReader, config.CSVHeaderLocation)
+			}
+			if _, ok := csvDelimiters[config.CSVDelimiter]; !ok {
+				return nil, fmt.Errorf("%w: unknown 'CSVDelimiter': %s", ErrInvalidItemReader, config.CSVDelimiter)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown 'InputType': %s", ErrInvalidItemReader, config.InputType)
+		}
+	case ItemReaderResourceListObjectsV2:
+		if config.InputType != "" {
+			return nil, fmt.Errorf("%w: 'InputType' is not supported by %s", ErrInvalidItemReader, reader.Resource)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown 'Resource': %s", ErrInvalidItemReader, reader.Resource)
+	}
+
+	if config.MaxItems < 0 {
+		return nil, fmt.Errorf("%w: 'MaxItems' must not be negative", ErrInvalidItemReader)
+	}
+
+	return &reader, nil
+}
+
+// ItemBatcher groups the items of a Map state into batches, each of which is the input of an iteration:
+// {"BatchInput": <BatchInput>, "Items": [<item>, ...]}
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-itembatcher.html
+type ItemBatcher struct {
+	MaxItemsPerBatch      int         `json:"MaxItemsPerBatch"`
+	MaxInputBytesPerBatch int         `json:"MaxInputBytesPerBatch"`
+	RawBatchInput         interface{} `json:"BatchInput"`
+	// BatchInput is a payload template with JSONPath.
+	BatchInput *PayloadTemplate
+	// BatchInputExpr is BatchInput with JSONata.
+	BatchInputExpr *JSONataTemplate
+}
+
+func (batcher ItemBatcher) decode(ql string) (*ItemBatcher, error) {
+	if batcher.MaxItemsPerBatch < 0 || batcher.MaxInputBytesPerBatch < 0 {
+		return nil, fmt.Errorf("%w: the maximum must not be negative", ErrInvalidItemBatcher)
+	}
+	if batcher.MaxItemsPerBatch == 0 && batcher.MaxInputBytesPerBatch == 0 {
+		return nil, fmt.Errorf("%w: 'MaxItemsPerBatch' or 'MaxInputBytesPerBatch' is needed", ErrInvalidItemBatcher)
+	}
+
+	if batcher.RawBatchInput == nil {
+		return &batcher, nil
+	}
+
+	if _, ok := batcher.RawBatchInput.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%w: 'BatchInput' must be an object", ErrInvalidItemBatcher)
+	}
+
+	if ql == QueryLanguageJSONata {
+		v, err := NewJSONataTemplate(batcher.RawBatchInput)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid BatchInput: %v", ErrInvalidItemBatcher, err)
+		}
+		batcher.BatchInputExpr = v
+		return &batcher, nil
+	}
+
+	v, err := NewPayloadTemplate(batcher.RawBatchInput)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid BatchInput: %v", ErrInvalidItemBatcher, err)
+	}
+	batcher.BatchInput = v
+	return &batcher, nil
+}
+
+// ResultWriter writes the results of the iterations of a Map state to files, instead of the output of the state.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-resultwriter.html
+type ResultWriter struct {
+	Resource string `json:"Resource"`
+	resourceArgs
+}
+
+func (writer ResultWriter) decode(ql string) (*ResultWriter, error) {
+	if writer.Resource != ResultWriterResourcePutObject {
+		return nil, fmt.Errorf("%w: unknown 'Resource': %s", ErrInvalidResultWriter, writer.Resource)
+	}
+
+	args, err := writer.resourceArgs.decode(ql)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResultWriter, err)
+	}
+	writer.resourceArgs = args
+
+	return &writer, nil
+}
@@ -0,0 +1,47 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCompile_distributedMap(t *testing.T) {
+	const iterator = `"Iterator": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}`
+	tests := []struct {
+		name    string
+		fields  string
+		wantErr error
+	}{
+		{"valid", `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVDelimiter": "PIPE"}, "Parameters": {"Bucket": "b", "Key.$": "$.key"}},
+			"ItemBatcher": {"MaxItemsPerBatch": 10, "BatchInput": {"a": 1}},
+			"ResultWriter": {"Resource": "arn:aws:states:::s3:putObject", "Parameters": {"Bucket": "b", "Prefix": "p"}},
+			"ToleratedFailurePercentage": 10, "ToleratedFailureCount": 0`, nil},
+		{"list objects", `"ItemReader": {"Resource": "arn:aws:states:::s3:listObjectsV2", "Parameters": {"Bucket": "b"}}`, nil},
+		{"unknown resource", `"ItemReader": {"Resource": "arn:aws:states:::s3:deleteObject"}`, ErrInvalidItemReader},
+		{"unknown input type", `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "XML"}}`, ErrInvalidItemReader},
+		{"no headers", `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "GIVEN"}}`, ErrInvalidItemReader},
+		{"unknown delimiter", `"ItemReader": {"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVDelimiter": "COLON"}}`, ErrInvalidItemReader},
+		{"ItemsPath with ItemReader", `"ItemsPath": "$.items", "ItemReader": {"Resource": "arn:aws:states:::s3:listObjectsV2"}`, ErrInvalidItemReader},
+		{"Arguments with JSONPath", `"ItemReader": {"Resource": "arn:aws:states:::s3:listObjectsV2", "Arguments": {}}`, ErrInvalidItemReader},
+		{"no maximum", `"ItemBatcher": {"BatchInput": {}}`, ErrInvalidItemBatcher},
+		{"unknown writer resource", `"ResultWriter": {"Resource": "arn:aws:states:::s3:getObject"}`, ErrInvalidResultWriter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", ` + tt.fields + `, ` + iterator + `, "End": true}}}`
+			_, err := Compile(context.Background(), bytes.NewBufferString(asl))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Compile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, fields := range []string{`"ToleratedFailurePercentage": 101`, `"ToleratedFailureCount": -1`} {
+		asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", ` + fields + `, ` + iterator + `, "End": true}}}`
+		if _, err := Compile(context.Background(), bytes.NewBufferString(asl)); err == nil {
+			t.Errorf("Compile() succeeded with %s", fields)
+		}
+	}
+}
@@ -1,9 +1,20 @@
 package compiler
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidFailState = errors.New("invalid fail state")
+
 type RawFailState struct {
 	CommonState1
-	Cause string `json:"Cause"`
-	Error string `json:"Error"`
+	Cause     string  `json:"Cause"`
+	CausePath *string `json:"CausePath"`
+	Error     string  `json:"Error"`
+	ErrorPath *string `json:"ErrorPath"`
 }
 
 func (raw RawFailState) decode(name string) (State, error) {
@@ -11,12 +22,125 @@ func (raw RawFailState) decode(name string) (State, error) {
 	if err != nil {
 		return nil, err
 	}
-	raw.CommonState1 = s.Common().CommonState1
-	return FailState(raw), nil
+
+	if raw.Error != "" && raw.ErrorPath != nil {
+		return nil, fmt.Errorf("%w: 'Error' and 'ErrorPath' are exclusive", ErrInvalidFailState)
+	}
+
+	if raw.Cause != "" && raw.CausePath != nil {
+		return nil, fmt.Errorf("%w: 'Cause' and 'CausePath' are exclusive", ErrInvalidFailState)
+	}
+
+	res := FailState{
+		CommonState1: s.Common().CommonState1,
+		Cause:        raw.Cause,
+		Error:        raw.Error,
+	}
+
+	if raw.IsJSONata() {
+		switch {
+		case raw.ErrorPath != nil:
+			return nil, unsupportedFieldError("ErrorPath", raw.QueryLanguage)
+		case raw.CausePath != nil:
+			return nil, unsupportedFieldError("CausePath", raw.QueryLanguage)
+		}
+
+		// Error and Cause may be JSONata expressions
+		if isJSONataExpr(raw.Error) {
+			v, err := NewJSONataTemplate(raw.Error)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Error: %w", err)
+			}
+			res.ErrorExpr = v
+		}
+		if isJSONataExpr(raw.Cause) {
+			v, err := NewJSONataTemplate(raw.Cause)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Cause: %w", err)
+			}
+			res.CauseExpr = v
+		}
+	}
+
+	if raw.CausePath != nil {
+		v, err := NewPathOrIntrinsic(*raw.CausePath)
+		if err != nil {
+			return nil, err
+		}
+		res.CausePath = &v
+	}
+
+	if raw.ErrorPath != nil {
+		v, err := NewPathOrIntrinsic(*raw.ErrorPath)
+		if err != nil {
+			return nil, err
+		}
+		res.ErrorPath = &v
+	}
+
+	return res, nil
 }
 
 type FailState struct {
 	CommonState1
-	Cause string
-	Error string
+	Cause     string
+	CausePath *PathOrIntrinsic
+	CauseExpr *JSONataTemplate
+	Error     string
+	ErrorPath *PathOrIntrinsic
+	ErrorExpr *JSONataTemplate
+}
+
+// PathOrIntrinsic is the value of a field such as ErrorPath,
+// which is either a reference path or an intrinsic function.
+type PathOrIntrinsic struct {
+	Path      *ReferencePath
+	Intrinsic *IntrinsicFunction
+}
+
+func NewPathOrIntrinsic(str string) (PathOrIntrinsic, error) {
+	if strings.HasPrefix(str, "$") {
+		p, err := NewReferencePath(str)
+		if err != nil {
+			return PathOrIntrinsic{}, err
+		}
+		return PathOrIntrinsic{Path: &p}, nil
+	}
+
+	f, err := ParseIntrinsicFunction(str)
+	if err != nil {
+		return PathOrIntrinsic{}, fmt.Errorf("neither a reference path nor an intrinsic function: %w", err)
+	}
+
+	return PathOrIntrinsic{Intrinsic: f}, nil
+}
+
+func (v PathOrIntrinsic) Resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	if v.Path != nil {
+		p := v.Path.Path
+		return UnjoinByPath(coj, input, &p)
+	}
+
+	return v.Intrinsic.Eval(ctx, coj, input)
+}
+
+func (v PathOrIntrinsic) ResolveString(ctx context.Context, coj *CtxObj, input interface{}) (string, error) {
+	got, err := v.Resolve(ctx, coj, input)
+	if err != nil {
+		return "", err
+	}
+
+	str, ok := got.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid field value (must be string) : [%s]=[%v]", v, got)
+	}
+
+	return str, nil
+}
+
+func (v PathOrIntrinsic) String() string {
+	if v.Path != nil {
+		return v.Path.String()
+	}
+	return v.Intrinsic.String()
 }
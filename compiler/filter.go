@@ -2,34 +2,147 @@ package compiler
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
-	"github.com/ohler55/ojg"
 	"github.com/ohler55/ojg/jp"
-	"github.com/ohler55/ojg/sen"
-	"github.com/w-haibara/kakemoti/intrinsic"
 )
 
+// JoinByPath returns v1 with v2 placed at path.
+// v1 is never modified: the objects and the arrays on the path are copied,
+// and the rest of v1 is shared with the result (copy-on-write).
+// The compiled path is never modified either, so it can be shared by concurrent executions.
 func JoinByPath(coj *CtxObj, v1, v2 interface{}, path *Path) (interface{}, error) {
+	if path.Variable != "" {
+		return nil, fmt.Errorf("the variable can not be set by the path, use Assign instead (path=[%s])", path)
+	}
+
 	if path.IsContextPath {
-		path.IsContextPath = false
-		return JoinByPath(coj, v1, coj.GetAll(), path)
+		p := *path
+		p.IsContextPath = false
+		return JoinByPath(coj, v1, coj.GetAll(), &p)
+	}
+
+	// "$" replaces the whole value
+	if len(path.Expr) == 1 {
+		if _, ok := path.Expr[0].(jp.Root); ok {
+			return v2, nil
+		}
+	}
+
+	if !isCopyOnWritePath(path.Expr) {
+		// the path may match some nodes, so the whole value is copied
+		v := CopyJSON(v1)
+		if err := path.Expr.Set(v, v2); err != nil {
+			return nil, fmt.Errorf("path.Set(rawinput, result) failed (path=[%s]) : %v", path, err)
+		}
+		return v, nil
 	}
 
-	if err := path.Expr.Set(v1, v2); err != nil {
+	v, err := setByPath(v1, path.Expr, 0, v2)
+	if err != nil {
 		return nil, fmt.Errorf("path.Set(rawinput, result) failed (path=[%s]) : %v", path, err)
 	}
 
-	return v1, nil
+	return v, nil
+}
+
+// isCopyOnWritePath reports whether x points to a single node,
+// which can be set by copying only the objects and the arrays on the path.
+func isCopyOnWritePath(x jp.Expr) bool {
+	for _, f := range x {
+		switch f.(type) {
+		case jp.Root, jp.Bracket, jp.Child, jp.Nth:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setByPath returns a copy of v whose node at x[i:] is replaced with val.
+// As with jp.Expr.Set, missing objects on the path are created.
+func setByPath(v interface{}, x jp.Expr, i int, val interface{}) (interface{}, error) {
+	if i == len(x) {
+		return val, nil
+	}
+
+	switch f := x[i].(type) {
+	case jp.Child:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can not follow a %T at '%s'", v, x[:i])
+		}
+
+		child, ok := m[string(f)]
+		if !ok && i+1 < len(x) {
+			switch next := x[i+1].(type) {
+			case jp.Child:
+				child = map[string]interface{}{}
+			case jp.Nth:
+				if next < 0 {
+					return nil, fmt.Errorf("can not deduce the length of the array to add at '%s'", x[:i+1])
+				}
+				child = make([]interface{}, int(next)+1)
+			default:
+				return nil, fmt.Errorf("can not deduce what element to add at '%s'", x[:i+1])
+			}
+		}
+
+		nv, err := setByPath(child, x, i+1, val)
+		if err != nil {
+			return nil, err
+		}
+
+		out := make(map[string]interface{}, len(m)+1)
+		for k, e := range m {
+			out[k] = e
+		}
+		out[string(f)] = nv
+		return out, nil
+	case jp.Nth:
+		s, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can not follow a %T at '%s'", v, x[:i])
+		}
+
+		n := int(f)
+		if n < 0 {
+			n += len(s)
+		}
+		if n < 0 || n >= len(s) {
+			return nil, fmt.Errorf("index out of range at '%s'", x[:i+1])
+		}
+
+		nv, err := setByPath(s[n], x, i+1, val)
+		if err != nil {
+			return nil, err
+		}
+
+		out := make([]interface{}, len(s))
+		copy(out, s)
+		out[n] = nv
+		return out, nil
+	default:
+		// jp.Root and jp.Bracket do not move
+		return setByPath(v, x, i+1, val)
+	}
 }
 
 func UnjoinByPath(coj *CtxObj, v interface{}, path *Path) (interface{}, error) {
+	if path.Variable != "" {
+		val, ok := coj.Variables().Get(path.Variable)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUndefinedVariable, path.Variable)
+		}
+		p := *path
+		p.Variable = ""
+		return UnjoinByPath(coj, val, &p)
+	}
+
 	if path.IsContextPath {
-		path.IsContextPath = false
-		return UnjoinByPath(coj, coj.GetAll(), path)
+		p := *path
+		p.IsContextPath = false
+		return UnjoinByPath(coj, coj.GetAll(), &p)
 	}
 
 	nodes := path.Expr.Get(v)
@@ -60,12 +173,15 @@ func GetNumeric(coj *CtxObj, input interface{}, path Path) (float64, error) {
 		return 0, err
 	}
 
-	v1, ok := v.(float64)
-	if !ok {
+	// the results of the intrinsic functions, such as States.MathAdd, are integers
+	switch v1 := v.(type) {
+	case float64:
+		return v1, nil
+	case int:
+		return float64(v1), nil
+	default:
 		return 0, fmt.Errorf("invalid field value (must be float64) : [%s]=[%v]", path.String(), v1)
 	}
-
-	return v1, nil
 }
 
 func GetBool(coj *CtxObj, input interface{}, path Path) (bool, error) {
@@ -135,266 +251,6 @@ func FilterByOutputPath(coj *CtxObj, state State, output interface{}) (interface
 	return UnjoinByPath(coj, output, v.OutputPath)
 }
 
-func SetObjectByKey(v1, v2 interface{}, key string) (interface{}, error) {
-	if err := jp.C(key).Set(v1, v2); err != nil {
-		return nil, fmt.Errorf("jp.N(0).C(key).Set(v1, v2) failed: %v", err)
-	}
-
-	return v1, nil
-}
-
-func resolvePayload(ctx context.Context, coj *CtxObj, input interface{}, payload map[string]interface{}) (map[string]interface{}, error) {
-	out := make(map[string]interface{})
-	for key, val := range payload {
-		temp, ok := val.(map[string]interface{})
-		if !ok {
-			out[key] = val
-			continue
-		}
-
-		v, err := ResolvePayload(ctx, coj, input, temp)
-		if err != nil {
-			return nil, err
-		}
-		out[key] = v
-	}
-	return out, nil
-}
-
-func resolvePayloadByPath(coj *CtxObj, input interface{}, payload map[string]interface{}) (map[string]interface{}, error) {
-	out := make(map[string]interface{})
-	for key, val := range payload {
-		if !strings.HasSuffix(key, ".$") {
-			out[key] = val
-			continue
-		}
-
-		path, ok := val.(string)
-		if !ok {
-			return nil, fmt.Errorf("value of payload template is not string: %v", path)
-		}
-
-		if !strings.HasPrefix(path, "$") {
-			out[key] = path
-			continue
-		}
-
-		p, err := NewPath(path)
-		if err != nil {
-			return nil, err
-		}
-
-		got, err := UnjoinByPath(coj, input, &p)
-		if err != nil {
-			return nil, err
-		}
-
-		v, err := SetObjectByKey(payload, got, strings.TrimSuffix(key, ".$"))
-		if err != nil {
-			return nil, err
-		}
-
-		v1, ok := v.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("result of SetObjectByKey() is invarid: %s", sen.String(v, &ojg.Options{Sort: true}))
-		}
-
-		delete(v1, key)
-		out = v1
-	}
-
-	return out, nil
-}
-
-var ErrIntrinsicFunctionFailed = errors.New("intrinsic function failed")
-
-func parseIntrinsicFunction(ctx context.Context, coj *CtxObj, fnstr string, input interface{}) (string, []interface{}, error) {
-	var ErrParseFailed = errors.New("parseIntrinsicFunction() failed")
-
-	fnAndArgsStr := func() (string, string, error) {
-		n1 := strings.Index(fnstr, "(")
-		if n1 < 1 && n1+1 < len(fnstr) {
-			return "", "", ErrParseFailed
-		}
-
-		n2 := strings.LastIndex(fnstr, ")")
-		if n2 < 2 || n1 >= n2 {
-			return "", "", ErrParseFailed
-		}
-
-		return fnstr[:n1], fnstr[n1+1 : n2], nil
-	}
-
-	resolvePath := func(path string) (interface{}, error) {
-		p, err := NewPath(path)
-		if err != nil {
-			return nil, err
-		}
-		v, err := UnjoinByPath(coj, input, &p)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
-	}
-
-	parseArg := func(str string) (interface{}, error) {
-		b1 := strings.HasPrefix(str, "'")
-		b2 := strings.HasSuffix(str, "'")
-		if (b1 && !b2) || (!b1 && b2) {
-			return nil, ErrParseFailed
-		}
-
-		if b1 && b2 {
-			return strings.TrimPrefix(strings.TrimSuffix(str, "'"), "'"), nil
-		}
-
-		if strings.HasPrefix(str, "$") {
-			v, err := resolvePath(str)
-			if err != nil {
-				return nil, err
-			}
-			return v, nil
-		}
-
-		switch str {
-		case "true":
-			return true, nil
-		case "false":
-			return false, nil
-		case "null":
-			return "null", nil
-		}
-
-		if v, err := strconv.Atoi(str); err == nil {
-			return v, nil
-		}
-		if v, err := strconv.ParseFloat(str, 64); err == nil {
-			return v, nil
-		}
-
-		fn, args, err := parseIntrinsicFunction(ctx, coj, str, input)
-		if err != nil {
-			return nil, err
-		}
-		result, err := intrinsic.Do(ctx, fn, args)
-		if err != nil {
-			return nil, err
-		}
-
-		return result, nil
-	}
-
-	parseArgs := func(str string) ([]interface{}, error) {
-		args := []string{""}
-		parenCount := 0
-		quoted := false
-		for _, s := range str {
-			if (parenCount > 0 && s != '(' && s != ')') || (quoted && s != '\'') {
-				args[len(args)-1] += string(s)
-				continue
-			}
-
-			if s == ',' {
-				args = append(args, "")
-				continue
-			}
-
-			args[len(args)-1] += string(s)
-
-			if s == '(' {
-				parenCount++
-				continue
-			}
-			if s == ')' {
-				parenCount--
-				continue
-			}
-
-			if s == '\'' {
-				quoted = !quoted
-				continue
-			}
-		}
-
-		result := make([]interface{}, len(args))
-		for i, arg := range args {
-			arg = strings.TrimSpace(arg)
-
-			s, err := parseArg(arg)
-			if err != nil {
-				return nil, err
-			}
-
-			result[i] = s
-		}
-		return result, nil
-	}
-
-	fn, argsstr, err := fnAndArgsStr()
-	if err != nil {
-		return "", nil, fmt.Errorf("%s: %w", err.Error(), ErrIntrinsicFunctionFailed)
-	}
-
-	args, err := parseArgs(argsstr)
-	if err != nil {
-		return "", nil, fmt.Errorf("%s: %w", err.Error(), ErrIntrinsicFunctionFailed)
-	}
-
-	return fn, args, nil
-}
-
-func resolveIntrinsicFunction(ctx context.Context, coj *CtxObj, input interface{}, payload map[string]interface{}) (map[string]interface{}, error) {
-	out := make(map[string]interface{})
-	for key, val := range payload {
-		if !strings.HasSuffix(key, ".$") {
-			out[key] = val
-			continue
-		}
-
-		fnstr, ok := val.(string)
-		if !ok {
-			return nil, fmt.Errorf("value of payload template is not string: %v", fnstr)
-		}
-
-		fn, args, err := parseIntrinsicFunction(ctx, coj, fnstr, input)
-		if err != nil {
-			return nil, err
-		}
-
-		result, err := intrinsic.Do(ctx, fn, args)
-		if err != nil {
-			return nil, err
-		}
-
-		out[strings.TrimSuffix(key, ".$")] = result
-	}
-
-	return out, nil
-}
-
-func ResolvePayload(ctx context.Context, coj *CtxObj, input interface{}, payload interface{}) (interface{}, error) {
-	v, ok := payload.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid type of payload: [%#v]", payload)
-	}
-	payload1, err := resolvePayload(ctx, coj, input, v)
-	if err != nil {
-		return nil, err
-	}
-
-	payload2, err := resolvePayloadByPath(coj, input, payload1)
-	if err != nil {
-		return nil, err
-	}
-
-	payload3, err := resolveIntrinsicFunction(ctx, coj, input, payload2)
-	if err != nil {
-		return nil, err
-	}
-
-	return payload3, err
-}
-
 func FilterByParameters(ctx context.Context, coj *CtxObj, state State, input interface{}) (interface{}, error) {
 	if state.FieldsType() < FieldsType4 {
 		return input, nil
@@ -405,7 +261,7 @@ func FilterByParameters(ctx context.Context, coj *CtxObj, state State, input int
 		return input, nil
 	}
 
-	return ResolvePayload(ctx, coj, input, v.Parameters)
+	return v.Parameters.Resolve(ctx, coj, input)
 }
 
 func FilterByResultSelector(ctx context.Context, coj *CtxObj, state State, result interface{}) (interface{}, error) {
@@ -418,5 +274,5 @@ func FilterByResultSelector(ctx context.Context, coj *CtxObj, state State, resul
 		return result, nil
 	}
 
-	return ResolvePayload(ctx, coj, result, v.ResultSelector)
+	return v.ResultSelector.Resolve(ctx, coj, result)
 }
@@ -1,41 +1,93 @@
 package compiler
 
 import (
-	"context"
-	"reflect"
+	"encoding/json"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
-func Test_parseIntrinsicFunction(t *testing.T) {
+func TestJoinByPath(t *testing.T) {
 	tests := []struct {
-		name     string
-		fnstr    string
-		input    interface{}
-		wantName string
-		wantArgs []interface{}
+		name string
+		path string
+		v1   string
+		v2   interface{}
+		want string
 	}{
-		{"quoted string", "a('x')", nil, "a", []interface{}{"x"}},
-		{"int", "a(1)", nil, "a", []interface{}{1}},
-		{"float", "a(3.14)", nil, "a", []interface{}{3.14}},
-		{"null", "a(null)", nil, "a", []interface{}{"null"}},
-		{"path", "a($.aaa)", map[string]interface{}{"aaa": 111}, "a", []interface{}{111}},
-		{"all", "abc_XYZ.123('x', 1, 3.14, null, $.aaa)", map[string]interface{}{"aaa": 111}, "abc_XYZ.123", []interface{}{"x", 1, 3.14, "null", 111}},
-		{"nested", "States.Format('[{}][{}]', States.Format('[{}]', $.aaa), $.bbb)", map[string]interface{}{"aaa": 111, "bbb": 222}, "States.Format", []interface{}{"[{}][{}]", "[111]", 222}},
-		{"sample1", "States.Format('Hello, my name is {}.', $.name)", map[string]interface{}{"name": "Alice"}, "States.Format", []interface{}{"Hello, my name is {}.", "Alice"}},
+		{"root", "$", `{"a": 1}`, "x", `"x"`},
+		{"child", "$.b", `{"a": {"c": 1}}`, "x", `{"a": {"c": 1}, "b": "x"}`},
+		{"nested child", "$.a.c", `{"a": {"c": 1, "d": 2}, "b": [1]}`, "x", `{"a": {"c": "x", "d": 2}, "b": [1]}`},
+		{"missing objects", "$.a.b.c", `{}`, "x", `{"a": {"b": {"c": "x"}}}`},
+		{"missing array", "$.a[1]", `{}`, "x", `{"a": [null, "x"]}`},
+		{"nth", "$.a[1].b", `{"a": [{"b": 1}, {"b": 2}]}`, "x", `{"a": [{"b": 1}, {"b": "x"}]}`},
+		{"negative nth", "$.a[-1]", `{"a": [1, 2]}`, "x", `{"a": [1, "x"]}`},
+		{"bracket", "$['a']['b']", `{"a": {"b": 1}}`, "x", `{"a": {"b": "x"}}`},
+		{"wildcard", "$.a[*].b", `{"a": [{"b": 1}, {"b": 2}]}`, "x", `{"a": [{"b": "x"}, {"b": "x"}]}`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotName, gotArgs, err := parseIntrinsicFunction(context.Background(), &CtxObj{}, tt.fnstr, tt.input)
+			var v1, want interface{}
+			if err := json.Unmarshal([]byte(tt.v1), &v1); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			orig := CopyJSON(v1)
+
+			path := MustNewPath(tt.path)
+			got, err := JoinByPath(nil, v1, tt.v2, &path)
 			if err != nil {
-				t.Errorf("parseIntrinsicFunction() error = %v", err)
-				return
+				t.Fatal("JoinByPath() failed:", err)
 			}
-			if gotName != tt.wantName {
-				t.Errorf("parseIntrinsicFunction() got = %#v, want %#v", gotName, tt.wantName)
+			if d := cmp.Diff(got, want); d != "" {
+				t.Errorf("JoinByPath() failed: \n%s", d)
 			}
-			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
-				t.Errorf("parseIntrinsicFunction() got = %#v, want %#v", gotArgs, tt.wantArgs)
+			if d := cmp.Diff(v1, orig); d != "" {
+				t.Errorf("JoinByPath() modified the input: \n%s", d)
 			}
 		})
 	}
 }
+
+func TestJoinByPath_contextPath(t *testing.T) {
+	coj, err := new(CtxObj).SetByString("$.aaa", 111)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := MustNewPath("$$.ctx")
+	for i := 0; i < 2; i++ {
+		got, err := JoinByPath(coj, map[string]interface{}{}, nil, &path)
+		if err != nil {
+			t.Fatal("JoinByPath() failed:", err)
+		}
+		want := map[string]interface{}{"ctx": map[string]interface{}{"aaa": 111}}
+		if d := cmp.Diff(got, want); d != "" {
+			t.Errorf("JoinByPath() failed: \n%s", d)
+		}
+	}
+	if !path.IsContextPath {
+		t.Error("JoinByPath() modified the path")
+	}
+}
+
+func TestCtxObj_Set(t *testing.T) {
+	c1, err := new(CtxObj).SetByString("$.a", map[string]interface{}{"b": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := c1.SetByString("$.a.c", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := cmp.Diff(c1.GetAll(), map[string]interface{}{"a": map[string]interface{}{"b": 1}}); d != "" {
+		t.Errorf("Set() modified the receiver: \n%s", d)
+	}
+	if d := cmp.Diff(c2.GetAll(), map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": 2}}); d != "" {
+		t.Errorf("Set() failed: \n%s", d)
+	}
+}
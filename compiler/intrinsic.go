@@ -0,0 +1,362 @@
+package compiler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/w-haibara/kakemoti/intrinsic"
+)
+
+var (
+	ErrIntrinsicFunctionFailed = errors.New("intrinsic function failed")
+	ErrInvalidIntrinsic        = errors.New("invalid intrinsic function")
+)
+
+// IntrinsicFunction is an intrinsic function call, such as States.Format('{}', $.name),
+// parsed at compile time.
+// ref: https://states-language.net/#intrinsic-functions
+type IntrinsicFunction struct {
+	Name string
+	args []intrinsicExpr
+	src  string
+}
+
+// intrinsicExpr is an argument of an intrinsic function:
+// a literal, a path, or a nested intrinsic function.
+type intrinsicExpr interface {
+	eval(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error)
+}
+
+type intrinsicLiteral struct {
+	v interface{}
+}
+
+func (e intrinsicLiteral) eval(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	return e.v, nil
+}
+
+type intrinsicPath struct {
+	path Path
+}
+
+func (e intrinsicPath) eval(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	p := e.path
+	return UnjoinByPath(coj, input, &p)
+}
+
+func (f *IntrinsicFunction) eval(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	args := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		v, err := arg.eval(ctx, coj, input)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	return intrinsic.Do(ctx, f.Name, args)
+}
+
+// Eval calls the function with its arguments resolved against the input and the context object.
+func (f *IntrinsicFunction) Eval(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	v, err := f.eval(ctx, coj, input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", err.Error(), ErrIntrinsicFunctionFailed)
+	}
+	return v, nil
+}
+
+func (f *IntrinsicFunction) String() string {
+	return f.src
+}
+
+// ParseIntrinsicFunction parses str into an intrinsic function.
+// The function names, the numbers of the arguments and the literal arguments are checked as well.
+func ParseIntrinsicFunction(str string) (*IntrinsicFunction, error) {
+	tokens, err := lexIntrinsic(str)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidIntrinsic, str, err)
+	}
+
+	p := intrinsicParser{tokens: tokens}
+	f, err := p.parseFunction()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %s", p.peek())
+	}
+	if errors.Is(err, intrinsic.ErrUnknownFunction) || errors.Is(err, intrinsic.ErrInvalidArity) || errors.Is(err, intrinsic.ErrInvalidArgument) {
+		return nil, fmt.Errorf("%s: %w", str, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidIntrinsic, str, err)
+	}
+
+	f.src = str
+	return f, nil
+}
+
+type intrinsicTokenType int
+
+const (
+	intrinsicTokenIdent intrinsicTokenType = iota
+	intrinsicTokenString
+	intrinsicTokenNumber
+	intrinsicTokenPath
+	intrinsicTokenLParen
+	intrinsicTokenRParen
+	intrinsicTokenComma
+)
+
+type intrinsicToken struct {
+	typ intrinsicTokenType
+	val string
+}
+
+func (t intrinsicToken) String() string {
+	if t.typ == intrinsicTokenString {
+		return strconv.Quote(t.val)
+	}
+	return "'" + t.val + "'"
+}
+
+func lexIntrinsic(str string) ([]intrinsicToken, error) {
+	rs := []rune(str)
+	tokens := []intrinsicToken{}
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, intrinsicToken{intrinsicTokenLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, intrinsicToken{intrinsicTokenRParen, ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, intrinsicToken{intrinsicTokenComma, ","})
+			i++
+		case r == '\'':
+			s, n, err := lexIntrinsicString(rs[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, intrinsicToken{intrinsicTokenString, s})
+			i += n
+		case r == '$':
+			n, err := lexIntrinsicPath(rs[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, intrinsicToken{intrinsicTokenPath, string(rs[i : i+n])})
+			i += n
+		case r == '-' || unicode.IsDigit(r):
+			n := 1
+			for i+n < len(rs) && strings.ContainsRune("0123456789.eE+-", rs[i+n]) {
+				n++
+			}
+			tokens = append(tokens, intrinsicToken{intrinsicTokenNumber, string(rs[i : i+n])})
+			i += n
+		case unicode.IsLetter(r) || r == '_':
+			n := 1
+			for i+n < len(rs) && (unicode.IsLetter(rs[i+n]) || unicode.IsDigit(rs[i+n]) || rs[i+n] == '_' || rs[i+n] == '.') {
+				n++
+			}
+			tokens = append(tokens, intrinsicToken{intrinsicTokenIdent, string(rs[i : i+n])})
+			i += n
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at %d", r, i)
+		}
+	}
+	return tokens, nil
+}
+
+// lexIntrinsicString reads a quoted string at the beginning of rs, and returns its value and length.
+// \' and \\ are unescaped, and the other escapes such as \{ are kept for States.Format.
+func lexIntrinsicString(rs []rune) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\':
+			if i+1 >= len(rs) {
+				return "", 0, errors.New("unterminated string")
+			}
+			i++
+			if rs[i] != '\'' && rs[i] != '\\' {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(rs[i])
+		case '\'':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteRune(rs[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// lexIntrinsicPath returns the length of the path at the beginning of rs.
+// The path ends at a comma or a closing parenthesis that is not in brackets, parentheses or quotes.
+func lexIntrinsicPath(rs []rune) (int, error) {
+	depth := 0
+	var quote rune
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if quote != 0 {
+			if r == '\\' {
+				i++
+			} else if r == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch r {
+		case '\'', '"':
+			quote = r
+		case '[', '(':
+			depth++
+		case ']':
+			depth--
+		case ')':
+			if depth == 0 {
+				return i, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return i, nil
+			}
+		default:
+			if depth == 0 && unicode.IsSpace(r) {
+				return i, nil
+			}
+		}
+	}
+
+	if quote != 0 || depth != 0 {
+		return 0, errors.New("unterminated path")
+	}
+	return len(rs), nil
+}
+
+type intrinsicParser struct {
+	tokens []intrinsicToken
+	pos    int
+}
+
+func (p *intrinsicParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *intrinsicParser) peek() intrinsicToken {
+	return p.tokens[p.pos]
+}
+
+func (p *intrinsicParser) expect(typ intrinsicTokenType, what string) (intrinsicToken, error) {
+	if p.done() {
+		return intrinsicToken{}, fmt.Errorf("%s is expected, but reached the end", what)
+	}
+	t := p.peek()
+	if t.typ != typ {
+		return intrinsicToken{}, fmt.Errorf("%s is expected, but got %s", what, t)
+	}
+	p.pos++
+	return t, nil
+}
+
+func (p *intrinsicParser) parseFunction() (*IntrinsicFunction, error) {
+	name, err := p.expect(intrinsicTokenIdent, "a function name")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(intrinsicTokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	f := &IntrinsicFunction{Name: name.val, args: []intrinsicExpr{}}
+	if !p.done() && p.peek().typ == intrinsicTokenRParen {
+		p.pos++
+	} else {
+		for {
+			arg, err := p.parseArg()
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, arg)
+
+			if p.done() {
+				return nil, errors.New("')' is expected, but reached the end")
+			}
+			t := p.peek()
+			p.pos++
+			if t.typ == intrinsicTokenRParen {
+				break
+			}
+			if t.typ != intrinsicTokenComma {
+				return nil, fmt.Errorf("',' or ')' is expected, but got %s", t)
+			}
+		}
+	}
+
+	args := make([]intrinsic.Arg, len(f.args))
+	for i, arg := range f.args {
+		if v, ok := arg.(intrinsicLiteral); ok {
+			args[i] = intrinsic.Arg{Value: v.v, Literal: true}
+		}
+	}
+	if err := intrinsic.Check(f.Name, args); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (p *intrinsicParser) parseArg() (intrinsicExpr, error) {
+	if p.done() {
+		return nil, errors.New("an argument is expected, but reached the end")
+	}
+
+	t := p.peek()
+	switch t.typ {
+	case intrinsicTokenString:
+		p.pos++
+		return intrinsicLiteral{t.val}, nil
+	case intrinsicTokenNumber:
+		p.pos++
+		if v, err := strconv.Atoi(t.val); err == nil {
+			return intrinsicLiteral{v}, nil
+		}
+		v, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", t.val)
+		}
+		return intrinsicLiteral{v}, nil
+	case intrinsicTokenPath:
+		p.pos++
+		path, err := NewPath(t.val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %s: %v", t.val, err)
+		}
+		return intrinsicPath{path}, nil
+	case intrinsicTokenIdent:
+		switch t.val {
+		case "true":
+			p.pos++
+			return intrinsicLiteral{true}, nil
+		case "false":
+			p.pos++
+			return intrinsicLiteral{false}, nil
+		case "null":
+			p.pos++
+			return intrinsicLiteral{nil}, nil
+		}
+		return p.parseFunction()
+	default:
+		return nil, fmt.Errorf("an argument is expected, but got %s", t)
+	}
+}
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/w-haibara/kakemoti/intrinsic"
+)
+
+func TestParseIntrinsicFunction(t *testing.T) {
+	tests := []struct {
+		name  string
+		fnstr string
+		input interface{}
+		want  interface{}
+	}{
+		{"quoted string", "States.Array('x')", nil, []interface{}{"x"}},
+		{"int", "States.Array(1)", nil, []interface{}{1}},
+		{"negative", "States.Array(-1)", nil, []interface{}{-1}},
+		{"float", "States.Array(3.14)", nil, []interface{}{3.14}},
+		{"null", "States.Array(null)", nil, []interface{}{nil}},
+		{"bool", "States.Array(true, false)", nil, []interface{}{true, false}},
+		{"no arguments", "States.Array()", nil, []interface{}{}},
+		{"path", "States.Array($.aaa)", map[string]interface{}{"aaa": 111}, []interface{}{111}},
+		{"path(brackets)", "States.Array($['a,b'], $.c[0])", map[string]interface{}{"a,b": 1, "c": []interface{}{2}}, []interface{}{1, 2}},
+		{"all", "States.Array('x', 1, 3.14, null, $.aaa)", map[string]interface{}{"aaa": 111}, []interface{}{"x", 1, 3.14, nil, 111}},
+		{"escaped quote", `States.Array('it\'s', 'a\\b')`, nil, []interface{}{"it's", `a\b`}},
+		{"comma and parentheses in string", "States.Array('a, b', '(c)', '{}')", nil, []interface{}{"a, b", "(c)", "{}"}},
+		{"nested", "States.Format('[{}][{}]', States.Format('[{}]', $.aaa), $.bbb)", map[string]interface{}{"aaa": 111, "bbb": 222}, "[[111]][222]"},
+		{"escaped brace", `States.Format('\{} {}', 'x')`, nil, `\{} x`},
+		{"format only", "States.Format('x')", nil, "x"},
+		{"sample1", "States.Format('Hello, my name is {}.', $.name)", map[string]interface{}{"name": "Alice"}, "Hello, my name is Alice."},
+		{"json merge", "States.JsonMerge($.a, $.b, false)", map[string]interface{}{"a": map[string]interface{}{"x": 1}, "b": map[string]interface{}{"y": 2}}, map[string]interface{}{"x": 1, "y": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseIntrinsicFunction(tt.fnstr)
+			if err != nil {
+				t.Fatalf("ParseIntrinsicFunction() error = %v", err)
+			}
+			got, err := f.Eval(context.Background(), &CtxObj{}, tt.input)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIntrinsicFunction_error(t *testing.T) {
+	tests := []struct {
+		name    string
+		fnstr   string
+		wantErr error
+	}{
+		{"unknown function", "States.Unknown(1)", intrinsic.ErrUnknownFunction},
+		{"too few arguments", "States.ArrayGetItem($.a)", intrinsic.ErrInvalidArity},
+		{"too many arguments", "States.UUID(1)", intrinsic.ErrInvalidArity},
+		{"nested arity", "States.Format('{}', States.ArrayLength())", intrinsic.ErrInvalidArity},
+		{"deep merge", "States.JsonMerge($.a, $.b, true)", intrinsic.ErrInvalidArgument},
+		{"nested deep merge", "States.JsonToString(States.JsonMerge($.a, $.b, true))", intrinsic.ErrInvalidArgument},
+		{"unterminated string", "States.Array('x)", ErrInvalidIntrinsic},
+		{"unterminated call", "States.Array('x'", ErrInvalidIntrinsic},
+		{"trailing tokens", "States.Array('x') 1", ErrInvalidIntrinsic},
+		{"missing comma", "States.Array('x' 'y')", ErrInvalidIntrinsic},
+		{"bare identifier", "States.Array(x)", ErrInvalidIntrinsic},
+		{"not a call", "States.Array", ErrInvalidIntrinsic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseIntrinsicFunction(tt.fnstr); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseIntrinsicFunction() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompile_intrinsic(t *testing.T) {
+	tests := []struct {
+		name    string
+		asl     string
+		wantErr error
+	}{
+		{"parameters", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {"a": {"b.$": "States.Unknown()"}}, "End": true}}}`, intrinsic.ErrUnknownFunction},
+		{"result selector", `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "ResultSelector": {"a.$": "States.UUID(1)"}, "End": true}}}`, intrinsic.ErrInvalidArity},
+		{"fail", `{"StartAt": "F", "States": {"F": {"Type": "Fail", "CausePath": "States.Format('{}'"}}}`, ErrInvalidIntrinsic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(context.Background(), bytes.NewBufferString(tt.asl)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Compile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package compiler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/w-haibara/kakemoti/jsonata"
+)
+
+// The query languages of a state machine and its states.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/transforming-data.html
+const (
+	QueryLanguageJSONPath = "JSONPath"
+	QueryLanguageJSONata  = "JSONata"
+)
+
+var (
+	ErrInvalidQueryLanguage  = errors.New("invalid QueryLanguage")
+	ErrQueryEvaluationFailed = errors.New("query evaluation failed")
+)
+
+func validateQueryLanguage(ql string) error {
+	switch ql {
+	case "", QueryLanguageJSONPath, QueryLanguageJSONata:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidQueryLanguage, ql)
+	}
+}
+
+// unsupportedFieldError is returned when a field is used with the other query language,
+// such as InputPath with JSONata.
+func unsupportedFieldError(field, ql string) error {
+	if ql == "" {
+		ql = QueryLanguageJSONPath
+	}
+	return fmt.Errorf("%w: '%s' is not supported with %s", ErrInvalidQueryLanguage, field, ql)
+}
+
+// JSONataVars are the variables that JSONata expressions refer to, such as $states.
+type JSONataVars map[string]interface{}
+
+// NewJSONataVars returns the variables of a state, which has $states.input and $states.context,
+// and the variables assigned by the Assign fields.
+func NewJSONataVars(coj *CtxObj, input interface{}) JSONataVars {
+	var ctxobj interface{}
+	if coj != nil {
+		ctxobj = coj.GetAll()
+	}
+
+	vars := JSONataVars(coj.Variables().All())
+	vars["states"] = map[string]interface{}{
+		"input":   input,
+		"context": ctxobj,
+	}
+	return vars
+}
+
+// WithStates returns a copy of vars whose $states has v as key, such as $states.result.
+func (vars JSONataVars) WithStates(key string, v interface{}) JSONataVars {
+	res := make(JSONataVars, len(vars))
+	for k, e := range vars {
+		res[k] = e
+	}
+
+	states := map[string]interface{}{}
+	if m, ok := vars["states"].(map[string]interface{}); ok {
+		for k, e := range m {
+			states[k] = e
+		}
+	}
+	states[key] = v
+	res["states"] = states
+
+	return res
+}
+
+// isJSONataExpr reports whether str is a JSONata expression enclosed in {% %}.
+func isJSONataExpr(str string) bool {
+	return strings.HasPrefix(str, "{%") && strings.HasSuffix(str, "%}") && len(str) >= 4
+}
+
+// compileJSONataExpr compiles a JSONata expression enclosed in {% %}.
+func compileJSONataExpr(str string) (*jsonata.Expression, error) {
+	if !isJSONataExpr(str) {
+		return nil, fmt.Errorf("%w: JSONata expression must be enclosed in {%% %%}: %s", jsonata.ErrSyntax, str)
+	}
+	return jsonata.Compile(strings.TrimSpace(str[2 : len(str)-2]))
+}
+
+// JSONataTemplate is a JSON value whose strings enclosed in {% %} are JSONata expressions,
+// such as Arguments and Output. It is compiled when a state is decoded, and never modified after that.
+type JSONataTemplate struct {
+	root jsonataNode
+	raw  interface{}
+}
+
+type jsonataNode interface {
+	eval(ctx context.Context, vars JSONataVars) (interface{}, error)
+}
+
+type jsonataField struct {
+	key  string
+	node jsonataNode
+}
+
+type jsonataObject []jsonataField
+
+func (o jsonataObject) eval(ctx context.Context, vars JSONataVars) (interface{}, error) {
+	out := make(map[string]interface{}, len(o))
+	for _, field := range o {
+		v, err := field.node.eval(ctx, vars)
+		if errors.Is(err, jsonata.ErrUndefined) {
+			// the fields without values are omitted
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[field.key] = v
+	}
+	return out, nil
+}
+
+type jsonataArray []jsonataNode
+
+func (a jsonataArray) eval(ctx context.Context, vars JSONataVars) (interface{}, error) {
+	out := make([]interface{}, 0, len(a))
+	for _, node := range a {
+		v, err := node.eval(ctx, vars)
+		if errors.Is(err, jsonata.ErrUndefined) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+type jsonataStatic struct {
+	v interface{}
+}
+
+func (s jsonataStatic) eval(ctx context.Context, vars JSONataVars) (interface{}, error) {
+	return CopyJSON(s.v), nil
+}
+
+type jsonataExpr struct {
+	expr *jsonata.Expression
+}
+
+func (e jsonataExpr) eval(ctx context.Context, vars JSONataVars) (interface{}, error) {
+	return e.expr.Eval(ctx, nil, vars)
+}
+
+// NewJSONataTemplate compiles a template decoded from JSON.
+func NewJSONataTemplate(v interface{}) (*JSONataTemplate, error) {
+	root, err := compileJSONataNode(v)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONataTemplate{root: root, raw: v}, nil
+}
+
+func compileJSONataNode(v interface{}) (jsonataNode, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		obj := make(jsonataObject, len(keys))
+		for i, key := range keys {
+			node, err := compileJSONataNode(v[key])
+			if err != nil {
+				return nil, err
+			}
+			obj[i] = jsonataField{key: key, node: node}
+		}
+		return obj, nil
+	case []interface{}:
+		arr := make(jsonataArray, len(v))
+		for i, e := range v {
+			node, err := compileJSONataNode(e)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = node
+		}
+		return arr, nil
+	case string:
+		if !isJSONataExpr(v) {
+			return jsonataStatic{v}, nil
+		}
+		expr, err := compileJSONataExpr(v)
+		if err != nil {
+			return nil, err
+		}
+		return jsonataExpr{expr}, nil
+	default:
+		return jsonataStatic{v}, nil
+	}
+}
+
+// Eval returns a new value built from the template and the variables.
+func (t *JSONataTemplate) Eval(ctx context.Context, vars JSONataVars) (interface{}, error) {
+	v, err := t.root.eval(ctx, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrQueryEvaluationFailed, err)
+	}
+	return v, nil
+}
+
+// EvalString evaluates the template, which must result in a string.
+func (t *JSONataTemplate) EvalString(ctx context.Context, vars JSONataVars) (string, error) {
+	v, err := t.Eval(ctx, vars)
+	if err != nil {
+		return "", err
+	}
+
+	str, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: the result must be a string: %v", ErrQueryEvaluationFailed, v)
+	}
+	return str, nil
+}
+
+// Raw returns the template as it is written in the state machine.
+func (t *JSONataTemplate) Raw() interface{} {
+	return t.raw
+}
+
+func (t *JSONataTemplate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.raw)
+}
+
+// newSecondsField decodes a field of seconds such as TimeoutSeconds, which is an integer,
+// or a JSONata expression evaluated at run time if the state uses JSONata.
+// A value that is neither of them is reported with errInvalid.
+func newSecondsField(field string, v interface{}, jsonata bool, errInvalid error) (*int, *JSONataTemplate, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil, nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+			return nil, nil, fmt.Errorf("%w: '%s' must be an integer: %v", errInvalid, field, v)
+		}
+		n := int(v)
+		return &n, nil, nil
+	case string:
+		if !jsonata || !isJSONataExpr(v) {
+			break
+		}
+		tmpl, err := NewJSONataTemplate(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", field, err)
+		}
+		return nil, tmpl, nil
+	}
+	return nil, nil, fmt.Errorf("%w: '%s' must be an integer: %v", errInvalid, field, v)
+}
+
+// JSONataRule is a Choice rule whose Condition is a JSONata expression.
+type JSONataRule struct {
+	Condition *jsonata.Expression
+}
+
+func (r JSONataRule) Eval(ctx context.Context, coj *CtxObj, input interface{}) (bool, error) {
+	v, err := r.Condition.Eval(ctx, nil, NewJSONataVars(coj, input))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrQueryEvaluationFailed, err)
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: Condition must be a boolean: %v", ErrQueryEvaluationFailed, v)
+	}
+	return b, nil
+}
@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/jsonata"
+)
+
+func TestJSONataTemplate_Eval(t *testing.T) {
+	raw := map[string]interface{}{
+		"static":  "{% not an expression",
+		"expr":    "{% $states.input.a + 1 %}",
+		"missing": "{% $states.input.missing %}",
+		"list":    []interface{}{"{% $states.context.aaa %}", "{% $states.input.missing %}", 1.0},
+	}
+	temp, err := NewJSONataTemplate(raw)
+	if err != nil {
+		t.Fatal("NewJSONataTemplate() failed:", err)
+	}
+
+	coj, err := new(CtxObj).SetByString("$.aaa", 111)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := temp.Eval(context.Background(), NewJSONataVars(coj, map[string]interface{}{"a": 1.0}))
+	if err != nil {
+		t.Fatal("Eval() failed:", err)
+	}
+
+	want := map[string]interface{}{
+		"static": "{% not an expression",
+		"expr":   2.0,
+		"list":   []interface{}{111, 1.0},
+	}
+	if d := cmp.Diff(got, want); d != "" {
+		t.Errorf("Eval() failed: \n%s", d)
+	}
+}
+
+func TestCompile_jsonata(t *testing.T) {
+	tests := []struct {
+		name    string
+		asl     string
+		wantErr error
+	}{
+		{"valid", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Output": "{% $states.input %}", "End": true}}}`, nil},
+		{"valid per state", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "QueryLanguage": "JSONata", "Output": {"a": "{% 1 %}"}, "End": true}}}`, nil},
+		{"unknown query language", `{"QueryLanguage": "XPath", "StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"JSONPath state in JSONata", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "QueryLanguage": "JSONPath", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"InputPath", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "InputPath": "$.a", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"Parameters", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {}, "End": true}}}`, ErrInvalidQueryLanguage},
+		{"Catch ResultPath", `{"QueryLanguage": "JSONata", "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "Catch": [{"ErrorEquals": ["States.ALL"], "ResultPath": "$.e", "Next": "T"}], "End": true}}}`, ErrInvalidQueryLanguage},
+		{"Output with JSONPath", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Output": {}, "End": true}}}`, ErrInvalidQueryLanguage},
+		{"Arguments with JSONPath", `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "Arguments": {}, "End": true}}}`, ErrInvalidQueryLanguage},
+		{"invalid expression", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Output": "{% $states.input. %}", "End": true}}}`, jsonata.ErrSyntax},
+		{"invalid Condition", `{"QueryLanguage": "JSONata", "StartAt": "C", "States": {"C": {"Type": "Choice", "Choices": [{"Condition": "true", "Next": "P"}]}, "P": {"Type": "Pass", "End": true}}}`, jsonata.ErrSyntax},
+		{"TimeoutSeconds expression", `{"QueryLanguage": "JSONata", "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "TimeoutSeconds": "{% $states.input.t %}", "HeartbeatSeconds": "{% 1 %}", "End": true}}}`, nil},
+		{"Wait expressions", `{"QueryLanguage": "JSONata", "StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": "{% $states.input.s %}", "Next": "X"}, "X": {"Type": "Wait", "Timestamp": "{% $states.input.ts %}", "End": true}}}`, nil},
+		{"Seconds expression with JSONPath", `{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": "{% 1 %}", "End": true}}}`, ErrInvalidWaitSeconds},
+		{"TimeoutSeconds expression with JSONPath", `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "TimeoutSeconds": "{% 1 %}", "End": true}}}`, ErrInvalidTaskTimeout},
+		{"invalid TimeoutSeconds expression", `{"QueryLanguage": "JSONata", "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "TimeoutSeconds": "{% ( %}", "End": true}}}`, jsonata.ErrSyntax},
+		{"TimeoutSecondsPath", `{"QueryLanguage": "JSONata", "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "TimeoutSecondsPath": "$.t", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"HeartbeatSecondsPath", `{"QueryLanguage": "JSONata", "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x", "HeartbeatSecondsPath": "$.h", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"SecondsPath", `{"QueryLanguage": "JSONata", "StartAt": "W", "States": {"W": {"Type": "Wait", "SecondsPath": "$.s", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"TimestampPath", `{"QueryLanguage": "JSONata", "StartAt": "W", "States": {"W": {"Type": "Wait", "TimestampPath": "$.ts", "End": true}}}`, ErrInvalidQueryLanguage},
+		{"JSONPath state in JSONata branch", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Parallel", "End": true, "Branches": [
+			{"StartAt": "B", "States": {"B": {"Type": "Pass", "QueryLanguage": "JSONPath", "End": true}}}]}}}`, ErrInvalidQueryLanguage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(context.Background(), bytes.NewBufferString(tt.asl))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Compile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONataRule_Eval_canceled(t *testing.T) {
+	expr, err := jsonata.Compile("true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (JSONataRule{expr}).Eval(ctx, new(CtxObj), nil); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("Eval() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -1,12 +1,24 @@
 package compiler
 
-import "log"
+import (
+	"errors"
+	"fmt"
+	"log"
+)
 
 type RawMapState struct {
 	CommonState5
-	Iterator       ASL    `json:"Iterator"`
-	ItemsPath      string `json:"ItemsPath"`
-	MaxConcurrency int    `json:"MaxConcurrency"`
+	Iterator                   *ASL           `json:"Iterator"`
+	ItemProcessor              *ItemProcessor `json:"ItemProcessor"`
+	RawItemSelector            interface{}    `json:"ItemSelector"`
+	ItemsPath                  string         `json:"ItemsPath"`
+	RawItems                   interface{}    `json:"Items"`
+	MaxConcurrency             int            `json:"MaxConcurrency"`
+	ItemReader                 *ItemReader    `json:"ItemReader"`
+	ItemBatcher                *ItemBatcher   `json:"ItemBatcher"`
+	ResultWriter               *ResultWriter  `json:"ResultWriter"`
+	ToleratedFailurePercentage *float64       `json:"ToleratedFailurePercentage"`
+	ToleratedFailureCount      *int           `json:"ToleratedFailureCount"`
 }
 
 func (raw RawMapState) decode(name string) (State, error) {
@@ -15,29 +27,237 @@ func (raw RawMapState) decode(name string) (State, error) {
 		return nil, err
 	}
 
-	workflow, err := raw.Iterator.compile()
+	processor, err := raw.itemProcessor()
+	if err != nil {
+		return nil, err
+	}
+
+	if processor.QueryLanguage == "" {
+		processor.QueryLanguage = raw.QueryLanguage
+	}
+	if err := validateQueryLanguage(processor.QueryLanguage); err != nil {
+		return nil, err
+	}
+
+	workflow, err := processor.compile()
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
+	state := MapState{
+		CommonState5:    s.Common(),
+		Iterator:        *workflow,
+		ProcessorConfig: processor.ProcessorConfig,
+		MaxConcurrency:  raw.MaxConcurrency,
+	}
+
+	if err := raw.decodeItemSelector(&state); err != nil {
+		return nil, err
+	}
+
+	if err := raw.decodeDistributed(&state); err != nil {
+		return nil, err
+	}
+
+	if raw.IsJSONata() {
+		if raw.ItemsPath != "" {
+			return nil, unsupportedFieldError("ItemsPath", raw.QueryLanguage)
+		}
+		if raw.RawItems != nil && state.ItemReader != nil {
+			return nil, fmt.Errorf("%w: 'Items' can not be used with 'ItemReader'", ErrInvalidItemReader)
+		}
+
+		if raw.RawItems != nil {
+			v, err := NewJSONataTemplate(raw.RawItems)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Items: %w", err)
+			}
+			state.Items = v
+		}
+
+		return state, nil
+	}
+
+	if raw.RawItems != nil {
+		return nil, unsupportedFieldError("Items", raw.QueryLanguage)
+	}
+
+	if state.ItemReader != nil {
+		if raw.ItemsPath != "" {
+			return nil, fmt.Errorf("%w: 'ItemsPath' can not be used with 'ItemReader'", ErrInvalidItemReader)
+		}
+		return state, nil
+	}
+
+	// the input of the state is iterated over by default
+	if raw.ItemsPath == "" {
+		raw.ItemsPath = "$"
+	}
+
 	path, err := NewReferencePath(raw.ItemsPath)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	state.ItemsPath = path
+
+	return state, nil
+}
+
+// itemProcessor returns ItemProcessor, or Iterator which is its deprecated name.
+func (raw RawMapState) itemProcessor() (ItemProcessor, error) {
+	switch {
+	case raw.ItemProcessor != nil && raw.Iterator != nil:
+		return ItemProcessor{}, fmt.Errorf("%w: 'ItemProcessor' can not be used with 'Iterator'", ErrInvalidItemProcessor)
+	case raw.ItemProcessor != nil:
+		processor := *raw.ItemProcessor
+		switch processor.ProcessorConfig.Mode {
+		case "":
+			processor.ProcessorConfig.Mode = ProcessorModeInline
+		case ProcessorModeInline, ProcessorModeDistributed:
+		default:
+			return ItemProcessor{}, fmt.Errorf("%w: unknown 'Mode': %s", ErrInvalidItemProcessor, processor.ProcessorConfig.Mode)
+		}
+		switch processor.ProcessorConfig.ExecutionType {
+		case "", ExecutionTypeStandard, ExecutionTypeExpress:
+		default:
+			return ItemProcessor{}, fmt.Errorf("%w: unknown 'ExecutionType': %s", ErrInvalidItemProcessor, processor.ProcessorConfig.ExecutionType)
+		}
+		return processor, nil
+	case raw.Iterator != nil:
+		return ItemProcessor{
+			ASL:             *raw.Iterator,
+			ProcessorConfig: ProcessorConfig{Mode: ProcessorModeInline},
+		}, nil
+	default:
+		return ItemProcessor{}, fmt.Errorf("%w: 'ItemProcessor' is needed", ErrInvalidItemProcessor)
+	}
+}
+
+// decodeItemSelector decodes ItemSelector, or Parameters which is its deprecated name.
+// Parameters of a Map state is applied to each item instead of the input of the state.
+func (raw RawMapState) decodeItemSelector(state *MapState) error {
+	if raw.RawItemSelector == nil && state.Parameters == nil {
+		return nil
+	}
+	if raw.RawItemSelector != nil && state.Parameters != nil {
+		return fmt.Errorf("%w: 'ItemSelector' can not be used with 'Parameters'", ErrInvalidItemSelector)
+	}
+	if state.Parameters != nil {
+		state.ItemSelector = state.Parameters
+		state.Parameters = nil
+		return nil
+	}
+
+	if _, ok := raw.RawItemSelector.(map[string]interface{}); !ok {
+		return fmt.Errorf("%w: 'ItemSelector' must be an object", ErrInvalidItemSelector)
+	}
+
+	if raw.IsJSONata() {
+		v, err := NewJSONataTemplate(raw.RawItemSelector)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidItemSelector, err)
+		}
+		state.ItemSelectorExpr = v
+		return nil
+	}
+
+	v, err := NewPayloadTemplate(raw.RawItemSelector)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidItemSelector, err)
+	}
+	state.ItemSelector = v
+	return nil
+}
+
+// decodeDistributed decodes the fields of the distributed Map state.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/state-map-distributed.html
+func (raw RawMapState) decodeDistributed(state *MapState) error {
+	if raw.ItemReader != nil {
+		v, err := raw.ItemReader.decode(raw.QueryLanguage)
+		if err != nil {
+			return err
+		}
+		state.ItemReader = v
+	}
+
+	if raw.ItemBatcher != nil {
+		v, err := raw.ItemBatcher.decode(raw.QueryLanguage)
+		if err != nil {
+			return err
+		}
+		state.ItemBatcher = v
+	}
+
+	if raw.ResultWriter != nil {
+		v, err := raw.ResultWriter.decode(raw.QueryLanguage)
+		if err != nil {
+			return err
+		}
+		state.ResultWriter = v
+	}
+
+	if v := raw.ToleratedFailurePercentage; v != nil && (*v < 0 || *v > 100) {
+		return fmt.Errorf("'ToleratedFailurePercentage' must be between 0 and 100: %v", *v)
+	}
+	if v := raw.ToleratedFailureCount; v != nil && *v < 0 {
+		return fmt.Errorf("'ToleratedFailureCount' must not be negative: %v", *v)
+	}
+	state.ToleratedFailurePercentage = raw.ToleratedFailurePercentage
+	state.ToleratedFailureCount = raw.ToleratedFailureCount
+
+	return nil
+}
+
+// The values of ProcessorConfig.
+const (
+	ProcessorModeInline      = "INLINE"
+	ProcessorModeDistributed = "DISTRIBUTED"
+
+	ExecutionTypeStandard = "STANDARD"
+	ExecutionTypeExpress  = "EXPRESS"
+)
+
+var (
+	ErrInvalidItemProcessor = errors.New("invalid ItemProcessor")
+	ErrInvalidItemSelector  = errors.New("invalid ItemSelector")
+)
+
+// ItemProcessor is the state machine run for each item of a Map state.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-itemprocessor.html
+type ItemProcessor struct {
+	ASL
+	ProcessorConfig ProcessorConfig `json:"ProcessorConfig"`
+}
 
-	return MapState{
-		CommonState5:   s.Common(),
-		Iterator:       *workflow,
-		ItemsPath:      path,
-		MaxConcurrency: raw.MaxConcurrency,
-	}, nil
+type ProcessorConfig struct {
+	Mode          string `json:"Mode"`
+	ExecutionType string `json:"ExecutionType"`
 }
 
 type MapState struct {
 	CommonState5
-	Iterator       Workflow
-	ItemsPath      ReferencePath
+	// Iterator is the compiled ItemProcessor.
+	Iterator        Workflow
+	ProcessorConfig ProcessorConfig
+	ItemsPath       ReferencePath
+	// ItemSelector builds the input of each iteration from $$.Map.Item, if it is not nil.
+	ItemSelector *PayloadTemplate
+	// ItemSelectorExpr is ItemSelector with JSONata.
+	ItemSelectorExpr *JSONataTemplate
+	// Items is the array to iterate over, or a JSONata expression that results in it.
+	// The input of the state is iterated over if it is nil.
+	Items          *JSONataTemplate
 	MaxConcurrency int
+	// ItemReader reads the items from a file instead of the input, if it is not nil.
+	ItemReader *ItemReader
+	// ItemBatcher groups the items into the batches, if it is not nil.
+	ItemBatcher *ItemBatcher
+	// ResultWriter writes the results to files instead of the output, if it is not nil.
+	ResultWriter *ResultWriter
+	// The iterations can fail up to these thresholds without failing the state.
+	// No iterations can fail if both are nil.
+	ToleratedFailurePercentage *float64
+	ToleratedFailureCount      *int
 }
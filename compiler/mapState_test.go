@@ -0,0 +1,64 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCompile_itemProcessor(t *testing.T) {
+	const states = `"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}`
+	tests := []struct {
+		name     string
+		fields   string
+		wantMode string
+		wantErr  error
+	}{
+		{"item processor", `"ItemProcessor": {"ProcessorConfig": {"Mode": "DISTRIBUTED", "ExecutionType": "EXPRESS"}, ` + states + `}`, ProcessorModeDistributed, nil},
+		{"default mode", `"ItemProcessor": {` + states + `}`, ProcessorModeInline, nil},
+		{"iterator", `"Iterator": {` + states + `}`, ProcessorModeInline, nil},
+		{"item selector", `"ItemSelector": {"v.$": "$$.Map.Item.Value"}, "ItemProcessor": {` + states + `}`, ProcessorModeInline, nil},
+		{"both", `"ItemProcessor": {` + states + `}, "Iterator": {` + states + `}`, "", ErrInvalidItemProcessor},
+		{"neither", `"ItemsPath": "$"`, "", ErrInvalidItemProcessor},
+		{"unknown mode", `"ItemProcessor": {"ProcessorConfig": {"Mode": "PARALLEL"}, ` + states + `}`, "", ErrInvalidItemProcessor},
+		{"unknown execution type", `"ItemProcessor": {"ProcessorConfig": {"ExecutionType": "FAST"}, ` + states + `}`, "", ErrInvalidItemProcessor},
+		{"item selector with parameters", `"ItemSelector": {}, "Parameters": {}, "ItemProcessor": {` + states + `}`, "", ErrInvalidItemSelector},
+		{"item selector not object", `"ItemSelector": [], "ItemProcessor": {` + states + `}`, "", ErrInvalidItemSelector},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", ` + tt.fields + `, "End": true}}}`
+			w, err := Compile(context.Background(), bytes.NewBufferString(asl))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Compile() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			state := w.States[0][0].(MapState)
+			if state.ProcessorConfig.Mode != tt.wantMode {
+				t.Errorf("Mode = %s, want %s", state.ProcessorConfig.Mode, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestCompile_mapParameters(t *testing.T) {
+	asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "Parameters": {"v.$": "$$.Map.Item.Value"}, "End": true,
+		"Iterator": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}}}}`
+	w, err := Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("Compile() failed:", err)
+	}
+
+	// Parameters of a Map state is applied to each item, not to the input of the state
+	state := w.States[0][0].(MapState)
+	if state.Parameters != nil {
+		t.Error("Parameters is not nil")
+	}
+	if state.ItemSelector == nil {
+		t.Error("ItemSelector is nil")
+	}
+}
@@ -18,6 +18,13 @@ func (raw RawParallelState) decode(name string) (State, error) {
 	branches := make([]Workflow, len(raw.Branches))
 
 	for i, branch := range raw.Branches {
+		if branch.QueryLanguage == "" {
+			branch.QueryLanguage = raw.QueryLanguage
+		}
+		if err := validateQueryLanguage(branch.QueryLanguage); err != nil {
+			return nil, err
+		}
+
 		workflow, err := branch.compile()
 		if err != nil {
 			log.Println(err)
@@ -9,12 +9,20 @@ import (
 type Path struct {
 	Expr          jp.Expr
 	IsContextPath bool
+	// Variable is the name of the variable that the path starts with, such as $name.field.
+	Variable string
 }
 
 func NewPath(path string) (Path, error) {
 	result := Path{}
 
-	if strings.HasPrefix(path, "$$") {
+	if name, rest, ok := splitVariablePath(path); ok {
+		if err := validateVariableName(name); err != nil {
+			return Path{}, err
+		}
+		result.Variable = name
+		path = "$" + rest
+	} else if strings.HasPrefix(path, "$$") {
 		path = strings.TrimPrefix(path, "$")
 		result.IsContextPath = true
 	}
@@ -37,5 +45,8 @@ func MustNewPath(path string) Path {
 }
 
 func (p Path) String() string {
+	if p.Variable != "" {
+		return "$" + p.Variable + strings.TrimPrefix(p.Expr.String(), "$")
+	}
 	return p.Expr.String()
 }
@@ -0,0 +1,213 @@
+package compiler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PayloadTemplate is a payload template, such as Parameters and ResultSelector, compiled when a state is decoded.
+// It is never modified after it is compiled, so it can be shared by concurrent executions.
+// ref: https://states-language.net/#payload-template
+type PayloadTemplate struct {
+	root payloadNode
+	raw  interface{}
+}
+
+// payloadNode is a node of a compiled payload template, which resolves to a fresh value every time.
+type payloadNode interface {
+	resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error)
+}
+
+type payloadField struct {
+	key  string
+	node payloadNode
+}
+
+type payloadObject []payloadField
+
+func (o payloadObject) resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	out := make(map[string]interface{}, len(o))
+	for _, field := range o {
+		v, err := field.node.resolve(ctx, coj, input)
+		if err != nil {
+			return nil, err
+		}
+		out[field.key] = v
+	}
+	return out, nil
+}
+
+type payloadArray []payloadNode
+
+func (a payloadArray) resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	out := make([]interface{}, len(a))
+	for i, node := range a {
+		v, err := node.resolve(ctx, coj, input)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type payloadStatic struct {
+	v interface{}
+}
+
+func (s payloadStatic) resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	return s.v, nil
+}
+
+type payloadPath struct {
+	key  string
+	path Path
+}
+
+func (p payloadPath) resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	path := p.path
+	v, err := UnjoinByPath(coj, input, &path)
+	if err != nil {
+		return nil, fmt.Errorf("[%s]=[%s]: %v", p.key, p.path, err)
+	}
+	return v, nil
+}
+
+type payloadIntrinsic struct {
+	f *IntrinsicFunction
+}
+
+func (i payloadIntrinsic) resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	return i.f.Eval(ctx, coj, input)
+}
+
+// NewPayloadTemplate compiles a payload template decoded from JSON.
+// The paths and the intrinsic functions in the fields whose names end with ".$" are validated.
+func NewPayloadTemplate(v interface{}) (*PayloadTemplate, error) {
+	root, err := compilePayloadNode(v)
+	if err != nil {
+		return nil, err
+	}
+	return &PayloadTemplate{root: root, raw: v}, nil
+}
+
+func compilePayloadNode(v interface{}) (payloadNode, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		obj := make(payloadObject, 0, len(v))
+		for _, key := range keys {
+			node, err := compilePayloadField(key, v[key])
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, payloadField{key: strings.TrimSuffix(key, ".$"), node: node})
+		}
+
+		for i := 1; i < len(obj); i++ {
+			if obj[i-1].key == obj[i].key {
+				return nil, fmt.Errorf("duplicated field in payload template: %s", obj[i].key)
+			}
+		}
+		return obj, nil
+	case []interface{}:
+		arr := make(payloadArray, len(v))
+		for i, e := range v {
+			node, err := compilePayloadNode(e)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = node
+		}
+		return arr, nil
+	default:
+		return payloadStatic{v}, nil
+	}
+}
+
+func compilePayloadField(key string, val interface{}) (payloadNode, error) {
+	if !strings.HasSuffix(key, ".$") {
+		return compilePayloadNode(val)
+	}
+
+	str, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("value of payload template is not string: [%s]=[%v]", key, val)
+	}
+
+	if strings.HasPrefix(str, "$") {
+		p, err := NewPath(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path in payload template: [%s]=[%s]: %w", key, str, err)
+		}
+		return payloadPath{key: key, path: p}, nil
+	}
+
+	f, err := ParseIntrinsicFunction(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: [%s]: %w", key, err)
+	}
+	return payloadIntrinsic{f}, nil
+}
+
+// Resolve returns a new value built from the template, the input and the context object.
+// The result shares nothing with the template, so it can be modified freely.
+func (t *PayloadTemplate) Resolve(ctx context.Context, coj *CtxObj, input interface{}) (interface{}, error) {
+	v, err := t.root.resolve(ctx, coj, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// static values are shared by all the results, so they are copied at the end
+	return CopyJSON(v), nil
+}
+
+// Raw returns the template as it is written in the state machine.
+func (t *PayloadTemplate) Raw() interface{} {
+	return t.raw
+}
+
+func (t *PayloadTemplate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.raw)
+}
+
+// CopyJSON returns a deep copy of a value decoded from JSON.
+// The objects of a named map type, such as the ones returned by task functions, are converted to plain maps.
+func CopyJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, bool, float64, string, json.Number:
+		return v
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = CopyJSON(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = CopyJSON(e)
+		}
+		return s
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return v
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = CopyJSON(iter.Value().Interface())
+	}
+	return m
+}
@@ -0,0 +1,131 @@
+package compiler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/intrinsic"
+)
+
+func TestPayloadTemplate_Resolve(t *testing.T) {
+	input := map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"c": "x"}, "items": []interface{}{1.0, 2.0}}
+	tests := []struct {
+		name     string
+		template string
+		want     interface{}
+	}{
+		{"object", `{"static": 1, "path.$": "$.a", "nested": {"c.$": "$.b.c"}}`, map[string]interface{}{"static": 1.0, "path": 1.0, "nested": map[string]interface{}{"c": "x"}}},
+		{"array of objects", `{"list": [{"a.$": "$.a"}, 2, [{"c.$": "$.b.c"}]]}`, map[string]interface{}{"list": []interface{}{map[string]interface{}{"a": 1.0}, 2.0, []interface{}{map[string]interface{}{"c": "x"}}}}},
+		{"intrinsic", `{"len.$": "States.ArrayLength($.items)"}`, map[string]interface{}{"len": 2}},
+		{"context object", `{"ctx.$": "$$.aaa"}`, map[string]interface{}{"ctx": 111}},
+		{"top-level array", `[{"a.$": "$.a"}, "b"]`, []interface{}{map[string]interface{}{"a": 1.0}, "b"}},
+		{"top-level string", `"$.a"`, "$.a"},
+		{"top-level null", `null`, nil},
+	}
+	coj, err := new(CtxObj).SetByString("$.aaa", 111)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.template), &raw); err != nil {
+				t.Fatal(err)
+			}
+
+			temp, err := NewPayloadTemplate(raw)
+			if err != nil {
+				t.Fatal("NewPayloadTemplate() failed:", err)
+			}
+
+			got, err := temp.Resolve(context.Background(), coj, input)
+			if err != nil {
+				t.Fatal("Resolve() failed:", err)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("Resolve() failed: \n%s", d)
+			}
+		})
+	}
+}
+
+func TestPayloadTemplate_Resolve_fresh(t *testing.T) {
+	raw := map[string]interface{}{"static": map[string]interface{}{"x": 1.0}, "path.$": "$.a"}
+	temp, err := NewPayloadTemplate(raw)
+	if err != nil {
+		t.Fatal("NewPayloadTemplate() failed:", err)
+	}
+
+	input := map[string]interface{}{"a": map[string]interface{}{"y": 2.0}}
+	got, err := temp.Resolve(context.Background(), &CtxObj{}, input)
+	if err != nil {
+		t.Fatal("Resolve() failed:", err)
+	}
+
+	got.(map[string]interface{})["static"].(map[string]interface{})["x"] = "modified"
+	got.(map[string]interface{})["path"].(map[string]interface{})["y"] = "modified"
+
+	if d := cmp.Diff(raw, map[string]interface{}{"static": map[string]interface{}{"x": 1.0}, "path.$": "$.a"}); d != "" {
+		t.Errorf("the template is modified: \n%s", d)
+	}
+	if d := cmp.Diff(input, map[string]interface{}{"a": map[string]interface{}{"y": 2.0}}); d != "" {
+		t.Errorf("the input is modified: \n%s", d)
+	}
+
+	again, err := temp.Resolve(context.Background(), &CtxObj{}, input)
+	if err != nil {
+		t.Fatal("Resolve() failed:", err)
+	}
+	if d := cmp.Diff(again, map[string]interface{}{"static": map[string]interface{}{"x": 1.0}, "path": map[string]interface{}{"y": 2.0}}); d != "" {
+		t.Errorf("Resolve() failed: \n%s", d)
+	}
+}
+
+func TestCopyJSON(t *testing.T) {
+	type obj map[string]interface{}
+	v := map[string]interface{}{"a": []interface{}{obj{"b": obj{"c": 1.0}}}, "d": "e"}
+
+	got := CopyJSON(v)
+	want := map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": map[string]interface{}{"c": 1.0}}}, "d": "e"}
+	if d := cmp.Diff(got, want); d != "" {
+		t.Fatalf("CopyJSON() = \n%s", d)
+	}
+
+	got.(map[string]interface{})["a"].([]interface{})[0].(map[string]interface{})["b"] = "modified"
+	if v["a"].([]interface{})[0].(obj)["b"].(obj)["c"] != 1.0 {
+		t.Errorf("the original is modified: %v", v)
+	}
+}
+
+func TestNewPayloadTemplate_error(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  error
+	}{
+		{"not string", `{"a.$": 1}`, nil},
+		{"invalid path", `{"a.$": "$.["}`, nil},
+		{"duplicated", `{"a": 1, "a.$": "$.a"}`, nil},
+		{"unknown function", `[{"a.$": "States.Unknown()"}]`, intrinsic.ErrUnknownFunction},
+		{"invalid intrinsic", `{"a": {"b.$": "States.Format('x'"}}`, ErrInvalidIntrinsic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.template), &raw); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := NewPayloadTemplate(raw)
+			if err == nil {
+				t.Fatal("NewPayloadTemplate() succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewPayloadTemplate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,7 +17,13 @@ type ReferencePath struct {
 func NewReferencePath(path string) (ReferencePath, error) {
 	result := ReferencePath{}
 
-	if strings.HasPrefix(path, "$$") {
+	if name, rest, ok := splitVariablePath(path); ok {
+		if err := validateVariableName(name); err != nil {
+			return ReferencePath{}, err
+		}
+		result.Variable = name
+		path = "$" + rest
+	} else if strings.HasPrefix(path, "$$") {
 		path = strings.TrimPrefix(path, "$")
 		result.IsContextPath = true
 	}
@@ -45,5 +51,5 @@ func NewReferencePath(path string) (ReferencePath, error) {
 }
 
 func (p ReferencePath) String() string {
-	return p.Expr.String()
+	return p.Path.String()
 }
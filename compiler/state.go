@@ -2,6 +2,7 @@ package compiler
 
 type RawState interface {
 	decode(name string) (State, error)
+	inheritQueryLanguage(ql string)
 }
 
 type State interface {
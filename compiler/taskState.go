@@ -8,14 +8,18 @@ import (
 var (
 	ErrInvalidTaskResource     = fmt.Errorf("invalid resource")
 	ErrInvalidTaskResourceType = fmt.Errorf("invalid resource type")
+	ErrInvalidTaskTimeout      = fmt.Errorf("invalid task timeout")
 )
 
 type RawTaskState struct {
 	CommonState5
-	RawResource          string  `json:"Resource"`
-	TimeoutSeconds       *int    `json:"TimeoutSeconds"`
-	TimeoutSecondsPath   *string `json:"TimeoutSecondsPath"`
-	HeartbeatSeconds     *int    `json:"HeartbeatSeconds"`
+	RawResource string `json:"Resource"`
+	// RawTimeoutSeconds and RawHeartbeatSeconds are integers, or JSONata expressions in a JSONata state.
+	RawTimeoutSeconds    interface{} `json:"TimeoutSeconds"`
+	TimeoutSeconds       *int
+	TimeoutSecondsPath   *string     `json:"TimeoutSecondsPath"`
+	RawHeartbeatSeconds  interface{} `json:"HeartbeatSeconds"`
+	HeartbeatSeconds     *int
 	HeartbeatSecondsPath *string `json:"HeartbeatSecondsPath"`
 }
 
@@ -32,6 +36,31 @@ func (raw *RawTaskState) decode(name string) (State, error) {
 		return nil, ErrInvalidTaskResource
 	}
 
+	if raw.IsJSONata() {
+		switch {
+		case raw.TimeoutSecondsPath != nil:
+			return nil, unsupportedFieldError("TimeoutSecondsPath", raw.QueryLanguage)
+		case raw.HeartbeatSecondsPath != nil:
+			return nil, unsupportedFieldError("HeartbeatSecondsPath", raw.QueryLanguage)
+		}
+	}
+
+	timeoutSeconds, timeoutSecondsExpr, err := newSecondsField("TimeoutSeconds", raw.RawTimeoutSeconds, raw.IsJSONata(), ErrInvalidTaskTimeout)
+	if err != nil {
+		return nil, err
+	}
+	raw.TimeoutSeconds = timeoutSeconds
+
+	heartbeatSeconds, heartbeatSecondsExpr, err := newSecondsField("HeartbeatSeconds", raw.RawHeartbeatSeconds, raw.IsJSONata(), ErrInvalidTaskTimeout)
+	if err != nil {
+		return nil, err
+	}
+	raw.HeartbeatSeconds = heartbeatSeconds
+
+	if err := raw.validateTimeouts(); err != nil {
+		return nil, err
+	}
+
 	var timeoutSecondsPath *Path
 	if raw.TimeoutSecondsPath != nil {
 		v, err := NewPath(*raw.TimeoutSecondsPath)
@@ -50,19 +79,51 @@ func (raw *RawTaskState) decode(name string) (State, error) {
 		heartbeatSecondsPath = &v
 	}
 
+	resource := TaskResouce{
+		Type: v[0],
+		Path: v[1],
+	}
+	if strings.HasSuffix(resource.Path, TaskResourceWaitForTaskToken) {
+		resource.Path = strings.TrimSuffix(resource.Path, TaskResourceWaitForTaskToken)
+		resource.WaitForTaskToken = true
+	}
+
 	return TaskState{
-		CommonState5: s.Common(),
-		Resouce: TaskResouce{
-			Type: v[0],
-			Path: v[1],
-		},
+		CommonState5:         s.Common(),
+		Resouce:              resource,
 		TimeoutSeconds:       raw.TimeoutSeconds,
 		TimeoutSecondsPath:   timeoutSecondsPath,
 		HeartbeatSeconds:     raw.HeartbeatSeconds,
 		HeartbeatSecondsPath: heartbeatSecondsPath,
+		TimeoutSecondsExpr:   timeoutSecondsExpr,
+		HeartbeatSecondsExpr: heartbeatSecondsExpr,
 	}, nil
 }
 
+func (raw *RawTaskState) validateTimeouts() error {
+	if raw.TimeoutSeconds != nil && raw.TimeoutSecondsPath != nil {
+		return fmt.Errorf("%w: 'TimeoutSeconds' and 'TimeoutSecondsPath' are exclusive", ErrInvalidTaskTimeout)
+	}
+
+	if raw.HeartbeatSeconds != nil && raw.HeartbeatSecondsPath != nil {
+		return fmt.Errorf("%w: 'HeartbeatSeconds' and 'HeartbeatSecondsPath' are exclusive", ErrInvalidTaskTimeout)
+	}
+
+	if raw.TimeoutSeconds != nil && *raw.TimeoutSeconds <= 0 {
+		return fmt.Errorf("%w: 'TimeoutSeconds' must be positive", ErrInvalidTaskTimeout)
+	}
+
+	if raw.HeartbeatSeconds != nil && *raw.HeartbeatSeconds <= 0 {
+		return fmt.Errorf("%w: 'HeartbeatSeconds' must be positive", ErrInvalidTaskTimeout)
+	}
+
+	if raw.TimeoutSeconds != nil && raw.HeartbeatSeconds != nil && *raw.HeartbeatSeconds >= *raw.TimeoutSeconds {
+		return fmt.Errorf("%w: 'HeartbeatSeconds' must be smaller than 'TimeoutSeconds'", ErrInvalidTaskTimeout)
+	}
+
+	return nil
+}
+
 type TaskState struct {
 	CommonState5
 	Resouce              TaskResouce
@@ -70,9 +131,18 @@ type TaskState struct {
 	TimeoutSecondsPath   *Path
 	HeartbeatSeconds     *int
 	HeartbeatSecondsPath *Path
+	// TimeoutSecondsExpr and HeartbeatSecondsExpr are the JSONata expressions of a JSONata state,
+	// which are evaluated with the input of the state before it is run.
+	TimeoutSecondsExpr   *JSONataTemplate
+	HeartbeatSecondsExpr *JSONataTemplate
 }
 
+// TaskResourceWaitForTaskToken is the suffix of a Resource that pauses the task until a task token is returned.
+const TaskResourceWaitForTaskToken = ".waitForTaskToken"
+
 type TaskResouce struct {
 	Type string
 	Path string
+	// WaitForTaskToken is true if the task waits for its token to be returned by SendTaskSuccess or SendTaskFailure.
+	WaitForTaskToken bool
 }
@@ -0,0 +1,171 @@
+package compiler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidVariableName = errors.New("invalid variable name")
+	ErrUndefinedVariable   = errors.New("undefined variable")
+	ErrReadOnlyVariable    = errors.New("the variable of an outer scope is read-only")
+)
+
+// variableName is the syntax of the names of the variables.
+// "states" is reserved for $states of JSONata.
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func validateVariableName(name string) error {
+	if !variableName.MatchString(name) || name == "states" {
+		return fmt.Errorf("%w: %s", ErrInvalidVariableName, name)
+	}
+	return nil
+}
+
+// Variables is a scope of the variables stored by the Assign fields.
+// It is never modified: Assign returns a new scope, so it can be shared by concurrent branches.
+// The scope of a Parallel or Map branch reads the variables of the outer scopes, but can not assign them.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/workflow-variables.html
+type Variables struct {
+	v      map[string]interface{}
+	parent *Variables
+}
+
+// NewScope returns the scope of a branch, whose outer scope is vars.
+func (vars *Variables) NewScope() *Variables {
+	return &Variables{parent: vars}
+}
+
+func (vars *Variables) Get(name string) (interface{}, bool) {
+	for s := vars; s != nil; s = s.parent {
+		if v, ok := s.v[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Assign returns a new scope whose variables are updated with values.
+func (vars *Variables) Assign(values map[string]interface{}) (*Variables, error) {
+	if len(values) == 0 {
+		return vars, nil
+	}
+
+	res := &Variables{}
+	if vars != nil {
+		res.parent = vars.parent
+	}
+
+	for name := range values {
+		if _, ok := res.parent.Get(name); ok {
+			return nil, fmt.Errorf("%w: %s", ErrReadOnlyVariable, name)
+		}
+	}
+
+	res.v = make(map[string]interface{}, len(values))
+	if vars != nil {
+		for k, v := range vars.v {
+			res.v[k] = v
+		}
+	}
+	for k, v := range values {
+		res.v[k] = v
+	}
+
+	return res, nil
+}
+
+// All returns all the variables visible from the scope.
+func (vars *Variables) All() map[string]interface{} {
+	if vars == nil {
+		return map[string]interface{}{}
+	}
+
+	res := vars.parent.All()
+	for k, v := range vars.v {
+		res[k] = v
+	}
+	return res
+}
+
+// splitVariablePath splits a path that starts with a variable, such as $name.field, into the name and the rest.
+func splitVariablePath(path string) (string, string, bool) {
+	if len(path) < 2 || path[0] != '$' || path[1] == '$' || path[1] == '.' || path[1] == '[' {
+		return "", "", false
+	}
+
+	i := strings.IndexAny(path, ".[")
+	if i < 0 {
+		i = len(path)
+	}
+	return path[1:i], path[i:], true
+}
+
+// Assign is the Assign field of a state or a catcher, which is compiled when the state is decoded.
+// The values are a payload template with JSONPath, or are JSONata expressions with JSONata.
+type Assign struct {
+	payload *PayloadTemplate
+	jsonata *JSONataTemplate
+	raw     interface{}
+}
+
+// NewAssign compiles the Assign field of a state whose QueryLanguage is ql.
+func NewAssign(v interface{}, ql string) (*Assign, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Assign must be an object: %v", v)
+	}
+
+	for key := range m {
+		name := key
+		if ql != QueryLanguageJSONata {
+			name = strings.TrimSuffix(key, ".$")
+		}
+		if err := validateVariableName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if ql == QueryLanguageJSONata {
+		t, err := NewJSONataTemplate(v)
+		if err != nil {
+			return nil, err
+		}
+		return &Assign{jsonata: t, raw: v}, nil
+	}
+
+	t, err := NewPayloadTemplate(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Assign{payload: t, raw: v}, nil
+}
+
+// Resolve returns the values of the variables with JSONPath, where "$" is result.
+func (a *Assign) Resolve(ctx context.Context, coj *CtxObj, result interface{}) (map[string]interface{}, error) {
+	v, err := a.payload.Resolve(ctx, coj, result)
+	if err != nil {
+		if errors.Is(err, ErrIntrinsicFunctionFailed) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrQueryEvaluationFailed, err)
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// Eval returns the values of the variables with JSONata.
+func (a *Assign) Eval(ctx context.Context, vars JSONataVars) (map[string]interface{}, error) {
+	v, err := a.jsonata.Eval(ctx, vars)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+func (a *Assign) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.raw)
+}
@@ -0,0 +1,110 @@
+package compiler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVariables_Assign(t *testing.T) {
+	outer, err := (*Variables)(nil).Assign(map[string]interface{}{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal("Assign() failed:", err)
+	}
+
+	inner, err := outer.NewScope().Assign(map[string]interface{}{"c": 3})
+	if err != nil {
+		t.Fatal("Assign() failed:", err)
+	}
+	inner, err = inner.Assign(map[string]interface{}{"c": 4})
+	if err != nil {
+		t.Fatal("Assign() failed:", err)
+	}
+
+	if d := cmp.Diff(inner.All(), map[string]interface{}{"a": 1, "b": 2, "c": 4}); d != "" {
+		t.Errorf("All() failed: \n%s", d)
+	}
+	if _, ok := outer.Get("c"); ok {
+		t.Error("the variable of the inner scope is visible from the outer scope")
+	}
+
+	if _, err := inner.Assign(map[string]interface{}{"a": 5}); !errors.Is(err, ErrReadOnlyVariable) {
+		t.Errorf("Assign() error = %v, want %v", err, ErrReadOnlyVariable)
+	}
+	if v, _ := outer.Get("a"); v != 1 {
+		t.Errorf("the outer scope is modified: %v", v)
+	}
+}
+
+func TestUnjoinByPath_variable(t *testing.T) {
+	vars, err := (*Variables)(nil).Assign(map[string]interface{}{
+		"obj": map[string]interface{}{"arr": []interface{}{1.0, 2.0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coj := new(CtxObj).WithVariables(vars)
+
+	tests := []struct {
+		path    string
+		str     string
+		want    interface{}
+		wantErr error
+	}{
+		{"$obj", "$obj", map[string]interface{}{"arr": []interface{}{1.0, 2.0}}, nil},
+		{"$obj.arr[1]", "$obj.arr[1]", 2.0, nil},
+		{"$obj['arr'][0]", "$obj.arr[0]", 1.0, nil},
+		{"$missing", "$missing", nil, ErrUndefinedVariable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p, err := NewPath(tt.path)
+			if err != nil {
+				t.Fatal("NewPath() failed:", err)
+			}
+			if p.String() != tt.str {
+				t.Errorf("String() = %s, want %s", p.String(), tt.str)
+			}
+
+			got, err := UnjoinByPath(coj, map[string]interface{}{}, &p)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UnjoinByPath() error = %v, want %v", err, tt.wantErr)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("UnjoinByPath() failed: \n%s", d)
+			}
+		})
+	}
+}
+
+func TestCompile_assign(t *testing.T) {
+	tests := []struct {
+		name    string
+		asl     string
+		wantErr error
+	}{
+		{"valid", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Assign": {"a": 1, "b.$": "$.b", "c.$": "States.Format('{}', $a)"}, "End": true}}}`, nil},
+		{"valid(jsonata)", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Assign": {"a": "{% $states.input %}"}, "End": true}}}`, nil},
+		{"invalid name", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Assign": {"1a": 1}, "End": true}}}`, ErrInvalidVariableName},
+		{"reserved name", `{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Assign": {"states": 1}, "End": true}}}`, ErrInvalidVariableName},
+		{"invalid name in catcher", `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:x",
+			"Catch": [{"ErrorEquals": ["States.ALL"], "Assign": {"a-b": 1}, "Next": "T"}], "End": true}}}`, ErrInvalidVariableName},
+		{"invalid name in path", `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {"a.$": "$states.input"}, "End": true}}}`, ErrInvalidVariableName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(context.Background(), bytes.NewBufferString(tt.asl))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Compile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Compile(context.Background(), bytes.NewBufferString(
+		`{"StartAt": "P", "States": {"P": {"Type": "Pass", "ResultPath": "$a", "End": true}}}`)); err == nil {
+		t.Error("Compile() succeeded with ResultPath pointing to a variable")
+	}
+}
@@ -1,11 +1,16 @@
 package compiler
 
+import "fmt"
+
+var ErrInvalidWaitSeconds = fmt.Errorf("invalid wait seconds")
+
 type RawWaitState struct {
 	CommonState3
-	Seconds       *int    `json:"Seconds"`
-	Timestamp     *string `json:"Timestamp"`
-	SecondsPath   *string `json:"SecondsPath"`
-	TimestampPath *string `json:"TimestampPath"`
+	// RawSeconds is an integer, and Timestamp can be a JSONata expression as well in a JSONata state.
+	RawSeconds    interface{} `json:"Seconds"`
+	Timestamp     *string     `json:"Timestamp"`
+	SecondsPath   *string     `json:"SecondsPath"`
+	TimestampPath *string     `json:"TimestampPath"`
 }
 
 func (state RawWaitState) decode(name string) (State, error) {
@@ -14,17 +19,38 @@ func (state RawWaitState) decode(name string) (State, error) {
 		return nil, err
 	}
 
+	if state.IsJSONata() {
+		switch {
+		case state.SecondsPath != nil:
+			return nil, unsupportedFieldError("SecondsPath", state.QueryLanguage)
+		case state.TimestampPath != nil:
+			return nil, unsupportedFieldError("TimestampPath", state.QueryLanguage)
+		}
+	}
+
 	res := WaitState{
 		CommonState3: s.Common().CommonState3,
-		Seconds:      state.Seconds,
+	}
+
+	res.Seconds, res.SecondsExpr, err = newSecondsField("Seconds", state.RawSeconds, state.IsJSONata(), ErrInvalidWaitSeconds)
+	if err != nil {
+		return nil, err
 	}
 
 	if state.Timestamp != nil {
-		v, err := NewTimestamp(*state.Timestamp)
-		if err != nil {
-			return nil, err
+		if state.IsJSONata() && isJSONataExpr(*state.Timestamp) {
+			v, err := NewJSONataTemplate(*state.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Timestamp: %w", err)
+			}
+			res.TimestampExpr = v
+		} else {
+			v, err := NewTimestamp(*state.Timestamp)
+			if err != nil {
+				return nil, err
+			}
+			res.Timestamp = &v
 		}
-		res.Timestamp = &v
 	}
 
 	if state.SecondsPath != nil {
@@ -52,4 +78,8 @@ type WaitState struct {
 	Timestamp     *Timestamp
 	SecondsPath   *Path
 	TimestampPath *Path
+	// SecondsExpr and TimestampExpr are the JSONata expressions of a JSONata state,
+	// which are evaluated with the input of the state before it is run.
+	SecondsExpr   *JSONataTemplate
+	TimestampExpr *JSONataTemplate
 }
@@ -0,0 +1,224 @@
+package fn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// maxArrayRangeLength is the maximum number of elements that States.ArrayRange generates.
+const maxArrayRangeLength = 1000
+
+var ErrInvalidArguments = errors.New("invalid arguments")
+
+func DoStatesArrayPartition(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	array, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: the chunk size must be a positive integer: %d", ErrInvalidArguments, size)
+	}
+
+	result := make([]interface{}, 0, (len(array)+size-1)/size)
+	for i := 0; i < len(array); i += size {
+		end := i + size
+		if end > len(array) {
+			end = len(array)
+		}
+		chunk := make([]interface{}, end-i)
+		copy(chunk, array[i:end])
+		result = append(result, chunk)
+	}
+
+	return result, nil
+}
+
+func DoStatesArrayContains(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	array, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range array {
+		if equalJSON(v, args[1]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func DoStatesArrayRange(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 3); err != nil {
+		return nil, err
+	}
+
+	start, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := intArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("%w: the step must not be 0", ErrInvalidArguments)
+	}
+
+	result := []interface{}{}
+	for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+		if len(result) >= maxArrayRangeLength {
+			return nil, fmt.Errorf("%w: the range must not have more than %d elements", ErrInvalidArguments, maxArrayRangeLength)
+		}
+		result = append(result, i)
+	}
+
+	return result, nil
+}
+
+func DoStatesArrayGetItem(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	array, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(array) {
+		return nil, fmt.Errorf("%w: the index is out of range: %d", ErrInvalidArguments, index)
+	}
+
+	return array[index], nil
+}
+
+func DoStatesArrayLength(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 1); err != nil {
+		return nil, err
+	}
+
+	array, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return len(array), nil
+}
+
+func DoStatesArrayUnique(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 1); err != nil {
+		return nil, err
+	}
+
+	array, err := arrayArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	for _, v := range array {
+		unique := true
+		for _, u := range result {
+			if equalJSON(u, v) {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			result = append(result, v)
+		}
+	}
+
+	return result, nil
+}
+
+func checkArgsLen(args []interface{}, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%w: %d arguments are required, but %d are given", ErrInvalidArguments, n, len(args))
+	}
+	return nil
+}
+
+func arrayArg(args []interface{}, i int) ([]interface{}, error) {
+	v, ok := args[i].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument %d must be an array: %v", ErrInvalidArguments, i+1, args[i])
+	}
+	return v, nil
+}
+
+func intArg(args []interface{}, i int) (int, error) {
+	var f float64
+	switch v := args[i].(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		f = v
+	default:
+		return 0, fmt.Errorf("%w: the argument %d must be an integer: %v", ErrInvalidArguments, i+1, args[i])
+	}
+
+	if f != math.Trunc(f) || math.Abs(f) > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: the argument %d must be an integer: %v", ErrInvalidArguments, i+1, args[i])
+	}
+
+	return int(f), nil
+}
+
+// equalJSON reports whether v1 and v2 are the same JSON value.
+// Numbers are compared by their values, regardless of their Go types.
+func equalJSON(v1, v2 interface{}) bool {
+	return reflect.DeepEqual(normalizeNumbers(v1), normalizeNumbers(v2))
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalizeNumbers(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = normalizeNumbers(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
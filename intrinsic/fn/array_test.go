@@ -0,0 +1,59 @@
+package fn
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestArrayFunctions(t *testing.T) {
+	type fn func(context.Context, []interface{}) (interface{}, error)
+	array := []interface{}{1.0, 2.0, 3.0, 4.0, 5.0}
+	tests := []struct {
+		name    string
+		fn      fn
+		args    []interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"partition", DoStatesArrayPartition, []interface{}{array, 2}, []interface{}{[]interface{}{1.0, 2.0}, []interface{}{3.0, 4.0}, []interface{}{5.0}}, false},
+		{"partition(large chunk)", DoStatesArrayPartition, []interface{}{array, 10.0}, []interface{}{array}, false},
+		{"partition(empty)", DoStatesArrayPartition, []interface{}{[]interface{}{}, 2}, []interface{}{}, false},
+		{"partition(zero chunk)", DoStatesArrayPartition, []interface{}{array, 0}, nil, true},
+		{"partition(float chunk)", DoStatesArrayPartition, []interface{}{array, 1.5}, nil, true},
+		{"partition(not array)", DoStatesArrayPartition, []interface{}{"aaa", 2}, nil, true},
+		{"contains", DoStatesArrayContains, []interface{}{array, 3}, true, false},
+		{"contains(object)", DoStatesArrayContains, []interface{}{[]interface{}{map[string]interface{}{"a": 1.0}}, map[string]interface{}{"a": 1}}, true, false},
+		{"contains(not found)", DoStatesArrayContains, []interface{}{array, "3"}, false, false},
+		{"contains(arity)", DoStatesArrayContains, []interface{}{array}, nil, true},
+		{"range", DoStatesArrayRange, []interface{}{1, 9, 2}, []interface{}{1, 3, 5, 7, 9}, false},
+		{"range(descending)", DoStatesArrayRange, []interface{}{3, 1.0, -1}, []interface{}{3, 2, 1}, false},
+		{"range(empty)", DoStatesArrayRange, []interface{}{3, 1, 1}, []interface{}{}, false},
+		{"range(zero step)", DoStatesArrayRange, []interface{}{1, 9, 0}, nil, true},
+		{"range(too long)", DoStatesArrayRange, []interface{}{0, 1000, 1}, nil, true},
+		{"range(not integer)", DoStatesArrayRange, []interface{}{0, "9", 1}, nil, true},
+		{"get item", DoStatesArrayGetItem, []interface{}{array, 1}, 2.0, false},
+		{"get item(out of range)", DoStatesArrayGetItem, []interface{}{array, 5}, nil, true},
+		{"get item(negative)", DoStatesArrayGetItem, []interface{}{array, -1}, nil, true},
+		{"length", DoStatesArrayLength, []interface{}{array}, 5, false},
+		{"length(not array)", DoStatesArrayLength, []interface{}{map[string]interface{}{}}, nil, true},
+		{"unique", DoStatesArrayUnique, []interface{}{[]interface{}{1, 1.0, "1", true, "1", nil, nil}}, []interface{}{1, "1", true, nil}, false},
+		{"unique(arity)", DoStatesArrayUnique, []interface{}{array, array}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn(context.Background(), tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidArguments) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidArguments)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("failed: \n%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package fn
+
+import (
+	"context"
+	"crypto/md5"  // #nosec G501
+	"crypto/sha1" // #nosec G505
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"unicode/utf8"
+)
+
+// maxEncodingInputLength is the maximum number of characters of the data given to
+// States.Base64Encode, States.Base64Decode and States.Hash.
+const maxEncodingInputLength = 10000
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"MD5":     md5.New,  // #nosec G401
+	"SHA-1":   sha1.New, // #nosec G401
+	"SHA-256": sha256.New,
+	"SHA-384": sha512.New384,
+	"SHA-512": sha512.New,
+}
+
+func DoStatesBase64Encode(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 1); err != nil {
+		return nil, err
+	}
+
+	data, err := encodingInputArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(data)), nil
+}
+
+func DoStatesBase64Decode(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 1); err != nil {
+		return nil, err
+	}
+
+	data, err := encodingInputArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64 data: %v", ErrInvalidArguments, err)
+	}
+
+	return string(b), nil
+}
+
+func DoStatesHash(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	data, err := encodingInputArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument 2 must be a string: %v", ErrInvalidArguments, args[1])
+	}
+
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown hash algorithm: %s", ErrInvalidArguments, algorithm)
+	}
+
+	h := newHash()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func encodingInputArg(args []interface{}, i int) (string, error) {
+	str, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: the argument %d must be a string: %v", ErrInvalidArguments, i+1, args[i])
+	}
+
+	if utf8.RuneCountInString(str) > maxEncodingInputLength {
+		return "", fmt.Errorf("%w: the argument %d must not be longer than %d characters", ErrInvalidArguments, i+1, maxEncodingInputLength)
+	}
+
+	return str, nil
+}
@@ -0,0 +1,51 @@
+package fn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncodingFunctions(t *testing.T) {
+	type fn func(context.Context, []interface{}) (interface{}, error)
+	long := strings.Repeat("a", maxEncodingInputLength+1)
+	tests := []struct {
+		name    string
+		fn      fn
+		args    []interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"base64 encode", DoStatesBase64Encode, []interface{}{"Data to encode"}, "RGF0YSB0byBlbmNvZGU=", false},
+		{"base64 encode(too long)", DoStatesBase64Encode, []interface{}{long}, nil, true},
+		{"base64 encode(not string)", DoStatesBase64Encode, []interface{}{1}, nil, true},
+		{"base64 decode", DoStatesBase64Decode, []interface{}{"RGVjb2RlZCBkYXRh"}, "Decoded data", false},
+		{"base64 decode(invalid)", DoStatesBase64Decode, []interface{}{"!!!"}, nil, true},
+		{"base64 decode(too long)", DoStatesBase64Decode, []interface{}{long}, nil, true},
+		{"hash(MD5)", DoStatesHash, []interface{}{"input data", "MD5"}, "812f45842bc6d66ee14572ce20db8e86", false},
+		{"hash(SHA-1)", DoStatesHash, []interface{}{"input data", "SHA-1"}, "aaff4a450a104cd177d28d18d74485e8cae074b7", false},
+		{"hash(SHA-256)", DoStatesHash, []interface{}{"input data", "SHA-256"}, "b4a697a057313163aee33cd8d40c66e9f0f177e00cac2de32475ffff6169c3e3", false},
+		{"hash(SHA-384)", DoStatesHash, []interface{}{"input data", "SHA-384"}, "d28a7d5cf25a74f11a50a18452b75e04bb3d70c9dd0510d6123aa008c756511b87525bdc835ebb27e1fb9e9374a15562", false},
+		{"hash(SHA-512)", DoStatesHash, []interface{}{"input data", "SHA-512"}, "6ce4adb348546d4f449c4d25aad9a7c9cb711d9e91982d3f0b29ca2f3f47d4ce2deba23bf2954f0f1d593fc50283731a533d30d425402d4f91316d871303aac4", false},
+		{"hash(unknown algorithm)", DoStatesHash, []interface{}{"input data", "SHA-3"}, nil, true},
+		{"hash(too long)", DoStatesHash, []interface{}{long, "MD5"}, nil, true},
+		{"hash(arity)", DoStatesHash, []interface{}{"input data"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn(context.Background(), tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidArguments) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidArguments)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("failed: \n%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package fn
+
+import (
+	"context"
+	"fmt"
+)
+
+func DoStatesJsonMerge(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 3); err != nil {
+		return nil, err
+	}
+
+	obj1, err := objectArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	obj2, err := objectArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the shallow merge is supported, as in AWS
+	deep, ok := args[2].(bool)
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument 3 must be a boolean: %v", ErrInvalidArguments, args[2])
+	}
+	if deep {
+		return nil, fmt.Errorf("%w: the argument 3 must be false, since the deep merge is not supported", ErrInvalidArguments)
+	}
+
+	return mergeObjects(obj1, obj2), nil
+}
+
+// mergeObjects returns a new object that has the fields of both obj1 and obj2.
+// The fields of obj2 take precedence, and the nested objects are replaced by the ones of obj2.
+func mergeObjects(obj1, obj2 map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(obj1)+len(obj2))
+	for k, v := range obj1 {
+		result[k] = v
+	}
+	for k, v := range obj2 {
+		result[k] = v
+	}
+	return result
+}
+
+func objectArg(args []interface{}, i int) (map[string]interface{}, error) {
+	v, ok := args[i].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument %d must be an object: %v", ErrInvalidArguments, i+1, args[i])
+	}
+	return v, nil
+}
@@ -0,0 +1,41 @@
+package fn
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDoStatesJsonMerge(t *testing.T) {
+	obj1 := map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"x": 1.0, "y": 2.0}}
+	obj2 := map[string]interface{}{"c": 3.0, "b": map[string]interface{}{"y": 3.0, "z": 4.0}}
+	tests := []struct {
+		name    string
+		args    []interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"shallow", []interface{}{obj1, obj2, false}, map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"y": 3.0, "z": 4.0}, "c": 3.0}, false},
+		{"deep", []interface{}{obj1, obj2, true}, nil, true},
+		{"not object", []interface{}{obj1, []interface{}{}, false}, nil, true},
+		{"not boolean", []interface{}{obj1, obj2, "false"}, nil, true},
+		{"arity", []interface{}{obj1, obj2}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DoStatesJsonMerge(context.Background(), tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DoStatesJsonMerge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("DoStatesJsonMerge() failed: \n%s", d)
+			}
+		})
+	}
+
+	// the arguments are not modified
+	if d := cmp.Diff(obj1, map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"x": 1.0, "y": 2.0}}); d != "" {
+		t.Errorf("DoStatesJsonMerge() modified the argument: \n%s", d)
+	}
+}
@@ -0,0 +1,145 @@
+package fn
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type (
+	randKey          struct{}
+	uuidGeneratorKey struct{}
+)
+
+// lockedRand is a random number generator that can be shared by concurrent executions.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (r *lockedRand) int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+// WithRandSource returns a copy of ctx that makes States.MathRandom without a seed use src.
+func WithRandSource(ctx context.Context, src rand.Source) context.Context {
+	return context.WithValue(ctx, randKey{}, &lockedRand{r: rand.New(src)}) // #nosec G404
+}
+
+// WithUUIDGenerator returns a copy of ctx that makes States.UUID use newUUID.
+func WithUUIDGenerator(ctx context.Context, newUUID func() (string, error)) context.Context {
+	return context.WithValue(ctx, uuidGeneratorKey{}, newUUID)
+}
+
+func DoStatesMathRandom(ctx context.Context, args []interface{}) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("%w: 2 or 3 arguments are required, but %d are given", ErrInvalidArguments, len(args))
+	}
+
+	start, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if start >= end {
+		return nil, fmt.Errorf("%w: the start must be smaller than the end: %d, %d", ErrInvalidArguments, start, end)
+	}
+
+	n := int64(end) - int64(start)
+
+	// the same seed always generates the same number
+	if len(args) == 3 {
+		seed, err := intArg(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return start + int(rand.New(rand.NewSource(int64(seed))).Int63n(n)), nil // #nosec G404
+	}
+
+	if r, ok := ctx.Value(randKey{}).(*lockedRand); ok {
+		return start + int(r.int63n(n)), nil
+	}
+
+	return start + int(rand.Int63n(n)), nil // #nosec G404
+}
+
+func DoStatesMathAdd(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	v1, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	v2, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, v := range []int{v1, v2} {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: the argument %d is out of the range of 32-bit integers: %d", ErrInvalidArguments, i+1, v)
+		}
+	}
+
+	return v1 + v2, nil
+}
+
+func DoStatesStringSplit(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 2); err != nil {
+		return nil, err
+	}
+
+	str, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument 1 must be a string: %v", ErrInvalidArguments, args[0])
+	}
+
+	splitter, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: the argument 2 must be a string: %v", ErrInvalidArguments, args[1])
+	}
+
+	// every character of the splitter is a delimiter
+	fields := strings.FieldsFunc(str, func(r rune) bool {
+		return strings.ContainsRune(splitter, r)
+	})
+
+	result := make([]interface{}, len(fields))
+	for i, f := range fields {
+		result[i] = f
+	}
+
+	return result, nil
+}
+
+func DoStatesUUID(ctx context.Context, args []interface{}) (interface{}, error) {
+	if err := checkArgsLen(args, 0); err != nil {
+		return nil, err
+	}
+
+	if newUUID, ok := ctx.Value(uuidGeneratorKey{}).(func() (string, error)); ok {
+		return newUUID()
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	return id.String(), nil
+}
@@ -0,0 +1,99 @@
+package fn
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDoStatesMathRandom(t *testing.T) {
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		got, err := DoStatesMathRandom(ctx, []interface{}{1, 10.0})
+		if err != nil {
+			t.Fatal("DoStatesMathRandom() failed:", err)
+		}
+		if n := got.(int); n < 1 || n >= 10 {
+			t.Fatalf("DoStatesMathRandom() = %d, want [1, 10)", n)
+		}
+	}
+
+	seeded1, err := DoStatesMathRandom(ctx, []interface{}{0, 1000, 42})
+	if err != nil {
+		t.Fatal("DoStatesMathRandom() failed:", err)
+	}
+	seeded2, err := DoStatesMathRandom(ctx, []interface{}{0, 1000, 42})
+	if err != nil {
+		t.Fatal("DoStatesMathRandom() failed:", err)
+	}
+	if seeded1 != seeded2 {
+		t.Errorf("DoStatesMathRandom() with the same seed = %v, %v", seeded1, seeded2)
+	}
+
+	ctx1 := WithRandSource(ctx, rand.NewSource(1))
+	ctx2 := WithRandSource(ctx, rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		v1, _ := DoStatesMathRandom(ctx1, []interface{}{0, 1000})
+		v2, _ := DoStatesMathRandom(ctx2, []interface{}{0, 1000})
+		if v1 != v2 {
+			t.Fatalf("DoStatesMathRandom() with the same source = %v, %v", v1, v2)
+		}
+	}
+
+	for _, args := range [][]interface{}{{1}, {10, 1}, {1, 1}, {1, "10"}, {1, 10, 1.5}} {
+		if _, err := DoStatesMathRandom(ctx, args); !errors.Is(err, ErrInvalidArguments) {
+			t.Errorf("DoStatesMathRandom(%v) error = %v, want %v", args, err, ErrInvalidArguments)
+		}
+	}
+}
+
+func TestDoStatesUUID(t *testing.T) {
+	got, err := DoStatesUUID(context.Background(), []interface{}{})
+	if err != nil {
+		t.Fatal("DoStatesUUID() failed:", err)
+	}
+	if s, ok := got.(string); !ok || len(s) != 36 {
+		t.Errorf("DoStatesUUID() = %v", got)
+	}
+
+	ctx := WithUUIDGenerator(context.Background(), func() (string, error) { return "uuid", nil })
+	if got, err := DoStatesUUID(ctx, []interface{}{}); err != nil || got != "uuid" {
+		t.Errorf("DoStatesUUID() = %v, %v", got, err)
+	}
+
+	if _, err := DoStatesUUID(ctx, []interface{}{1}); !errors.Is(err, ErrInvalidArguments) {
+		t.Errorf("DoStatesUUID() error = %v, want %v", err, ErrInvalidArguments)
+	}
+}
+
+func TestMathAndStringFunctions(t *testing.T) {
+	type fn func(context.Context, []interface{}) (interface{}, error)
+	tests := []struct {
+		name    string
+		fn      fn
+		args    []interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"add", DoStatesMathAdd, []interface{}{111, -1.0}, 110, false},
+		{"add(not integer)", DoStatesMathAdd, []interface{}{1, 1.5}, nil, true},
+		{"add(out of range)", DoStatesMathAdd, []interface{}{1, 1 << 40}, nil, true},
+		{"split", DoStatesStringSplit, []interface{}{"1,2,3", ","}, []interface{}{"1", "2", "3"}, false},
+		{"split(splitters)", DoStatesStringSplit, []interface{}{"This.is+a,test=string", ".+,="}, []interface{}{"This", "is", "a", "test", "string"}, false},
+		{"split(not string)", DoStatesStringSplit, []interface{}{1, ","}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn(context.Background(), tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if d := cmp.Diff(got, tt.want); d != "" {
+				t.Errorf("failed: \n%s", d)
+			}
+		})
+	}
+}
@@ -16,7 +16,8 @@ func DoStatesFormat(ctx context.Context, args []interface{}) (interface{}, error
 		str2 = "\\{}"
 	)
 
-	if len(args) < 2 {
+	// the format string can be given alone if it has no {}
+	if len(args) < 1 {
 		return nil, ErrStatesFormatFailed
 	}
 
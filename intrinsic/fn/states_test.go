@@ -21,6 +21,9 @@ func TestDoStatesFormat(t *testing.T) {
 		{"int", []interface{}{"aaa={}", 111}, "aaa=111", false},
 		{"float", []interface{}{"aaa={}", 3.14}, "aaa=3.14", false},
 		{"all", []interface{}{"{}, {}, {}", "bbb", 111, 3.14}, "bbb, 111, 3.14", false},
+		{"no placeholders", []interface{}{"aaa"}, "aaa", false},
+		{"missing argument", []interface{}{"aaa={}"}, nil, true},
+		{"no arguments", []interface{}{}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -2,32 +2,122 @@ package intrinsic
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/w-haibara/kakemoti/intrinsic/fn"
 )
 
+var (
+	ErrUnknownFunction = errors.New("unknown intrinsic function")
+	ErrInvalidArity    = errors.New("invalid number of arguments")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
 type (
 	Fn    func(context.Context, []interface{}) (interface{}, error)
 	FnMap map[string]Fn
 )
 
-var fnMap FnMap
+// Arity is the number of arguments an intrinsic function takes.
+// Max is -1 if the function takes any number of arguments.
+type Arity struct {
+	Min, Max int
+}
+
+var (
+	fnMap    FnMap
+	arityMap map[string]Arity
+)
 
 func init() {
 	fnMap = make(FnMap)
+	arityMap = make(map[string]Arity)
 	RegisterDefault()
 }
 
 func RegisterDefault() {
-	Register("States.Format", fn.DoStatesFormat)
-	Register("States.StringToJson", fn.DoStatesStringToJson)
-	Register("States.JsonToString", fn.DoStatesJsonToString)
-	Register("States.Array", fn.DoStatesArray)
+	RegisterWithArity("States.Format", fn.DoStatesFormat, Arity{1, -1})
+	RegisterWithArity("States.StringToJson", fn.DoStatesStringToJson, Arity{1, 1})
+	RegisterWithArity("States.JsonToString", fn.DoStatesJsonToString, Arity{1, 1})
+	RegisterWithArity("States.Array", fn.DoStatesArray, Arity{0, -1})
+	RegisterWithArity("States.ArrayPartition", fn.DoStatesArrayPartition, Arity{2, 2})
+	RegisterWithArity("States.ArrayContains", fn.DoStatesArrayContains, Arity{2, 2})
+	RegisterWithArity("States.ArrayRange", fn.DoStatesArrayRange, Arity{3, 3})
+	RegisterWithArity("States.ArrayGetItem", fn.DoStatesArrayGetItem, Arity{2, 2})
+	RegisterWithArity("States.ArrayLength", fn.DoStatesArrayLength, Arity{1, 1})
+	RegisterWithArity("States.ArrayUnique", fn.DoStatesArrayUnique, Arity{1, 1})
+	RegisterWithArity("States.Base64Encode", fn.DoStatesBase64Encode, Arity{1, 1})
+	RegisterWithArity("States.Base64Decode", fn.DoStatesBase64Decode, Arity{1, 1})
+	RegisterWithArity("States.Hash", fn.DoStatesHash, Arity{2, 2})
+	RegisterWithArity("States.JsonMerge", fn.DoStatesJsonMerge, Arity{3, 3})
+	RegisterWithArity("States.MathRandom", fn.DoStatesMathRandom, Arity{2, 3})
+	RegisterWithArity("States.MathAdd", fn.DoStatesMathAdd, Arity{2, 2})
+	RegisterWithArity("States.StringSplit", fn.DoStatesStringSplit, Arity{2, 2})
+	RegisterWithArity("States.UUID", fn.DoStatesUUID, Arity{0, 0})
 }
 
+// Register registers fn that takes any number of arguments.
 func Register(name string, fn Fn) {
+	RegisterWithArity(name, fn, Arity{0, -1})
+}
+
+func RegisterWithArity(name string, fn Fn, arity Arity) {
 	fnMap[name] = fn
+	arityMap[name] = arity
+}
+
+// Arg is an argument of an intrinsic function at compile time.
+// Literal is true if its Value is known, such as a string or a boolean, and false for a path or a function call.
+type Arg struct {
+	Value   interface{}
+	Literal bool
+}
+
+// argCheckers check the literal arguments of the functions that do not accept some values.
+var argCheckers = map[string]func(args []Arg) error{
+	"States.JsonMerge": checkJsonMergeArgs,
+}
+
+// Check reports whether the function is registered and takes the arguments.
+// The values of the literal arguments are checked as well.
+func Check(fnname string, args []Arg) error {
+	arity, ok := arityMap[fnname]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFunction, fnname)
+	}
+
+	n := len(args)
+	if n < arity.Min || (arity.Max >= 0 && n > arity.Max) {
+		return fmt.Errorf("%w: %s takes %s, but %d are given", ErrInvalidArity, fnname, arity, n)
+	}
+
+	if check, ok := argCheckers[fnname]; ok {
+		if err := check(args); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidArgument, fnname, err)
+		}
+	}
+
+	return nil
+}
+
+// checkJsonMergeArgs rejects a deep merge, since the third argument of States.JsonMerge must be false in AWS.
+func checkJsonMergeArgs(args []Arg) error {
+	if args[2].Literal && args[2].Value != false {
+		return fmt.Errorf("the argument 3 must be false, but got %v", args[2].Value)
+	}
+	return nil
+}
+
+func (a Arity) String() string {
+	switch {
+	case a.Max < 0:
+		return fmt.Sprintf("at least %d arguments", a.Min)
+	case a.Min == a.Max:
+		return fmt.Sprintf("%d arguments", a.Min)
+	default:
+		return fmt.Sprintf("%d to %d arguments", a.Min, a.Max)
+	}
 }
 
 func Do(ctx context.Context, fnname string, args []interface{}) (interface{}, error) {
@@ -0,0 +1,623 @@
+package jsonata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// undefinedType is the type of undefined, which is the value of an expression that matches nothing.
+// It is different from null, and never appears in the results.
+type undefinedType struct{}
+
+var undefined = undefinedType{}
+
+// sequence is the result of a path that matches some values.
+// Unlike an array, a sequence of a single value is the value itself.
+type sequence []interface{}
+
+// newSequence returns the value of a sequence of items.
+func newSequence(items []interface{}) interface{} {
+	switch len(items) {
+	case 0:
+		return undefined
+	case 1:
+		return items[0]
+	default:
+		return sequence(items)
+	}
+}
+
+// plain converts the sequences in v into arrays.
+func plain(v interface{}) interface{} {
+	if s, ok := v.(sequence); ok {
+		arr := make([]interface{}, len(s))
+		copy(arr, s)
+		return arr
+	}
+	return v
+}
+
+type env struct {
+	vars   map[string]interface{}
+	parent *env
+}
+
+func newEnv(parent *env) *env {
+	return &env{vars: map[string]interface{}{}, parent: parent}
+}
+
+func (e *env) bind(name string, v interface{}) {
+	e.vars[name] = v
+}
+
+func (e *env) lookup(name string) (interface{}, bool) {
+	for c := e; c != nil; c = c.parent {
+		if v, ok := c.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+type evaluator struct {
+	ctx  context.Context
+	root interface{}
+}
+
+func (ev *evaluator) eval(n node, input interface{}, env *env) (interface{}, error) {
+	if err := ev.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	switch n := n.(type) {
+	case literalNode:
+		return n.v, nil
+	case nameNode:
+		return lookupField(input, n.name), nil
+	case variableNode:
+		switch n.name {
+		case "":
+			return input, nil
+		case "$":
+			return ev.root, nil
+		}
+		if v, ok := env.lookup(n.name); ok {
+			return v, nil
+		}
+		if f, ok := builtins[n.name]; ok {
+			return f, nil
+		}
+		return undefined, nil
+	case wildcardNode:
+		return wildcard(input), nil
+	case pathNode:
+		return ev.evalPath(n, input, env)
+	case filterNode:
+		v, err := ev.eval(n.expr, input, env)
+		if err != nil {
+			return nil, err
+		}
+		return ev.filter(v, n.pred, env)
+	case arrayNode:
+		return ev.evalArray(n, input, env)
+	case objectNode:
+		return ev.evalObject(n, input, env)
+	case blockNode:
+		scope := newEnv(env)
+		var v interface{} = undefined
+		for _, expr := range n.exprs {
+			var err error
+			if v, err = ev.eval(expr, input, scope); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case negateNode:
+		v, err := ev.eval(n.expr, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if v == undefined {
+			return undefined, nil
+		}
+		f, ok := toNumber(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate a non-numeric value: %v", plain(v))
+		}
+		return -f, nil
+	case binaryNode:
+		return ev.evalBinary(n, input, env)
+	case conditionNode:
+		cond, err := ev.eval(n.cond, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if toBoolean(cond) {
+			return ev.eval(n.then, input, env)
+		}
+		if n.els == nil {
+			return undefined, nil
+		}
+		return ev.eval(n.els, input, env)
+	case bindNode:
+		v, err := ev.eval(n.value, input, env)
+		if err != nil {
+			return nil, err
+		}
+		env.bind(n.name, v)
+		return v, nil
+	case callNode:
+		f, err := ev.eval(n.fn, input, env)
+		if err != nil {
+			return nil, err
+		}
+		args, err := ev.evalArgs(n.args, input, env)
+		if err != nil {
+			return nil, err
+		}
+		return ev.call(f, args, n.fn)
+	case lambdaNode:
+		return &lambda{params: n.params, body: n.body, env: env, input: input}, nil
+	default:
+		return nil, fmt.Errorf("unknown expression: %T", n)
+	}
+}
+
+func (ev *evaluator) evalArgs(nodes []node, input interface{}, env *env) ([]interface{}, error) {
+	args := make([]interface{}, len(nodes))
+	for i, arg := range nodes {
+		v, err := ev.eval(arg, input, env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+func (ev *evaluator) call(f interface{}, args []interface{}, name node) (interface{}, error) {
+	fn, ok := f.(callable)
+	if !ok {
+		if v, ok := name.(variableNode); ok {
+			return nil, fmt.Errorf("$%s is not a function", v.name)
+		}
+		return nil, errors.New("attempted to invoke a non-function")
+	}
+	return fn.call(ev, args)
+}
+
+func lookupField(input interface{}, name string) interface{} {
+	switch v := input.(type) {
+	case map[string]interface{}:
+		if e, ok := v[name]; ok {
+			return e
+		}
+		return undefined
+	case []interface{}:
+		return mapField(v, name)
+	case sequence:
+		return mapField(v, name)
+	default:
+		return undefined
+	}
+}
+
+func mapField(arr []interface{}, name string) interface{} {
+	items := []interface{}{}
+	for _, e := range arr {
+		items = appendFlat(items, lookupField(e, name))
+	}
+	return newSequence(items)
+}
+
+func wildcard(input interface{}) interface{} {
+	items := []interface{}{}
+	switch v := input.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			items = appendFlat(items, v[k])
+		}
+	case []interface{}:
+		for _, e := range v {
+			items = appendFlat(items, wildcard(e))
+		}
+	}
+	return newSequence(items)
+}
+
+// appendFlat appends v to items, expanding the arrays and the sequences.
+func appendFlat(items []interface{}, v interface{}) []interface{} {
+	switch v := v.(type) {
+	case undefinedType:
+		return items
+	case []interface{}:
+		return append(items, v...)
+	case sequence:
+		return append(items, v...)
+	default:
+		return append(items, v)
+	}
+}
+
+// evalPath evaluates each step against each value matched by the previous step.
+func (ev *evaluator) evalPath(n pathNode, input interface{}, env *env) (interface{}, error) {
+	var items []interface{}
+	if _, ok := n.steps[0].(variableNode); !ok {
+		items = toItems(input)
+	} else {
+		items = []interface{}{input}
+	}
+
+	var result interface{} = undefined
+	for _, step := range n.steps {
+		results := []interface{}{}
+		for _, item := range items {
+			v, err := ev.eval(step, item, env)
+			if err != nil {
+				return nil, err
+			}
+			if v != undefined {
+				results = append(results, v)
+			}
+		}
+
+		_, isArray := step.(arrayNode)
+		switch {
+		case len(results) == 1 && !isArray:
+			// a single array is kept as it is
+			result = results[0]
+		case isArray:
+			result = newSequence(results)
+		default:
+			flat := []interface{}{}
+			for _, v := range results {
+				flat = appendFlat(flat, v)
+			}
+			result = newSequence(flat)
+		}
+
+		if result == undefined {
+			return undefined, nil
+		}
+		items = toItems(result)
+	}
+
+	return result, nil
+}
+
+// toItems returns the values that the next step of a path is evaluated against.
+func toItems(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case undefinedType:
+		return nil
+	case []interface{}:
+		return v
+	case sequence:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// filter selects the items of v by pred, which is either an index or a condition.
+func (ev *evaluator) filter(v interface{}, pred node, env *env) (interface{}, error) {
+	items := toItems(v)
+
+	if lit, ok := pred.(literalNode); ok {
+		if f, ok := toNumber(lit.v); ok {
+			i := int(math.Floor(f))
+			if i < 0 {
+				i += len(items)
+			}
+			if i < 0 || i >= len(items) {
+				return undefined, nil
+			}
+			return items[i], nil
+		}
+	}
+
+	result := []interface{}{}
+	for i, item := range items {
+		p, err := ev.eval(pred, item, env)
+		if err != nil {
+			return nil, err
+		}
+
+		if f, ok := toNumber(p); ok {
+			index := int(math.Floor(f))
+			if index < 0 {
+				index += len(items)
+			}
+			if index == i {
+				result = append(result, item)
+			}
+			continue
+		}
+
+		if toBoolean(p) {
+			result = append(result, item)
+		}
+	}
+
+	return newSequence(result), nil
+}
+
+func (ev *evaluator) evalArray(n arrayNode, input interface{}, env *env) (interface{}, error) {
+	arr := []interface{}{}
+	for _, item := range n.items {
+		if r, ok := item.(binaryNode); ok && r.op == ".." {
+			v, err := ev.evalRange(r, input, env)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v...)
+			continue
+		}
+
+		v, err := ev.eval(item, input, env)
+		if err != nil {
+			return nil, err
+		}
+
+		// a nested array constructor makes a nested array, and the other arrays are flattened
+		if _, ok := item.(arrayNode); ok {
+			arr = append(arr, v)
+			continue
+		}
+		arr = appendFlat(arr, v)
+	}
+	return arr, nil
+}
+
+// maxRangeLength is the maximum number of the items that a range such as [1..10] generates.
+const maxRangeLength = 10000000
+
+func (ev *evaluator) evalRange(n binaryNode, input interface{}, env *env) ([]interface{}, error) {
+	lhs, rhs, err := ev.evalOperands(n, input, env)
+	if err != nil {
+		return nil, err
+	}
+	if lhs == undefined || rhs == undefined {
+		return nil, nil
+	}
+
+	start, ok1 := toNumber(lhs)
+	end, ok2 := toNumber(rhs)
+	if !ok1 || !ok2 || start != math.Trunc(start) || end != math.Trunc(end) {
+		return nil, errors.New("the operands of '..' must be integers")
+	}
+	if end-start >= maxRangeLength {
+		return nil, fmt.Errorf("the range must not have more than %d items", maxRangeLength)
+	}
+
+	items := []interface{}{}
+	for i := start; i <= end; i++ {
+		items = append(items, i)
+	}
+	return items, nil
+}
+
+func (ev *evaluator) evalObject(n objectNode, input interface{}, env *env) (interface{}, error) {
+	obj := make(map[string]interface{}, len(n.pairs))
+	for _, pair := range n.pairs {
+		k, err := ev.eval(pair.key, input, env)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("the key of an object must be a string: %v", plain(k))
+		}
+
+		v, err := ev.eval(pair.value, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if v == undefined {
+			continue
+		}
+		obj[key] = plain(v)
+	}
+	return obj, nil
+}
+
+func (ev *evaluator) evalOperands(n binaryNode, input interface{}, env *env) (interface{}, interface{}, error) {
+	lhs, err := ev.eval(n.lhs, input, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	rhs, err := ev.eval(n.rhs, input, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lhs, rhs, nil
+}
+
+func (ev *evaluator) evalBinary(n binaryNode, input interface{}, env *env) (interface{}, error) {
+	switch n.op {
+	case "and", "or":
+		lhs, err := ev.eval(n.lhs, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if l := toBoolean(lhs); (n.op == "and" && !l) || (n.op == "or" && l) {
+			return l, nil
+		}
+		rhs, err := ev.eval(n.rhs, input, env)
+		if err != nil {
+			return nil, err
+		}
+		return toBoolean(rhs), nil
+	case "??":
+		lhs, err := ev.eval(n.lhs, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if lhs != undefined {
+			return lhs, nil
+		}
+		return ev.eval(n.rhs, input, env)
+	case "~>":
+		lhs, err := ev.eval(n.lhs, input, env)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := n.rhs.(callNode); ok {
+			f, err := ev.eval(c.fn, input, env)
+			if err != nil {
+				return nil, err
+			}
+			args, err := ev.evalArgs(c.args, input, env)
+			if err != nil {
+				return nil, err
+			}
+			return ev.call(f, append([]interface{}{lhs}, args...), c.fn)
+		}
+		f, err := ev.eval(n.rhs, input, env)
+		if err != nil {
+			return nil, err
+		}
+		return ev.call(f, []interface{}{lhs}, n.rhs)
+	case "..":
+		items, err := ev.evalRange(n, input, env)
+		if err != nil {
+			return nil, err
+		}
+		return newSequence(items), nil
+	}
+
+	lhs, rhs, err := ev.evalOperands(n, input, env)
+	if err != nil {
+		return nil, err
+	}
+	lhs, rhs = plain(lhs), plain(rhs)
+
+	switch n.op {
+	case "+", "-", "*", "/", "%":
+		return arithmetic(n.op, lhs, rhs)
+	case "&":
+		l, err := toString(lhs)
+		if err != nil {
+			return nil, err
+		}
+		r, err := toString(rhs)
+		if err != nil {
+			return nil, err
+		}
+		return l + r, nil
+	case "=", "!=":
+		if lhs == undefined || rhs == undefined {
+			return false, nil
+		}
+		return deepEqual(lhs, rhs) == (n.op == "="), nil
+	case "<", "<=", ">", ">=":
+		return compare(n.op, lhs, rhs)
+	case "in":
+		if lhs == undefined || rhs == undefined {
+			return false, nil
+		}
+		for _, item := range toItems(rhs) {
+			if deepEqual(lhs, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %s", n.op)
+	}
+}
+
+func arithmetic(op string, lhs, rhs interface{}) (interface{}, error) {
+	if lhs == undefined || rhs == undefined {
+		return undefined, nil
+	}
+
+	l, ok1 := toNumber(lhs)
+	r, ok2 := toNumber(rhs)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("the operands of '%s' must be numbers: %v, %v", op, lhs, rhs)
+	}
+
+	var v float64
+	switch op {
+	case "+":
+		v = l + r
+	case "-":
+		v = l - r
+	case "*":
+		v = l * r
+	case "/":
+		v = l / r
+	case "%":
+		v = math.Mod(l, r)
+	}
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil, fmt.Errorf("the result of '%s' is out of range", op)
+	}
+	return v, nil
+}
+
+func compare(op string, lhs, rhs interface{}) (interface{}, error) {
+	if lhs == undefined || rhs == undefined {
+		return false, nil
+	}
+
+	var c int
+	l, ok1 := toNumber(lhs)
+	r, ok2 := toNumber(rhs)
+	ls, ok3 := lhs.(string)
+	rs, ok4 := rhs.(string)
+	switch {
+	case ok1 && ok2:
+		c = compareFloat(l, r)
+	case ok3 && ok4:
+		c = compareString(ls, rs)
+	default:
+		return nil, fmt.Errorf("the operands of '%s' must be both numbers or both strings: %v, %v", op, lhs, rhs)
+	}
+
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func compareFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareString(l, r string) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
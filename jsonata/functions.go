@@ -0,0 +1,873 @@
+package jsonata
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/w-haibara/kakemoti/intrinsic/fn"
+)
+
+type builtin struct {
+	name string
+	// min and max are the numbers of the arguments. max is -1 if the function takes any number of arguments.
+	min, max int
+	fn       func(ev *evaluator, args []interface{}) (interface{}, error)
+}
+
+func (b *builtin) call(ev *evaluator, args []interface{}) (interface{}, error) {
+	if len(args) < b.min || (b.max >= 0 && len(args) > b.max) {
+		return nil, fmt.Errorf("$%s: invalid number of arguments: %d", b.name, len(args))
+	}
+
+	plainArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		plainArgs[i] = plain(arg)
+	}
+
+	v, err := b.fn(ev, plainArgs)
+	if err != nil {
+		return nil, fmt.Errorf("$%s: %w", b.name, err)
+	}
+	return v, nil
+}
+
+func (b *builtin) arity() int {
+	return b.max
+}
+
+var builtins map[string]*builtin
+
+func init() {
+	builtins = make(map[string]*builtin)
+	for _, b := range []*builtin{
+		// string functions
+		{"string", 1, 1, fnString},
+		{"length", 1, 1, fnLength},
+		{"substring", 2, 3, fnSubstring},
+		{"substringBefore", 2, 2, fnSubstringBefore},
+		{"substringAfter", 2, 2, fnSubstringAfter},
+		{"uppercase", 1, 1, fnUppercase},
+		{"lowercase", 1, 1, fnLowercase},
+		{"trim", 1, 1, fnTrim},
+		{"contains", 2, 2, fnContains},
+		{"split", 2, 3, fnSplit},
+		{"join", 1, 2, fnJoin},
+		{"replace", 3, 4, fnReplace},
+		{"base64encode", 1, 1, fnBase64Encode},
+		{"base64decode", 1, 1, fnBase64Decode},
+		// numeric functions
+		{"number", 1, 1, fnNumber},
+		{"abs", 1, 1, mathFn(math.Abs)},
+		{"floor", 1, 1, mathFn(math.Floor)},
+		{"ceil", 1, 1, mathFn(math.Ceil)},
+		{"round", 1, 2, fnRound},
+		{"power", 2, 2, fnPower},
+		{"sqrt", 1, 1, fnSqrt},
+		{"sum", 1, 1, fnSum},
+		{"max", 1, 1, fnMax},
+		{"min", 1, 1, fnMin},
+		{"average", 1, 1, fnAverage},
+		// boolean functions
+		{"boolean", 1, 1, fnBoolean},
+		{"not", 1, 1, fnNot},
+		{"exists", 1, 1, fnExists},
+		// array functions
+		{"count", 1, 1, fnCount},
+		{"append", 2, 2, fnAppend},
+		{"reverse", 1, 1, fnReverse},
+		{"sort", 1, 2, fnSort},
+		{"distinct", 1, 1, fnDistinct},
+		// object functions
+		{"keys", 1, 1, fnKeys},
+		{"lookup", 2, 2, fnLookup},
+		{"merge", 1, 1, fnMerge},
+		{"each", 2, 2, fnEach},
+		{"type", 1, 1, fnType},
+		{"error", 0, 1, fnError},
+		{"assert", 1, 2, fnAssert},
+		// higher-order functions
+		{"map", 2, 2, fnMap},
+		{"filter", 2, 2, fnFilter},
+		{"reduce", 2, 3, fnReduce},
+		// date and time functions
+		{"now", 0, 0, fnNow},
+		{"millis", 0, 0, fnMillis},
+		{"fromMillis", 1, 1, fnFromMillis},
+		{"toMillis", 1, 1, fnToMillis},
+		// functions added by AWS Step Functions
+		// ref: https://docs.aws.amazon.com/step-functions/latest/dg/transforming-data.html
+		{"partition", 2, 2, intrinsicFn(fn.DoStatesArrayPartition)},
+		{"range", 3, 3, intrinsicFn(fn.DoStatesArrayRange)},
+		{"hash", 2, 2, intrinsicFn(fn.DoStatesHash)},
+		{"random", 0, 1, fnRandom},
+		{"uuid", 0, 0, intrinsicFn(fn.DoStatesUUID)},
+		{"parse", 1, 1, fnParse},
+	} {
+		builtins[b.name] = b
+	}
+}
+
+// intrinsicFn makes a JSONata function of an intrinsic function, which takes the same arguments.
+func intrinsicFn(f func(ctx context.Context, args []interface{}) (interface{}, error)) func(*evaluator, []interface{}) (interface{}, error) {
+	return func(ev *evaluator, args []interface{}) (interface{}, error) {
+		for _, arg := range args {
+			if arg == undefined {
+				return undefined, nil
+			}
+		}
+		return f(ev.ctx, args)
+	}
+}
+
+func stringArg(args []interface{}, i int) (string, bool, error) {
+	if i >= len(args) || args[i] == undefined {
+		return "", false, nil
+	}
+	s, ok := args[i].(string)
+	if !ok {
+		return "", false, fmt.Errorf("the argument %d must be a string: %v", i+1, args[i])
+	}
+	return s, true, nil
+}
+
+func numberArg(args []interface{}, i int) (float64, bool, error) {
+	if i >= len(args) || args[i] == undefined {
+		return 0, false, nil
+	}
+	f, ok := toNumber(args[i])
+	if !ok {
+		return 0, false, fmt.Errorf("the argument %d must be a number: %v", i+1, args[i])
+	}
+	return f, true, nil
+}
+
+func functionArg(args []interface{}, i int) (callable, error) {
+	f, ok := args[i].(callable)
+	if !ok {
+		return nil, fmt.Errorf("the argument %d must be a function", i+1)
+	}
+	return f, nil
+}
+
+func fnString(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	return toString(args[0])
+}
+
+func fnLength(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return float64(utf8.RuneCountInString(s)), nil
+}
+
+func fnSubstring(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	start, _, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := []rune(s)
+	i := int(start)
+	if i < 0 {
+		i += len(rs)
+		if i < 0 {
+			i = 0
+		}
+	}
+	if i > len(rs) {
+		i = len(rs)
+	}
+
+	end := len(rs)
+	length, ok, err := numberArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if length <= 0 {
+			return "", nil
+		}
+		if i+int(length) < end {
+			end = i + int(length)
+		}
+	}
+
+	return string(rs[i:end]), nil
+}
+
+func fnSubstringBefore(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	chars, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.Index(s, chars); i >= 0 {
+		return s[:i], nil
+	}
+	return s, nil
+}
+
+func fnSubstringAfter(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	chars, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.Index(s, chars); i >= 0 {
+		return s[i+len(chars):], nil
+	}
+	return s, nil
+}
+
+func fnUppercase(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return strings.ToUpper(s), nil
+}
+
+func fnLowercase(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return strings.ToLower(s), nil
+}
+
+// fnTrim removes the leading and trailing spaces, and replaces the other runs of spaces with a single space.
+func fnTrim(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return strings.Join(strings.Fields(s), " "), nil
+}
+
+func fnContains(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	pattern, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Contains(s, pattern), nil
+}
+
+func fnSplit(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	sep, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(s, sep)
+	if limit, ok, err := numberArg(args, 2); err != nil {
+		return nil, err
+	} else if ok && int(limit) < len(parts) {
+		if limit < 0 {
+			return nil, errors.New("the limit must not be negative")
+		}
+		parts = parts[:int(limit)]
+	}
+
+	result := make([]interface{}, len(parts))
+	for i, p := range parts {
+		result[i] = p
+	}
+	return result, nil
+}
+
+func fnJoin(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	sep, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	items := toItems(args[0])
+	strs := make([]string, len(items))
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("the argument 1 must be an array of strings: %v", args[0])
+		}
+		strs[i] = s
+	}
+	return strings.Join(strs, sep), nil
+}
+
+func fnReplace(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	pattern, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		return nil, errors.New("the pattern must not be empty")
+	}
+	replacement, _, err := stringArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	n := -1
+	if limit, ok, err := numberArg(args, 3); err != nil {
+		return nil, err
+	} else if ok {
+		if limit < 0 {
+			return nil, errors.New("the limit must not be negative")
+		}
+		n = int(limit)
+	}
+	return strings.Replace(s, pattern, replacement, n), nil
+}
+
+func fnBase64Encode(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s)), nil
+}
+
+func fnBase64Decode(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func fnNumber(ev *evaluator, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case undefinedType:
+		return undefined, nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case string:
+		var f float64
+		if err := json.Unmarshal([]byte(v), &f); err != nil {
+			return nil, fmt.Errorf("unable to cast the value to a number: %q", v)
+		}
+		return f, nil
+	default:
+		if f, ok := toNumber(v); ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("unable to cast the value to a number: %v", v)
+	}
+}
+
+func mathFn(f func(float64) float64) func(*evaluator, []interface{}) (interface{}, error) {
+	return func(ev *evaluator, args []interface{}) (interface{}, error) {
+		n, ok, err := numberArg(args, 0)
+		if !ok {
+			return undefined, err
+		}
+		return f(n), nil
+	}
+}
+
+// fnRound rounds half to even, as JSONata does.
+// The digits are shifted in the decimal representation, so that $round(1.255, 2) is 1.26 as it is written.
+func fnRound(ev *evaluator, args []interface{}) (interface{}, error) {
+	n, ok, err := numberArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	precision, _, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	shifted, err := shiftDecimal(n, int(precision))
+	if err != nil {
+		return nil, err
+	}
+	return shiftDecimal(math.RoundToEven(shifted), -int(precision))
+}
+
+// shiftDecimal returns n * 10^places, computed on the shortest decimal representation of n.
+func shiftDecimal(n float64, places int) (float64, error) {
+	s := strconv.FormatFloat(n, 'e', -1, 64)
+	i := strings.IndexByte(s, 'e')
+	exp, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s[:i]+"e"+strconv.Itoa(exp+places), 64)
+}
+
+func fnPower(ev *evaluator, args []interface{}) (interface{}, error) {
+	base, ok, err := numberArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	exp, _, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	v := math.Pow(base, exp)
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil, errors.New("the result is out of range")
+	}
+	return v, nil
+}
+
+func fnSqrt(ev *evaluator, args []interface{}) (interface{}, error) {
+	n, ok, err := numberArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	if n < 0 {
+		return nil, errors.New("the argument must not be negative")
+	}
+	return math.Sqrt(n), nil
+}
+
+func numbersArg(args []interface{}, i int) ([]float64, error) {
+	items := toItems(args[i])
+	nums := make([]float64, len(items))
+	for j, item := range items {
+		f, ok := toNumber(item)
+		if !ok {
+			return nil, fmt.Errorf("the argument %d must be an array of numbers: %v", i+1, args[i])
+		}
+		nums[j] = f
+	}
+	return nums, nil
+}
+
+func fnSum(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	nums, err := numbersArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	sum := 0.0
+	for _, n := range nums {
+		sum += n
+	}
+	return sum, nil
+}
+
+func fnMax(ev *evaluator, args []interface{}) (interface{}, error) {
+	nums, err := numbersArg(args, 0)
+	if err != nil || len(nums) == 0 {
+		return undefined, err
+	}
+	max := nums[0]
+	for _, n := range nums[1:] {
+		max = math.Max(max, n)
+	}
+	return max, nil
+}
+
+func fnMin(ev *evaluator, args []interface{}) (interface{}, error) {
+	nums, err := numbersArg(args, 0)
+	if err != nil || len(nums) == 0 {
+		return undefined, err
+	}
+	min := nums[0]
+	for _, n := range nums[1:] {
+		min = math.Min(min, n)
+	}
+	return min, nil
+}
+
+func fnAverage(ev *evaluator, args []interface{}) (interface{}, error) {
+	nums, err := numbersArg(args, 0)
+	if err != nil || len(nums) == 0 {
+		return undefined, err
+	}
+	sum := 0.0
+	for _, n := range nums {
+		sum += n
+	}
+	return sum / float64(len(nums)), nil
+}
+
+func fnBoolean(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	return toBoolean(args[0]), nil
+}
+
+func fnNot(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	return !toBoolean(args[0]), nil
+}
+
+func fnExists(ev *evaluator, args []interface{}) (interface{}, error) {
+	return args[0] != undefined, nil
+}
+
+func fnCount(ev *evaluator, args []interface{}) (interface{}, error) {
+	return float64(len(toItems(args[0]))), nil
+}
+
+func fnAppend(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[1] == undefined {
+		return args[0], nil
+	}
+	if args[0] == undefined {
+		return args[1], nil
+	}
+
+	result := []interface{}{}
+	result = append(result, toItems(args[0])...)
+	result = append(result, toItems(args[1])...)
+	return result, nil
+}
+
+func fnReverse(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	items := toItems(args[0])
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[len(items)-1-i] = item
+	}
+	return result, nil
+}
+
+func fnSort(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	result := append([]interface{}{}, toItems(args[0])...)
+
+	var sortErr error
+	if len(args) > 1 {
+		f, err := functionArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		// the function returns true if the first argument should come after the second one
+		sort.SliceStable(result, func(i, j int) bool {
+			if sortErr != nil {
+				return false
+			}
+			v, err := callN(ev, f, result[j], result[i])
+			if err != nil {
+				sortErr = err
+				return false
+			}
+			return toBoolean(v)
+		})
+		return result, sortErr
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		l, ok1 := toNumber(result[i])
+		r, ok2 := toNumber(result[j])
+		if ok1 && ok2 {
+			return l < r
+		}
+		ls, ok3 := result[i].(string)
+		rs, ok4 := result[j].(string)
+		if ok3 && ok4 {
+			return ls < rs
+		}
+		sortErr = errors.New("the array must contain only numbers or only strings")
+		return false
+	})
+	return result, sortErr
+}
+
+func fnDistinct(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	result := []interface{}{}
+	for _, item := range toItems(args[0]) {
+		unique := true
+		for _, r := range result {
+			if deepEqual(r, item) {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+func fnKeys(ev *evaluator, args []interface{}) (interface{}, error) {
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, item := range toItems(args[0]) {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, k := range sortedKeys(obj) {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return newSequence(keys), nil
+}
+
+func fnLookup(ev *evaluator, args []interface{}) (interface{}, error) {
+	key, _, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return lookupField(args[0], key), nil
+}
+
+func fnMerge(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	result := map[string]interface{}{}
+	for _, item := range toItems(args[0]) {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the argument 1 must be an array of objects: %v", args[0])
+		}
+		for k, v := range obj {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func fnEach(ev *evaluator, args []interface{}) (interface{}, error) {
+	obj, ok := args[0].(map[string]interface{})
+	if !ok {
+		return undefined, nil
+	}
+	f, err := functionArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	for _, k := range sortedKeys(obj) {
+		v, err := callN(ev, f, obj[k], k, obj)
+		if err != nil {
+			return nil, err
+		}
+		if v != undefined {
+			result = append(result, v)
+		}
+	}
+	return newSequence(result), nil
+}
+
+func fnType(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	return typeName(args[0]), nil
+}
+
+func fnError(ev *evaluator, args []interface{}) (interface{}, error) {
+	msg, ok, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		msg = "$error() function evaluated"
+	}
+	return nil, errors.New(msg)
+}
+
+func fnAssert(ev *evaluator, args []interface{}) (interface{}, error) {
+	if toBoolean(args[0]) {
+		return undefined, nil
+	}
+	msg, ok, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		msg = "$assert() statement failed"
+	}
+	return nil, errors.New(msg)
+}
+
+func fnMap(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	f, err := functionArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	items := toItems(args[0])
+	result := []interface{}{}
+	for i, item := range items {
+		v, err := callN(ev, f, item, float64(i), items)
+		if err != nil {
+			return nil, err
+		}
+		if v != undefined {
+			result = append(result, plain(v))
+		}
+	}
+	return newSequence(result), nil
+}
+
+func fnFilter(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	f, err := functionArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	items := toItems(args[0])
+	result := []interface{}{}
+	for i, item := range items {
+		v, err := callN(ev, f, item, float64(i), items)
+		if err != nil {
+			return nil, err
+		}
+		if toBoolean(v) {
+			result = append(result, item)
+		}
+	}
+	return newSequence(result), nil
+}
+
+func fnReduce(ev *evaluator, args []interface{}) (interface{}, error) {
+	if args[0] == undefined {
+		return undefined, nil
+	}
+	f, err := functionArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	items := toItems(args[0])
+	var acc interface{} = undefined
+	start := 0
+	if len(args) > 2 {
+		acc = args[2]
+	} else if len(items) > 0 {
+		acc = items[0]
+		start = 1
+	}
+
+	for i := start; i < len(items); i++ {
+		v, err := callN(ev, f, acc, items[i], float64(i), items)
+		if err != nil {
+			return nil, err
+		}
+		acc = plain(v)
+	}
+	return acc, nil
+}
+
+// timeFormat is the format of the timestamps returned by $now and $fromMillis.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+func fnNow(ev *evaluator, args []interface{}) (interface{}, error) {
+	return time.Now().UTC().Format(timeFormat), nil
+}
+
+func fnMillis(ev *evaluator, args []interface{}) (interface{}, error) {
+	return float64(time.Now().UnixNano() / int64(time.Millisecond)), nil
+}
+
+func fnFromMillis(ev *evaluator, args []interface{}) (interface{}, error) {
+	n, ok, err := numberArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	return time.Unix(0, int64(n)*int64(time.Millisecond)).UTC().Format(timeFormat), nil
+}
+
+func fnToMillis(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+	return float64(t.UnixNano() / int64(time.Millisecond)), nil
+}
+
+// fnRandom returns a random number in [0, 1). The same seed always gives the same number.
+func fnRandom(ev *evaluator, args []interface{}) (interface{}, error) {
+	seed, ok, err := numberArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return rand.New(rand.NewSource(int64(seed))).Float64(), nil // #nosec G404
+	}
+	return rand.Float64(), nil // #nosec G404
+}
+
+func fnParse(ev *evaluator, args []interface{}) (interface{}, error) {
+	s, ok, err := stringArg(args, 0)
+	if !ok {
+		return undefined, err
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,165 @@
+// Package jsonata implements the subset of JSONata used by the states whose QueryLanguage is "JSONata".
+// ref: https://docs.jsonata.org/overview
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/transforming-data.html
+package jsonata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrSyntax     = errors.New("invalid JSONata expression")
+	ErrEvaluation = errors.New("JSONata evaluation failed")
+	ErrUndefined  = errors.New("JSONata expression returned no value")
+)
+
+// Expression is a JSONata expression parsed at compile time.
+// It is never modified after it is parsed, so it can be shared by concurrent evaluations.
+type Expression struct {
+	root node
+	src  string
+}
+
+// Compile parses src into an expression.
+func Compile(src string) (*Expression, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrSyntax, src, err)
+	}
+
+	p := parser{tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrSyntax, src, err)
+	}
+	if err := checkFunctions(root); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrSyntax, src, err)
+	}
+
+	return &Expression{root: root, src: src}, nil
+}
+
+func MustCompile(src string) *Expression {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err.Error())
+	}
+	return e
+}
+
+// Eval evaluates the expression against input.
+// vars are bound to the variables, such as {"states": ...} for $states.
+// ErrUndefined is returned if the expression has no value, for example when it refers to a missing field.
+func (e *Expression) Eval(ctx context.Context, input interface{}, vars map[string]interface{}) (interface{}, error) {
+	ev := evaluator{ctx: ctx, root: input}
+	env := newEnv(nil)
+	for name, v := range vars {
+		env.bind(name, v)
+	}
+
+	v, err := ev.eval(e.root, input, env)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrEvaluation, e.src, err)
+	}
+
+	v = plain(v)
+	if v == undefined {
+		return nil, fmt.Errorf("%w: %s", ErrUndefined, e.src)
+	}
+	if _, ok := v.(callable); ok {
+		return nil, fmt.Errorf("%w: %s: a function is not a JSON value", ErrEvaluation, e.src)
+	}
+
+	return v, nil
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+// checkFunctions rejects the calls of the functions that are neither built in nor bound in the expression,
+// so that a function missing from the supported subset fails at compile time rather than at evaluation time.
+func checkFunctions(root node) error {
+	bound := make(map[string]bool)
+	walk(root, func(n node) {
+		switch n := n.(type) {
+		case bindNode:
+			bound[n.name] = true
+		case lambdaNode:
+			for _, param := range n.params {
+				bound[param] = true
+			}
+		}
+	})
+
+	var err error
+	check := func(fn node) {
+		v, ok := fn.(variableNode)
+		if !ok || err != nil || bound[v.name] {
+			return
+		}
+		if _, ok := builtins[v.name]; !ok {
+			err = fmt.Errorf("unknown function: $%s", v.name)
+		}
+	}
+	walk(root, func(n node) {
+		switch n := n.(type) {
+		case callNode:
+			check(n.fn)
+		case binaryNode:
+			if n.op == "~>" {
+				check(n.rhs)
+			}
+		}
+	})
+	return err
+}
+
+// walk calls f for n and all the nodes in it.
+func walk(n node, f func(node)) {
+	f(n)
+	switch n := n.(type) {
+	case pathNode:
+		for _, step := range n.steps {
+			walk(step, f)
+		}
+	case filterNode:
+		walk(n.expr, f)
+		walk(n.pred, f)
+	case arrayNode:
+		for _, item := range n.items {
+			walk(item, f)
+		}
+	case objectNode:
+		for _, pair := range n.pairs {
+			walk(pair.key, f)
+			walk(pair.value, f)
+		}
+	case blockNode:
+		for _, e := range n.exprs {
+			walk(e, f)
+		}
+	case negateNode:
+		walk(n.expr, f)
+	case binaryNode:
+		walk(n.lhs, f)
+		walk(n.rhs, f)
+	case conditionNode:
+		walk(n.cond, f)
+		walk(n.then, f)
+		if n.els != nil {
+			walk(n.els, f)
+		}
+	case bindNode:
+		walk(n.value, f)
+	case callNode:
+		walk(n.fn, f)
+		for _, arg := range n.args {
+			walk(arg, f)
+		}
+	case lambdaNode:
+		walk(n.body, f)
+	}
+}
@@ -0,0 +1,162 @@
+package jsonata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpression_Eval(t *testing.T) {
+	input := `{
+		"name": "kakemoti",
+		"n": 3,
+		"flag": true,
+		"nil": null,
+		"items": [{"id": 1, "tags": ["a", "b"]}, {"id": 2, "tags": ["c"]}, {"id": 3, "tags": []}],
+		"single": [5],
+		"nested": {"a": {"b": "c"}}
+	}`
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"literal", `"str"`, `"str"`},
+		{"number", `1.5e1`, `15`},
+		{"field", `name`, `"kakemoti"`},
+		{"nested field", `nested.a.b`, `"c"`},
+		{"null field", `nil`, `null`},
+		{"context", `$.n`, `3`},
+		{"root", `items.$$.n`, `[3, 3, 3]`},
+		{"map over array", `items.id`, `[1, 2, 3]`},
+		{"flatten", `items.tags`, `["a", "b", "c"]`},
+		{"single array is kept", `single`, `[5]`},
+		{"index", `items[1].id`, `2`},
+		{"negative index", `items[-1].id`, `3`},
+		{"index of each step", `items.tags[0]`, `["a", "c"]`},
+		{"predicate", `items[id > 1].id`, `[2, 3]`},
+		{"predicate of a single item", `items[id = 2].tags`, `["c"]`},
+		{"wildcard", `nested.*.b`, `"c"`},
+		{"arithmetic", `n * 2 + 1 - 4 / 2 % 3`, `5`},
+		{"negation", `-n`, `-3`},
+		{"concatenation", `name & "-" & n & flag`, `"kakemoti-3true"`},
+		{"comparison", `[n = 3, n != 3, n < 4, n <= 2, name > "a", name >= "z"]`, `[true, false, true, false, true, false]`},
+		{"deep equal", `items[0].tags = ["a", "b"]`, `true`},
+		{"boolean operators", `flag and n > 1 or false`, `true`},
+		{"in", `"b" in items.tags`, `true`},
+		{"condition", `n > 1 ? "many" : "one"`, `"many"`},
+		{"condition without else", `{"v": n > 5 ? "many"}`, `{}`},
+		{"coalescing", `missing ?? "default"`, `"default"`},
+		{"array constructor", `[n, [1, 2], items.id]`, `[3, [1, 2], 1, 2, 3]`},
+		{"range", `[1..3, 5]`, `[1, 2, 3, 5]`},
+		{"object constructor", `{"name": name, "missing": missing, "ids": items.id}`, `{"name": "kakemoti", "ids": [1, 2, 3]}`},
+		{"object constructor in a path", `items.{"id": id}`, `[{"id": 1}, {"id": 2}, {"id": 3}]`},
+		{"block and binding", `($x := n; $y := $x * 2; $x + $y)`, `9`},
+		{"variable", `$states.input.n`, `3`},
+		{"lambda", `($double := function($v) { $v * 2 }; $double(n))`, `6`},
+		{"chain", `items.id ~> $sum()`, `6`},
+		{"higher-order functions", `[$map(items, function($v, $i) { $v.id + $i }), $filter(items.id, function($v) { $v > 1 }), $reduce(items.id, function($a, $b) { $a + $b })]`, `[1, 3, 5, 2, 3, 6]`},
+		{"string functions", `[$string(n), $length(name), $substring(name, 2, 3), $substringBefore(name, "m"), $substringAfter(name, "m"), $uppercase(name), $trim("  a   b ")]`, `["3", 8, "kem", "kake", "oti", "KAKEMOTI", "a b"]`},
+		{"split and join", `$join($split("a,b,c", ","), "-")`, `"a-b-c"`},
+		{"replace", `$replace(name, "k", "K")`, `"KaKemoti"`},
+		{"string of an object", `$string(nested)`, `"{\"a\":{\"b\":\"c\"}}"`},
+		{"numeric functions", `[$number("1.5"), $abs(-2), $floor(1.5), $ceil(1.5), $round(2.5), $round(1.255, 2), $round(-2.5), $round(1250, -2), $power(2, 3), $sqrt(4)]`, `[1.5, 2, 1, 2, 2, 1.26, -2, 1200, 8, 2]`},
+		{"aggregation", `[$sum(items.id), $max(items.id), $min(items.id), $average(items.id), $count(items)]`, `[6, 3, 1, 2, 3]`},
+		{"boolean functions", `[$boolean(name), $not(flag), $exists(missing), $exists(nil)]`, `[true, false, false, true]`},
+		{"array functions", `{"append": $append([1], 2), "reverse": $reverse([1, 2]), "sort": $sort([3, 1, 2]), "distinct": $distinct([1, 1, 2])}`, `{"append": [1, 2], "reverse": [2, 1], "sort": [1, 2, 3], "distinct": [1, 2]}`},
+		{"nested array functions are flattened", `[$append([1], 2), $reverse([3, 4])]`, `[1, 2, 4, 3]`},
+		{"sort with a function", `$sort(items, function($l, $r) { $l.id < $r.id }).id`, `[3, 2, 1]`},
+		{"object functions", `[$keys(nested.a), $lookup(nested, "a").b, $merge([{"a": 1}, {"b": 2}]), $type(items)]`, `["b", "c", {"a": 1, "b": 2}, "array"]`},
+		{"partition", `$partition([1, 2, 3], 2)`, `[[1, 2], [3]]`},
+		{"range function", `$range(0, 4, 2)`, `[0, 2, 4]`},
+		{"hash", `$hash("input data", "SHA-1")`, `"aaff4a450a104cd177d28d18d74485e8cae074b7"`},
+		{"parse", `$parse('{"a": [1]}').a`, `[1]`},
+		{"seeded random", `$random(1) = $random(1)`, `true`},
+		{"comment", `/* comment */ n`, `3`},
+		{"backtick name", "nested.`a`.b", `"c"`},
+	}
+	var in interface{}
+	if err := json.Unmarshal([]byte(input), &in); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]interface{}{"states": map[string]interface{}{"input": in}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal("Compile() failed:", err)
+			}
+
+			got, err := e.Eval(context.Background(), in, vars)
+			if err != nil {
+				t.Fatal("Eval() failed:", err)
+			}
+
+			var want interface{}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(normalize(got), want); d != "" {
+				t.Errorf("Eval() failed: \n%s", d)
+			}
+		})
+	}
+}
+
+func TestExpression_Eval_error(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want error
+	}{
+		{"undefined", `missing`, ErrUndefined},
+		{"type error", `"a" + 1`, ErrEvaluation},
+		{"comparison of different types", `"a" < 1`, ErrEvaluation},
+		{"not a function", `($f := 1; $f())`, ErrEvaluation},
+		{"error function", `$error("boom")`, ErrEvaluation},
+		{"function result", `function($x) { $x }`, ErrEvaluation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal("Compile() failed:", err)
+			}
+
+			if _, err := e.Eval(context.Background(), map[string]interface{}{}, nil); !errors.Is(err, tt.want) {
+				t.Errorf("Eval() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile_error(t *testing.T) {
+	tests := []string{
+		``,
+		`a.`,
+		`(a`,
+		`[1, 2`,
+		`{"a" 1}`,
+		`"unterminated`,
+		`1 := 2`,
+		`function(a) { a }`,
+		`a b`,
+		`$missing()`,
+		`a ~> $missing`,
+		`$pad("a", 3)`,
+		`$match("a", /a/)`,
+		`items^(id)`,
+		`items#$i`,
+		`items@$v`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Compile(expr); !errors.Is(err, ErrSyntax) {
+				t.Errorf("Compile() error = %v, want %v", err, ErrSyntax)
+			}
+		})
+	}
+}
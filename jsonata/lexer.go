@@ -0,0 +1,221 @@
+package jsonata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenVariable
+	tokenOperator
+)
+
+type token struct {
+	typ tokenType
+	val string
+	num float64
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return "the end"
+	case tokenString:
+		return strconv.Quote(t.val)
+	case tokenVariable:
+		return "'$" + t.val + "'"
+	default:
+		return "'" + t.val + "'"
+	}
+}
+
+// operators are sorted so that the longer ones are tried first.
+var operators = []string{
+	"..", ":=", "!=", "<=", ">=", "~>", "??",
+	".", "[", "]", "{", "}", "(", ")", ",", ":", ";", "?",
+	"+", "-", "*", "/", "%", "&", "=", "<", ">",
+}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+		case r == '"' || r == '\'':
+			s, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%v at %d", err, i)
+			}
+			tokens = append(tokens, token{typ: tokenString, val: s, pos: i})
+			i += n
+		case r == '`':
+			end := strings.IndexByte(src[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated name at %d", i)
+			}
+			tokens = append(tokens, token{typ: tokenName, val: src[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case r >= '0' && r <= '9':
+			n := lexNumber(src[i:])
+			f, err := strconv.ParseFloat(src[i:i+n], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at %d: %s", i, src[i:i+n])
+			}
+			tokens = append(tokens, token{typ: tokenNumber, val: src[i : i+n], num: f, pos: i})
+			i += n
+		case r == '$':
+			n := 1
+			if strings.HasPrefix(src[i+1:], "$") {
+				n = 2
+			} else {
+				n += lexName(src[i+1:])
+			}
+			tokens = append(tokens, token{typ: tokenVariable, val: src[i+1 : i+n], pos: i})
+			i += n
+		default:
+			if op := lexOperator(src[i:]); op != "" {
+				tokens = append(tokens, token{typ: tokenOperator, val: op, pos: i})
+				i += len(op)
+				continue
+			}
+
+			n := lexName(src[i:])
+			if n == 0 {
+				if feature, ok := unsupportedOperators[r]; ok {
+					return nil, fmt.Errorf("%s is not supported: '%c' at %d", feature, r, i)
+				}
+				return nil, fmt.Errorf("unexpected character '%c' at %d", r, i)
+			}
+			tokens = append(tokens, token{typ: tokenName, val: src[i : i+n], pos: i})
+			i += n
+		}
+	}
+
+	return append(tokens, token{typ: tokenEOF, pos: len(src)}), nil
+}
+
+func lexOperator(src string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(src, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// lexNumber returns the length of the number at the beginning of src.
+// A dot followed by another dot is a range operator, not a decimal point.
+func lexNumber(src string) int {
+	n := 0
+	digits := func() {
+		for n < len(src) && src[n] >= '0' && src[n] <= '9' {
+			n++
+		}
+	}
+
+	digits()
+	if n+1 < len(src) && src[n] == '.' && src[n+1] >= '0' && src[n+1] <= '9' {
+		n++
+		digits()
+	}
+	if n < len(src) && (src[n] == 'e' || src[n] == 'E') {
+		m := n + 1
+		if m < len(src) && (src[m] == '+' || src[m] == '-') {
+			m++
+		}
+		if m < len(src) && src[m] >= '0' && src[m] <= '9' {
+			n = m
+			digits()
+		}
+	}
+	return n
+}
+
+// lexName returns the length of the name at the beginning of src, which ends at a space or an operator.
+func lexName(src string) int {
+	n := 0
+	for n < len(src) {
+		r, size := utf8.DecodeRuneInString(src[n:])
+		if unicode.IsSpace(r) || r == '"' || r == '\'' || r == '`' || r == '$' || r == '!' || r == '~' || r == '|' || r == '^' || r == '#' || r == '@' {
+			break
+		}
+		if lexOperator(src[n:]) != "" {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// lexString reads a quoted string at the beginning of src, and returns its value and length.
+func lexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\':
+			if i+1 >= len(src) {
+				return "", 0, errors.New("unterminated string")
+			}
+			i++
+			switch src[i] {
+			case '"', '\'', '\\', '/':
+				b.WriteByte(src[i])
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(src[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("unsupported escape sequence: \\%c", src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// unsupportedOperators are the operators of JSONata that are not implemented.
+var unsupportedOperators = map[rune]string{
+	'^': "the order-by operator",
+	'#': "the positional variable binding",
+	'@': "the context variable binding",
+}
@@ -0,0 +1,365 @@
+package jsonata
+
+import (
+	"errors"
+	"fmt"
+)
+
+type node interface{}
+
+type (
+	literalNode  struct{ v interface{} }
+	nameNode     struct{ name string }
+	variableNode struct{ name string }
+	wildcardNode struct{}
+	pathNode     struct{ steps []node }
+	filterNode   struct{ expr, pred node }
+	arrayNode    struct{ items []node }
+	objectNode   struct{ pairs []objectPair }
+	blockNode    struct{ exprs []node }
+	negateNode   struct{ expr node }
+	binaryNode   struct {
+		op       string
+		lhs, rhs node
+	}
+	conditionNode struct{ cond, then, els node }
+	bindNode      struct {
+		name  string
+		value node
+	}
+	callNode struct {
+		fn   node
+		args []node
+	}
+	lambdaNode struct {
+		params []string
+		body   node
+	}
+)
+
+type objectPair struct {
+	key, value node
+}
+
+// bindingPowers are the left binding powers of the infix operators.
+// ref: https://github.com/jsonata-js/jsonata/blob/master/src/parser.js
+var bindingPowers = map[string]int{
+	".":   75,
+	"[":   80,
+	"(":   80,
+	"*":   60,
+	"/":   60,
+	"%":   60,
+	"+":   50,
+	"-":   50,
+	"&":   50,
+	"=":   40,
+	"!=":  40,
+	"<":   40,
+	"<=":  40,
+	">":   40,
+	">=":  40,
+	"in":  40,
+	"~>":  40,
+	"and": 30,
+	"or":  25,
+	"..":  20,
+	"?":   20,
+	"??":  20,
+	":=":  10,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) parse() (node, error) {
+	n, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+	return t.typ == tokenOperator && t.val == op
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.typ != tokenOperator || t.val != op {
+		return fmt.Errorf("'%s' is expected, but got %s at %d", op, t, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) bindingPower(t token) int {
+	switch t.typ {
+	case tokenOperator:
+		return bindingPowers[t.val]
+	case tokenName:
+		switch t.val {
+		case "and", "or", "in":
+			return bindingPowers[t.val]
+		}
+	}
+	return 0
+}
+
+func (p *parser) expr(rbp int) (node, error) {
+	left, err := p.nud(p.next())
+	if err != nil {
+		return nil, err
+	}
+
+	for rbp < p.bindingPower(p.peek()) {
+		left, err = p.led(p.next(), left)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return left, nil
+}
+
+// list parses the expressions separated by sep until end.
+func (p *parser) list(sep, end string) ([]node, error) {
+	nodes := []node{}
+	if p.isOperator(end) {
+		p.next()
+		return nodes, nil
+	}
+
+	for {
+		n, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+
+		if p.isOperator(end) {
+			p.next()
+			return nodes, nil
+		}
+		if err := p.expect(sep); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) nud(t token) (node, error) {
+	switch t.typ {
+	case tokenNumber:
+		return literalNode{t.num}, nil
+	case tokenString:
+		return literalNode{t.val}, nil
+	case tokenVariable:
+		return variableNode{t.val}, nil
+	case tokenName:
+		switch t.val {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		case "function", "λ":
+			if p.isOperator("(") {
+				return p.lambda()
+			}
+		}
+		return nameNode{t.val}, nil
+	case tokenOperator:
+		switch t.val {
+		case "(":
+			exprs, err := p.list(";", ")")
+			if err != nil {
+				return nil, err
+			}
+			return blockNode{exprs}, nil
+		case "[":
+			items, err := p.list(",", "]")
+			if err != nil {
+				return nil, err
+			}
+			return arrayNode{items}, nil
+		case "{":
+			return p.object()
+		case "-":
+			n, err := p.expr(70)
+			if err != nil {
+				return nil, err
+			}
+			return negateNode{n}, nil
+		case "*":
+			return wildcardNode{}, nil
+		case "/":
+			return nil, fmt.Errorf("regular expressions are not supported: '/' at %d", t.pos)
+		}
+	case tokenEOF:
+		return nil, errors.New("unexpected end of the expression")
+	}
+
+	return nil, fmt.Errorf("unexpected %s at %d", t, t.pos)
+}
+
+func (p *parser) led(t token, left node) (node, error) {
+	switch t.val {
+	case ".":
+		right, err := p.expr(bindingPowers["."])
+		if err != nil {
+			return nil, err
+		}
+		return appendStep(left, right), nil
+	case "[":
+		pred, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return filterNode{left, pred}, nil
+	case "(":
+		args, err := p.list(",", ")")
+		if err != nil {
+			return nil, err
+		}
+		return callNode{left, args}, nil
+	case "?":
+		then, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		n := conditionNode{cond: left, then: then}
+		if p.isOperator(":") {
+			p.next()
+			els, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			n.els = els
+		}
+		return n, nil
+	case ":=":
+		v, ok := left.(variableNode)
+		if !ok || v.name == "" || v.name == "$" {
+			return nil, fmt.Errorf("the left side of ':=' must be a variable at %d", t.pos)
+		}
+		value, err := p.expr(bindingPowers[":="] - 1)
+		if err != nil {
+			return nil, err
+		}
+		return bindNode{v.name, value}, nil
+	default:
+		right, err := p.expr(bindingPowers[t.val])
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{t.val, left, right}, nil
+	}
+}
+
+// appendStep makes a path of left followed by right.
+func appendStep(left, right node) node {
+	steps := []node{}
+	if l, ok := left.(pathNode); ok {
+		steps = append(steps, l.steps...)
+	} else {
+		steps = append(steps, left)
+	}
+	if r, ok := right.(pathNode); ok {
+		steps = append(steps, r.steps...)
+	} else {
+		steps = append(steps, right)
+	}
+	return pathNode{steps}
+}
+
+func (p *parser) object() (node, error) {
+	obj := objectNode{pairs: []objectPair{}}
+	if p.isOperator("}") {
+		p.next()
+		return obj, nil
+	}
+
+	for {
+		key, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		obj.pairs = append(obj.pairs, objectPair{key, value})
+
+		if p.isOperator("}") {
+			p.next()
+			return obj, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) lambda() (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	params := []string{}
+	if p.isOperator(")") {
+		p.next()
+	} else {
+		for {
+			t := p.next()
+			if t.typ != tokenVariable || t.val == "" || t.val == "$" {
+				return nil, fmt.Errorf("a parameter is expected, but got %s at %d", t, t.pos)
+			}
+			params = append(params, t.val)
+
+			if p.isOperator(")") {
+				p.next()
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	body, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+
+	return lambdaNode{params, body}, nil
+}
@@ -0,0 +1,183 @@
+package jsonata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// callable is a function value, either a lambda or a built-in function.
+type callable interface {
+	call(ev *evaluator, args []interface{}) (interface{}, error)
+	arity() int
+}
+
+type lambda struct {
+	params []string
+	body   node
+	env    *env
+	input  interface{}
+}
+
+func (l *lambda) call(ev *evaluator, args []interface{}) (interface{}, error) {
+	scope := newEnv(l.env)
+	for i, name := range l.params {
+		if i < len(args) {
+			scope.bind(name, args[i])
+		} else {
+			scope.bind(name, undefined)
+		}
+	}
+	return ev.eval(l.body, l.input, scope)
+}
+
+func (l *lambda) arity() int {
+	return len(l.params)
+}
+
+// callN calls f with the first arguments of args that f takes.
+// It is used by the higher-order functions such as $map, which pass optional arguments.
+func callN(ev *evaluator, f callable, args ...interface{}) (interface{}, error) {
+	if n := f.arity(); n >= 0 && n < len(args) {
+		args = args[:n]
+	}
+	return f.call(ev, args)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// toBoolean casts v to a boolean in the same way as $boolean.
+func toBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case undefinedType, nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		for _, e := range v {
+			if toBoolean(e) {
+				return true
+			}
+		}
+		return false
+	case sequence:
+		return toBoolean([]interface{}(v))
+	case callable:
+		return false
+	default:
+		if f, ok := toNumber(v); ok {
+			return f != 0
+		}
+		return true
+	}
+}
+
+// toString casts v to a string in the same way as $string. undefined is cast to an empty string.
+func toString(v interface{}) (string, error) {
+	switch v := plain(v).(type) {
+	case undefinedType:
+		return "", nil
+	case string:
+		return v, nil
+	case callable:
+		return "", nil
+	default:
+		if f, ok := toNumber(v); ok {
+			return formatNumber(f), nil
+		}
+		b, err := json.Marshal(normalize(v))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// formatNumber formats f with 15 significant digits, as JSONata does.
+func formatNumber(f float64) string {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(f, 'g', 15, 64), 64)
+	if err != nil {
+		rounded = f
+	}
+	if math.Abs(rounded) < 1e21 {
+		return strconv.FormatFloat(rounded, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(rounded, 'g', -1, 64)
+}
+
+// normalize returns a copy of v whose numbers are float64, and whose functions are removed.
+func normalize(v interface{}) interface{} {
+	switch v := plain(v).(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if _, ok := e.(callable); ok {
+				continue
+			}
+			m[k] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = normalize(e)
+		}
+		return s
+	case callable:
+		return nil
+	default:
+		if f, ok := toNumber(v); ok {
+			return f
+		}
+		return v
+	}
+}
+
+// deepEqual reports whether v1 and v2 are the same JSON value.
+func deepEqual(v1, v2 interface{}) bool {
+	return reflect.DeepEqual(normalize(v1), normalize(v2))
+}
+
+func typeName(v interface{}) string {
+	switch v := plain(v).(type) {
+	case undefinedType:
+		return "undefined"
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case callable:
+		return "function"
+	default:
+		if _, ok := toNumber(v); ok {
+			return "number"
+		}
+		return fmt.Sprintf("%T", v)
+	}
+}
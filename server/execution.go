@@ -0,0 +1,516 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+const statusRunning = "RUNNING"
+
+// The limits of maxResults of ListExecutions and GetExecutionHistory.
+const (
+	defaultMaxResults = 100
+	maxMaxResults     = 1000
+)
+
+type execution struct {
+	arn          string
+	name         string
+	stateMachine *stateMachine
+	input        string
+	cancel       context.CancelFunc
+	done         chan struct{}
+	// startDate is the StartDate given by the engine, which is set before started is closed.
+	startDate time.Time
+	started   chan struct{}
+
+	// The fields below are guarded by Server.mu.
+	// result is set when the execution finishes, and stopError and stopCause are given by StopExecution.
+	result    *worker.Result
+	stopped   bool
+	stopError string
+	stopCause string
+}
+
+// status returns the status of the execution. s.mu must be held.
+func (e *execution) status() string {
+	if e.result == nil {
+		return statusRunning
+	}
+	return string(e.result.Status)
+}
+
+// dataDetails tells whether the input or the output is included in the response.
+type dataDetails struct {
+	Included bool `json:"included"`
+}
+
+// executionOutput is the output of DescribeExecution and StartSyncExecution.
+type executionOutput struct {
+	ExecutionArn    string            `json:"executionArn"`
+	StateMachineArn string            `json:"stateMachineArn"`
+	Name            string            `json:"name"`
+	Status          string            `json:"status"`
+	StartDate       worker.Timestamp  `json:"startDate"`
+	StopDate        *worker.Timestamp `json:"stopDate,omitempty"`
+	Input           string            `json:"input"`
+	InputDetails    dataDetails       `json:"inputDetails"`
+	Output          *string           `json:"output,omitempty"`
+	OutputDetails   *dataDetails      `json:"outputDetails,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Cause           string            `json:"cause,omitempty"`
+}
+
+// describe returns the current state of the execution. s.mu must be held.
+func (e *execution) describe() executionOutput {
+	out := executionOutput{
+		ExecutionArn:    e.arn,
+		StateMachineArn: e.stateMachine.arn,
+		Name:            e.name,
+		Status:          e.status(),
+		StartDate:       worker.Timestamp{Time: e.startDate},
+		Input:           e.input,
+		InputDetails:    dataDetails{Included: true},
+	}
+	if e.result == nil {
+		return out
+	}
+
+	out.StopDate = &worker.Timestamp{Time: e.result.StopDate}
+	if e.result.Status == worker.ExecutionStatusSucceeded {
+		output := string(e.result.Output)
+		out.Output = &output
+		out.OutputDetails = &dataDetails{Included: true}
+	}
+	out.Error = e.result.Error
+	out.Cause = e.result.Cause
+	return out
+}
+
+type startExecutionInput struct {
+	StateMachineArn string `json:"stateMachineArn"`
+	Name            string `json:"name"`
+	Input           string `json:"input"`
+}
+
+type startExecutionOutput struct {
+	ExecutionArn string           `json:"executionArn"`
+	StartDate    worker.Timestamp `json:"startDate"`
+}
+
+func (s *Server) startExecution(ctx context.Context, body []byte) (interface{}, error) {
+	var in startExecutionInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	// an express state machine is run asynchronously as well
+	exec, err := s.start(in, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return startExecutionOutput{
+		ExecutionArn: exec.arn,
+		StartDate:    worker.Timestamp{Time: exec.startDate},
+	}, nil
+}
+
+// startSyncExecution runs an execution of an express state machine, and returns its result.
+// The execution is aborted if the client goes away before it finishes.
+func (s *Server) startSyncExecution(ctx context.Context, body []byte) (interface{}, error) {
+	var in startExecutionInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	exec, err := s.start(in, typeExpress)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-exec.done:
+	case <-ctx.Done():
+		exec.cancel()
+		<-exec.done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return exec.describe(), nil
+}
+
+// start starts an execution of the state machine, whose type must be typ unless typ is empty.
+// Starting a running execution again with the same input returns it, as AWS does.
+func (s *Server) start(in startExecutionInput, typ string) (*execution, error) {
+	if in.Name == "" {
+		in.Name = uuid.NewString()
+	}
+	if !validName(in.Name) {
+		return nil, newError(errInvalidName, "invalid name: %q", in.Name)
+	}
+	if in.Input == "" {
+		in.Input = "{}"
+	}
+	if !json.Valid([]byte(in.Input)) {
+		return nil, newError(errInvalidExecutionInput, "the input is not a valid JSON text")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, err := s.lookupStateMachine(in.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "" && sm.typ != typ {
+		return nil, newError(errStateMachineTypeNotSupported, "the operation is not supported by %s state machines", sm.typ)
+	}
+
+	arn := s.executionArn(sm, in.Name)
+	if existing, ok := s.executions[arn]; ok {
+		if existing.result == nil && existing.input == in.Input {
+			return existing, nil
+		}
+		return nil, newError(errExecutionAlreadyExists, "execution already exists: %s", arn)
+	}
+
+	// the execution is named by its ARN, which is also the ID of its history in the engine
+	coj, err := new(compiler.CtxObj).SetByString("$.Execution", map[string]interface{}{
+		"Id":   arn,
+		"Name": in.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	coj, err = coj.SetByString("$.StateMachine", map[string]interface{}{
+		"Id":   sm.arn,
+		"Name": sm.name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	exec := &execution{
+		arn:          arn,
+		name:         in.Name,
+		stateMachine: sm,
+		input:        in.Input,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		started:      make(chan struct{}),
+	}
+	s.executions[arn] = exec
+	s.executionOrder = append(s.executionOrder, exec)
+
+	go s.run(ctx, exec, coj)
+
+	// the engine starts the execution without s.mu, so it can be waited for here
+	<-exec.started
+
+	return exec, nil
+}
+
+func (s *Server) run(ctx context.Context, exec *execution, coj *compiler.CtxObj) {
+	defer close(exec.done)
+	defer exec.cancel()
+
+	started := false
+	ctx = worker.WithStarted(ctx, func(startDate time.Time) {
+		exec.startDate = startDate
+		started = true
+		close(exec.started)
+	})
+
+	res, err := s.engine.Execute(ctx, coj, exec.stateMachine.workflow, bytes.NewBufferString(exec.input))
+	if err != nil {
+		// the engine failed before the execution started
+		now := time.Now()
+		if !started {
+			exec.startDate = now
+			close(exec.started)
+		}
+		res = worker.Result{
+			ID:        exec.arn,
+			Status:    worker.ExecutionStatusFailed,
+			Error:     worker.StatesErrorRuntime,
+			Cause:     err.Error(),
+			StartDate: exec.startDate,
+			StopDate:  now,
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if exec.stopped && res.Status == worker.ExecutionStatusAborted {
+		res.Error = exec.stopError
+		res.Cause = exec.stopCause
+	}
+	exec.result = &res
+	s.finish(exec)
+}
+
+// finish adds the finished execution, and drops the oldest finished ones over s.executionLimit. s.mu must be held.
+func (s *Server) finish(exec *execution) {
+	s.finished = append(s.finished, exec)
+	if len(s.finished) <= s.executionLimit {
+		return
+	}
+
+	dropped := make(map[*execution]bool)
+	for len(s.finished) > s.executionLimit {
+		oldest := s.finished[0]
+		s.finished[0] = nil
+		s.finished = s.finished[1:]
+		dropped[oldest] = true
+		if s.executions[oldest.arn] == oldest {
+			delete(s.executions, oldest.arn)
+		}
+	}
+
+	order := s.executionOrder[:0]
+	for _, e := range s.executionOrder {
+		if !dropped[e] {
+			order = append(order, e)
+		}
+	}
+	for i := len(order); i < len(s.executionOrder); i++ {
+		s.executionOrder[i] = nil
+	}
+	s.executionOrder = order
+}
+
+// lookupExecution returns the execution of arn. s.mu must be held.
+func (s *Server) lookupExecution(arn string) (*execution, error) {
+	if err := required("executionArn", arn); err != nil {
+		return nil, err
+	}
+	if err := validArn(arn); err != nil {
+		return nil, err
+	}
+
+	exec, ok := s.executions[arn]
+	if !ok {
+		return nil, newError(errExecutionDoesNotExist, "execution does not exist: %s", arn)
+	}
+	return exec, nil
+}
+
+type describeExecutionInput struct {
+	ExecutionArn string `json:"executionArn"`
+}
+
+func (s *Server) describeExecution(ctx context.Context, body []byte) (interface{}, error) {
+	var in describeExecutionInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exec, err := s.lookupExecution(in.ExecutionArn)
+	if err != nil {
+		return nil, err
+	}
+	return exec.describe(), nil
+}
+
+type stopExecutionInput struct {
+	ExecutionArn string `json:"executionArn"`
+	Error        string `json:"error"`
+	Cause        string `json:"cause"`
+}
+
+type stopExecutionOutput struct {
+	StopDate worker.Timestamp `json:"stopDate"`
+}
+
+// stopExecution aborts the execution, and waits for it to stop.
+func (s *Server) stopExecution(ctx context.Context, body []byte) (interface{}, error) {
+	var in stopExecutionInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	exec, err := s.lookupExecution(in.ExecutionArn)
+	if err == nil && exec.result == nil {
+		exec.stopped = true
+		exec.stopError = in.Error
+		exec.stopCause = in.Cause
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	exec.cancel()
+	<-exec.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return stopExecutionOutput{
+		StopDate: worker.Timestamp{Time: exec.result.StopDate},
+	}, nil
+}
+
+type listExecutionsInput struct {
+	StateMachineArn string `json:"stateMachineArn"`
+	StatusFilter    string `json:"statusFilter"`
+	MaxResults      int    `json:"maxResults"`
+	NextToken       string `json:"nextToken"`
+}
+
+type executionListItem struct {
+	ExecutionArn    string            `json:"executionArn"`
+	StateMachineArn string            `json:"stateMachineArn"`
+	Name            string            `json:"name"`
+	Status          string            `json:"status"`
+	StartDate       worker.Timestamp  `json:"startDate"`
+	StopDate        *worker.Timestamp `json:"stopDate,omitempty"`
+}
+
+type listExecutionsOutput struct {
+	Executions []executionListItem `json:"executions"`
+	NextToken  string              `json:"nextToken,omitempty"`
+}
+
+// listExecutions returns the executions of the state machine, the newest first.
+func (s *Server) listExecutions(ctx context.Context, body []byte) (interface{}, error) {
+	var in listExecutionsInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	switch in.StatusFilter {
+	case "", statusRunning,
+		string(worker.ExecutionStatusSucceeded),
+		string(worker.ExecutionStatusFailed),
+		string(worker.ExecutionStatusTimedOut),
+		string(worker.ExecutionStatusAborted):
+	default:
+		return nil, newError(errValidation, "invalid statusFilter: %q", in.StatusFilter)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, err := s.lookupStateMachine(in.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []executionListItem{}
+	for i := len(s.executionOrder) - 1; i >= 0; i-- {
+		exec := s.executionOrder[i]
+		if exec.stateMachine != sm || (in.StatusFilter != "" && exec.status() != in.StatusFilter) {
+			continue
+		}
+
+		d := exec.describe()
+		items = append(items, executionListItem{
+			ExecutionArn:    d.ExecutionArn,
+			StateMachineArn: d.StateMachineArn,
+			Name:            d.Name,
+			Status:          d.Status,
+			StartDate:       d.StartDate,
+			StopDate:        d.StopDate,
+		})
+	}
+
+	start, end, next, err := page(len(items), in.MaxResults, in.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	return listExecutionsOutput{Executions: items[start:end], NextToken: next}, nil
+}
+
+type getExecutionHistoryInput struct {
+	ExecutionArn string `json:"executionArn"`
+	MaxResults   int    `json:"maxResults"`
+	ReverseOrder bool   `json:"reverseOrder"`
+	NextToken    string `json:"nextToken"`
+}
+
+type getExecutionHistoryOutput struct {
+	Events    []worker.HistoryEvent `json:"events"`
+	NextToken string                `json:"nextToken,omitempty"`
+}
+
+// getExecutionHistory returns the events recorded by the engine.
+// The input and the output are always included, whatever includeExecutionData is.
+func (s *Server) getExecutionHistory(ctx context.Context, body []byte) (interface{}, error) {
+	var in getExecutionHistoryInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	_, err := s.lookupExecution(in.ExecutionArn)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// the history is not registered until the execution is started by the engine,
+	// and the one of an old execution may be dropped by it
+	events := []worker.HistoryEvent{}
+	h, err := s.engine.History(in.ExecutionArn)
+	if errors.Is(err, worker.ErrNoHistory) {
+		return nil, newError(errValidation, "%v", err)
+	}
+	if err != nil && !errors.Is(err, worker.ErrExecutionNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		events = h.Events()
+	}
+
+	if in.ReverseOrder {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	start, end, next, err := page(len(events), in.MaxResults, in.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	return getExecutionHistoryOutput{Events: events[start:end], NextToken: next}, nil
+}
+
+// page returns the range of the n items in the page of nextToken, and the token of the next page.
+// The token is the index of the first item in the page.
+func page(n, maxResults int, nextToken string) (start, end int, next string, err error) {
+	if maxResults < 0 || maxResults > maxMaxResults {
+		return 0, 0, "", newError(errValidation, "maxResults must be between 0 and %d: %d", maxMaxResults, maxResults)
+	}
+	if maxResults == 0 {
+		maxResults = defaultMaxResults
+	}
+
+	if nextToken != "" {
+		start, err = strconv.Atoi(nextToken)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", newError(errInvalidToken, "invalid nextToken: %q", nextToken)
+		}
+	}
+
+	end = start + maxResults
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
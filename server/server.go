@@ -0,0 +1,263 @@
+// Package server serves the AWS Step Functions API over HTTP, backed by the compiler and the worker,
+// so that the AWS SDKs and CLI can run state machines on localhost as they do with Step Functions Local.
+//
+// The requests are in the AWS JSON 1.0 protocol: every request is a POST whose operation is named by
+// the X-Amz-Target header, such as "AWSStepFunctions.StartExecution".
+// ref: https://docs.aws.amazon.com/step-functions/latest/apireference/API_Operations.html
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+const (
+	targetPrefix = "AWSStepFunctions."
+	contentType  = "application/x-amz-json-1.0"
+
+	// maxRequestSize is the limit of a request body, which is larger than any definition or input allowed by AWS.
+	maxRequestSize = 16 << 20
+
+	// defaultExecutionLimit is the number of the finished executions kept by default.
+	defaultExecutionLimit = 1000
+)
+
+// The default region and account of the ARNs, which are the same as the ones of Step Functions Local.
+const (
+	DefaultRegion    = "us-east-1"
+	DefaultAccountID = "123456789012"
+)
+
+// Server is an http.Handler of the Step Functions API.
+// The state machines and the executions are kept in memory, and lost when the server stops.
+type Server struct {
+	engine    *worker.Engine
+	region    string
+	accountID string
+	// executionLimit is the number of the finished executions kept, in addition to the running ones
+	executionLimit int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu             sync.Mutex
+	stateMachines  map[string]*stateMachine
+	executions     map[string]*execution
+	executionOrder []*execution
+	// finished is the finished executions in the order they finished, the oldest of which are dropped over executionLimit
+	finished []*execution
+}
+
+type Option func(*Server)
+
+// WithRegion sets the region in the ARNs of the state machines and the executions.
+func WithRegion(region string) Option {
+	return func(s *Server) {
+		s.region = region
+	}
+}
+
+// WithAccountID sets the account ID in the ARNs of the state machines and the executions.
+func WithAccountID(id string) Option {
+	return func(s *Server) {
+		s.accountID = id
+	}
+}
+
+// WithExecutionLimit sets the number of the finished executions kept for DescribeExecution and ListExecutions,
+// which is 1000 by default. The older ones are dropped, while the running executions are always kept.
+func WithExecutionLimit(limit int) Option {
+	return func(s *Server) {
+		s.executionLimit = limit
+	}
+}
+
+// New returns a server that runs the executions on the engine.
+func New(engine *worker.Engine, opts ...Option) *Server {
+	s := &Server{
+		engine:         engine,
+		region:         DefaultRegion,
+		accountID:      DefaultAccountID,
+		executionLimit: defaultExecutionLimit,
+		stateMachines:  make(map[string]*stateMachine),
+		executions:     make(map[string]*execution),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Close aborts the running executions and waits for them to finish.
+func (s *Server) Close() {
+	s.cancel()
+
+	s.mu.Lock()
+	executions := make([]*execution, len(s.executionOrder))
+	copy(executions, s.executionOrder)
+	s.mu.Unlock()
+
+	for _, exec := range executions {
+		<-exec.done
+	}
+}
+
+type operation func(s *Server, ctx context.Context, body []byte) (interface{}, error)
+
+var operations = map[string]operation{
+	"CreateStateMachine":  (*Server).createStateMachine,
+	"StartExecution":      (*Server).startExecution,
+	"StartSyncExecution":  (*Server).startSyncExecution,
+	"DescribeExecution":   (*Server).describeExecution,
+	"StopExecution":       (*Server).stopExecution,
+	"ListExecutions":      (*Server).listExecutions,
+	"GetExecutionHistory": (*Server).getExecutionHistory,
+	"SendTaskSuccess":     (*Server).sendTaskSuccess,
+	"SendTaskFailure":     (*Server).sendTaskFailure,
+	"SendTaskHeartbeat":   (*Server).sendTaskHeartbeat,
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &apiError{Type: errUnknownOperation, Message: "only POST is supported", status: http.StatusMethodNotAllowed})
+		return
+	}
+
+	target := r.Header.Get("X-Amz-Target")
+	op, ok := operations[strings.TrimPrefix(target, targetPrefix)]
+	if !ok || !strings.HasPrefix(target, targetPrefix) {
+		writeError(w, newError(errUnknownOperation, "unknown operation: %q", target))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeError(w, newError(errSerialization, "failed to read the request: %v", err))
+		return
+	}
+
+	out, err := op(s, r.Context(), body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(b)
+}
+
+// The error types of the Step Functions API.
+const (
+	errUnknownOperation             = "UnknownOperationException"
+	errSerialization                = "SerializationException"
+	errValidation                   = "ValidationException"
+	errInternalFailure              = "InternalFailure"
+	errInvalidArn                   = "InvalidArn"
+	errInvalidName                  = "InvalidName"
+	errInvalidDefinition            = "InvalidDefinition"
+	errInvalidExecutionInput        = "InvalidExecutionInput"
+	errInvalidOutput                = "InvalidOutput"
+	errInvalidToken                 = "InvalidToken"
+	errStateMachineAlreadyExists    = "StateMachineAlreadyExists"
+	errStateMachineDoesNotExist     = "StateMachineDoesNotExist"
+	errStateMachineTypeNotSupported = "StateMachineTypeNotSupported"
+	errExecutionAlreadyExists       = "ExecutionAlreadyExists"
+	errExecutionDoesNotExist        = "ExecutionDoesNotExist"
+	errTaskDoesNotExist             = "TaskDoesNotExist"
+)
+
+// apiError is an error returned to the client, whose Type is the name of the exception in the AWS SDKs.
+type apiError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	status  int
+}
+
+func newError(typ, format string, a ...interface{}) *apiError {
+	return &apiError{Type: typ, Message: fmt.Sprintf(format, a...), status: http.StatusBadRequest}
+}
+
+func (e *apiError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var aerr *apiError
+	if !errors.As(err, &aerr) {
+		aerr = &apiError{Type: errInternalFailure, Message: err.Error(), status: http.StatusInternalServerError}
+	}
+
+	b, err := json.Marshal(aerr)
+	if err != nil {
+		b = []byte(`{"__type":"` + errInternalFailure + `"}`)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(aerr.status)
+	w.Write(b)
+}
+
+// decode decodes the request body into v. An empty body is the same as an empty object.
+func decode(body []byte, v interface{}) error {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return newError(errSerialization, "invalid request: %v", err)
+	}
+	return nil
+}
+
+func required(name, value string) error {
+	if value == "" {
+		return newError(errValidation, "%s is required", name)
+	}
+	return nil
+}
+
+// validName reports whether name can be a name of a state machine or an execution.
+// ref: https://docs.aws.amazon.com/step-functions/latest/apireference/API_CreateStateMachine.html#StepFunctions-CreateStateMachine-request-name
+func validName(name string) bool {
+	if name == "" || len(name) > 80 {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || (r >= 0x7f && r <= 0x9f) || strings.ContainsRune(`<>{}[]?*"#%\^|~`+"`"+`$&,;:/`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) stateMachineArn(name string) string {
+	return fmt.Sprintf("arn:aws:states:%s:%s:stateMachine:%s", s.region, s.accountID, name)
+}
+
+func (s *Server) executionArn(sm *stateMachine, name string) string {
+	if sm.typ == typeExpress {
+		return fmt.Sprintf("arn:aws:states:%s:%s:express:%s:%s", s.region, s.accountID, sm.name, name)
+	}
+	return fmt.Sprintf("arn:aws:states:%s:%s:execution:%s:%s", s.region, s.accountID, sm.name, name)
+}
+
+func validArn(arn string) error {
+	if !strings.HasPrefix(arn, "arn:") {
+		return newError(errInvalidArn, "invalid ARN: %q", arn)
+	}
+	return nil
+}
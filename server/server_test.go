@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+type client struct {
+	t   *testing.T
+	url string
+}
+
+func newClient(t *testing.T, s *Server) client {
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		s.Close()
+	})
+	return client{t: t, url: ts.URL}
+}
+
+// call calls the operation, and returns the error type if it fails.
+func (c client) call(op string, in interface{}, out interface{}) string {
+	c.t.Helper()
+
+	b, err := json.Marshal(in)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Target", targetPrefix+op)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var aerr apiError
+		if err := json.NewDecoder(resp.Body).Decode(&aerr); err != nil {
+			c.t.Fatalf("%s: status %d: %v", op, resp.StatusCode, err)
+		}
+		return aerr.Type
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s: %v", op, err)
+		}
+	}
+	return ""
+}
+
+func (c client) mustCall(op string, in interface{}, out interface{}) {
+	c.t.Helper()
+	if typ := c.call(op, in, out); typ != "" {
+		c.t.Fatalf("%s failed: %s", op, typ)
+	}
+}
+
+func (c client) createStateMachine(name, typ, definition string) string {
+	c.t.Helper()
+	var out createStateMachineOutput
+	c.mustCall("CreateStateMachine", map[string]string{"name": name, "type": typ, "definition": definition}, &out)
+	return out.StateMachineArn
+}
+
+// wait polls DescribeExecution until the execution finishes.
+func (c client) wait(arn string) executionOutput {
+	c.t.Helper()
+	for i := 0; i < 100; i++ {
+		var out executionOutput
+		c.mustCall("DescribeExecution", map[string]string{"executionArn": arn}, &out)
+		if out.Status != statusRunning {
+			return out
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.t.Fatalf("the execution does not finish: %s", arn)
+	return executionOutput{}
+}
+
+const passDefinition = `{"StartAt": "P", "States": {"P": {"Type": "Pass",
+	"Parameters": {"in.$": "$", "id.$": "$$.Execution.Id", "name.$": "$$.Execution.Name", "sm.$": "$$.StateMachine.Name"}, "End": true}}}`
+
+func TestServer_execution(t *testing.T) {
+	c := newClient(t, New(worker.NewEngine(worker.WithHistory(10)), WithRegion("ap-northeast-1"), WithAccountID("000000000000")))
+
+	smArn := c.createStateMachine("sm", "", passDefinition)
+	if want := "arn:aws:states:ap-northeast-1:000000000000:stateMachine:sm"; smArn != want {
+		t.Fatalf("stateMachineArn = %s, want %s", smArn, want)
+	}
+	if got := c.createStateMachine("sm", "", passDefinition); got != smArn {
+		t.Errorf("CreateStateMachine() of the same state machine = %s, want %s", got, smArn)
+	}
+	if typ := c.call("CreateStateMachine", map[string]string{"name": "sm", "definition": `{"StartAt": "X", "States": {"X": {"Type": "Succeed"}}}`}, nil); typ != errStateMachineAlreadyExists {
+		t.Errorf("CreateStateMachine() of another definition = %q, want %q", typ, errStateMachineAlreadyExists)
+	}
+
+	var started startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": smArn, "name": "e1", "input": `{"a": 1}`}, &started)
+	want := "arn:aws:states:ap-northeast-1:000000000000:execution:sm:e1"
+	if started.ExecutionArn != want {
+		t.Fatalf("executionArn = %s, want %s", started.ExecutionArn, want)
+	}
+
+	out := c.wait(started.ExecutionArn)
+	wantOutput := `{"id":"` + want + `","in":{"a":1},"name":"e1","sm":"sm"}`
+	if out.Status != string(worker.ExecutionStatusSucceeded) || out.Output == nil || *out.Output != wantOutput {
+		t.Errorf("DescribeExecution() = %+v, want output %s", out, wantOutput)
+	}
+
+	if typ := c.call("StartExecution", map[string]string{"stateMachineArn": smArn, "name": "e1"}, nil); typ != errExecutionAlreadyExists {
+		t.Errorf("StartExecution() of the same name = %q, want %q", typ, errExecutionAlreadyExists)
+	}
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": smArn, "name": "e2"}, &started)
+	c.wait(started.ExecutionArn)
+
+	var list listExecutionsOutput
+	c.mustCall("ListExecutions", map[string]interface{}{"stateMachineArn": smArn, "maxResults": 1}, &list)
+	if len(list.Executions) != 1 || list.Executions[0].Name != "e2" || list.NextToken == "" {
+		t.Fatalf("ListExecutions() = %+v", list)
+	}
+	var next listExecutionsOutput
+	c.mustCall("ListExecutions", map[string]interface{}{"stateMachineArn": smArn, "maxResults": 1, "nextToken": list.NextToken}, &next)
+	if len(next.Executions) != 1 || next.Executions[0].Name != "e1" || next.NextToken != "" {
+		t.Errorf("ListExecutions() of the next page = %+v", next)
+	}
+	var failed listExecutionsOutput
+	c.mustCall("ListExecutions", map[string]interface{}{"stateMachineArn": smArn, "statusFilter": "FAILED"}, &failed)
+	if len(failed.Executions) != 0 {
+		t.Errorf("ListExecutions() of FAILED = %+v", failed)
+	}
+
+	var history getExecutionHistoryOutput
+	c.mustCall("GetExecutionHistory", map[string]interface{}{"executionArn": want, "reverseOrder": true}, &history)
+	types := []worker.HistoryEventType{}
+	for _, e := range history.Events {
+		types = append(types, e.Type)
+	}
+	wantTypes := []worker.HistoryEventType{
+		worker.HistoryEventExecutionSucceeded,
+		worker.StateExitedEventType("Pass"),
+		worker.StateEnteredEventType("Pass"),
+		worker.HistoryEventExecutionStarted,
+	}
+	if len(types) != len(wantTypes) {
+		t.Fatalf("GetExecutionHistory() = %v, want %v", types, wantTypes)
+	}
+	for i := range types {
+		if types[i] != wantTypes[i] {
+			t.Fatalf("GetExecutionHistory() = %v, want %v", types, wantTypes)
+		}
+	}
+}
+
+func TestServer_startSyncExecution(t *testing.T) {
+	c := newClient(t, New(worker.NewEngine()))
+
+	express := c.createStateMachine("express", typeExpress, passDefinition)
+	var out executionOutput
+	c.mustCall("StartSyncExecution", map[string]string{"stateMachineArn": express, "name": "e", "input": `"x"`}, &out)
+	want := `{"id":"arn:aws:states:us-east-1:123456789012:express:express:e","in":"x","name":"e","sm":"express"}`
+	if out.Status != string(worker.ExecutionStatusSucceeded) || out.Output == nil || *out.Output != want {
+		t.Errorf("StartSyncExecution() = %+v, want output %s", out, want)
+	}
+
+	failed := c.createStateMachine("failed", typeExpress, `{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "E", "Cause": "C"}}}`)
+	var failedOut executionOutput
+	c.mustCall("StartSyncExecution", map[string]string{"stateMachineArn": failed}, &failedOut)
+	if failedOut.Status != string(worker.ExecutionStatusFailed) || failedOut.Error != "E" || failedOut.Cause != "C" || failedOut.Output != nil {
+		t.Errorf("StartSyncExecution() = %+v", failedOut)
+	}
+
+	standard := c.createStateMachine("standard", typeStandard, passDefinition)
+	if typ := c.call("StartSyncExecution", map[string]string{"stateMachineArn": standard}, nil); typ != errStateMachineTypeNotSupported {
+		t.Errorf("StartSyncExecution() of a standard state machine = %q, want %q", typ, errStateMachineTypeNotSupported)
+	}
+
+	// an express state machine can be run asynchronously as well
+	var started startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": express, "name": "async", "input": `"x"`}, &started)
+	if out := c.wait(started.ExecutionArn); out.Status != string(worker.ExecutionStatusSucceeded) {
+		t.Errorf("DescribeExecution() = %+v", out)
+	}
+}
+
+func TestServer_startDate(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newClient(t, New(worker.NewEngine(worker.WithClock(func() time.Time { return now }))))
+
+	sm := c.createStateMachine("sm", "", passDefinition)
+	var started startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": sm}, &started)
+	if !started.StartDate.Equal(now) {
+		t.Errorf("StartExecution() startDate = %v, want %v", started.StartDate.Time, now)
+	}
+	if out := c.wait(started.ExecutionArn); !out.StartDate.Equal(now) {
+		t.Errorf("DescribeExecution() startDate = %v, want %v", out.StartDate.Time, now)
+	}
+}
+
+func TestServer_executionLimit(t *testing.T) {
+	c := newClient(t, New(worker.NewEngine(), WithExecutionLimit(2)))
+
+	wait := c.createStateMachine("wait", "", `{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": 3600, "End": true}}}`)
+	var running startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": wait}, &running)
+
+	sm := c.createStateMachine("sm", "", passDefinition)
+	arns := []string{}
+	for i := 0; i < 3; i++ {
+		var started startExecutionOutput
+		c.mustCall("StartExecution", map[string]string{"stateMachineArn": sm}, &started)
+		c.wait(started.ExecutionArn)
+		arns = append(arns, started.ExecutionArn)
+	}
+
+	// the oldest finished execution is dropped, while the running one is kept
+	if typ := c.call("DescribeExecution", map[string]string{"executionArn": arns[0]}, nil); typ != errExecutionDoesNotExist {
+		t.Errorf("DescribeExecution() of a dropped execution = %q, want %q", typ, errExecutionDoesNotExist)
+	}
+	var list listExecutionsOutput
+	c.mustCall("ListExecutions", map[string]string{"stateMachineArn": sm}, &list)
+	if len(list.Executions) != 2 || list.Executions[0].ExecutionArn != arns[2] || list.Executions[1].ExecutionArn != arns[1] {
+		t.Errorf("ListExecutions() = %+v", list)
+	}
+	var out executionOutput
+	c.mustCall("DescribeExecution", map[string]string{"executionArn": running.ExecutionArn}, &out)
+	if out.Status != statusRunning {
+		t.Errorf("DescribeExecution() of the running execution = %+v", out)
+	}
+}
+
+func TestServer_stopExecution(t *testing.T) {
+	c := newClient(t, New(worker.NewEngine()))
+
+	sm := c.createStateMachine("wait", "", `{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": 3600, "End": true}}}`)
+	var started startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": sm}, &started)
+
+	var list listExecutionsOutput
+	c.mustCall("ListExecutions", map[string]interface{}{"stateMachineArn": sm, "statusFilter": statusRunning}, &list)
+	if len(list.Executions) != 1 {
+		t.Errorf("ListExecutions() of RUNNING = %+v", list)
+	}
+
+	c.mustCall("StopExecution", map[string]string{"executionArn": started.ExecutionArn, "error": "Stopped", "cause": "by the test"}, nil)
+
+	out := c.wait(started.ExecutionArn)
+	if out.Status != string(worker.ExecutionStatusAborted) || out.Error != "Stopped" || out.Cause != "by the test" || out.StopDate == nil {
+		t.Errorf("DescribeExecution() = %+v", out)
+	}
+}
+
+func TestServer_sendTask(t *testing.T) {
+	tokens := make(chan string, 1)
+	engine := worker.NewEngine(worker.WithTaskRegistry(task.FnMap{
+		"notify": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			tokens <- in["token"].(string)
+			return fn.Obj{}, "", nil
+		},
+	}))
+	c := newClient(t, New(engine))
+
+	sm := c.createStateMachine("callback", "", `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "notify:aaa.waitForTaskToken",
+		"Parameters": {"token.$": "$$.Task.Token"}, "End": true}}}`)
+
+	var started startExecutionOutput
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": sm}, &started)
+	token := <-tokens
+
+	if typ := c.call("SendTaskSuccess", map[string]string{"taskToken": token, "output": "{"}, nil); typ != errInvalidOutput {
+		t.Errorf("SendTaskSuccess() of an invalid output = %q, want %q", typ, errInvalidOutput)
+	}
+	c.mustCall("SendTaskHeartbeat", map[string]string{"taskToken": token}, nil)
+	c.mustCall("SendTaskSuccess", map[string]string{"taskToken": token, "output": `{"approved": true}`}, nil)
+
+	out := c.wait(started.ExecutionArn)
+	if out.Status != string(worker.ExecutionStatusSucceeded) || out.Output == nil || *out.Output != `{"approved":true}` {
+		t.Errorf("DescribeExecution() = %+v", out)
+	}
+
+	c.mustCall("StartExecution", map[string]string{"stateMachineArn": sm}, &started)
+	token = <-tokens
+	c.mustCall("SendTaskFailure", map[string]string{"taskToken": token, "error": "Rejected", "cause": "not approved"}, nil)
+
+	out = c.wait(started.ExecutionArn)
+	if out.Status != string(worker.ExecutionStatusFailed) || out.Error != "Rejected" || out.Cause != "not approved" {
+		t.Errorf("DescribeExecution() = %+v", out)
+	}
+
+	if typ := c.call("SendTaskSuccess", map[string]string{"taskToken": token, "output": "{}"}, nil); typ != errTaskDoesNotExist {
+		t.Errorf("SendTaskSuccess() of a used token = %q, want %q", typ, errTaskDoesNotExist)
+	}
+}
+
+func TestServer_errors(t *testing.T) {
+	c := newClient(t, New(worker.NewEngine()))
+	sm := c.createStateMachine("sm", "", passDefinition)
+
+	tests := []struct {
+		name string
+		op   string
+		in   interface{}
+		want string
+	}{
+		{"unknown operation", "XXX", map[string]string{}, errUnknownOperation},
+		{"invalid request", "StartExecution", "xxx", errSerialization},
+		{"invalid definition", "CreateStateMachine", map[string]string{"name": "x", "definition": `{"States": {}}`}, errInvalidDefinition},
+		{"invalid name", "CreateStateMachine", map[string]string{"name": "a b", "definition": passDefinition}, errInvalidName},
+		{"invalid type", "CreateStateMachine", map[string]string{"name": "x", "type": "XXX", "definition": passDefinition}, errValidation},
+		{"no state machine", "StartExecution", map[string]string{"stateMachineArn": sm + "x"}, errStateMachineDoesNotExist},
+		{"invalid arn", "StartExecution", map[string]string{"stateMachineArn": "sm"}, errInvalidArn},
+		{"missing arn", "StartExecution", map[string]string{}, errValidation},
+		{"invalid input", "StartExecution", map[string]string{"stateMachineArn": sm, "input": "{"}, errInvalidExecutionInput},
+		{"no execution", "DescribeExecution", map[string]string{"executionArn": sm + ":x"}, errExecutionDoesNotExist},
+		{"no history", "GetExecutionHistory", map[string]string{"executionArn": sm + ":x"}, errExecutionDoesNotExist},
+		{"stop no execution", "StopExecution", map[string]string{"executionArn": sm + ":x"}, errExecutionDoesNotExist},
+		{"invalid status filter", "ListExecutions", map[string]string{"stateMachineArn": sm, "statusFilter": "XXX"}, errValidation},
+		{"invalid next token", "ListExecutions", map[string]string{"stateMachineArn": sm, "nextToken": "xxx"}, errInvalidToken},
+		{"invalid max results", "ListExecutions", map[string]interface{}{"stateMachineArn": sm, "maxResults": 1001}, errValidation},
+		{"no task", "SendTaskHeartbeat", map[string]string{"taskToken": "xxx"}, errTaskDoesNotExist},
+		{"missing output", "SendTaskSuccess", map[string]string{"taskToken": "xxx"}, errValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.call(tt.op, tt.in, nil); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.op, got, tt.want)
+			}
+		})
+	}
+}
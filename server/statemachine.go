@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+// The types of state machines.
+const (
+	typeStandard = "STANDARD"
+	typeExpress  = "EXPRESS"
+)
+
+type stateMachine struct {
+	arn          string
+	name         string
+	typ          string
+	definition   string
+	roleArn      string
+	creationDate time.Time
+	workflow     *compiler.Workflow
+}
+
+type createStateMachineInput struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+	RoleArn    string `json:"roleArn"`
+	Type       string `json:"type"`
+}
+
+type createStateMachineOutput struct {
+	StateMachineArn string           `json:"stateMachineArn"`
+	CreationDate    worker.Timestamp `json:"creationDate"`
+}
+
+// createStateMachine compiles the definition. The role is not assumed, since the tasks run on this host.
+// Creating the same state machine again returns the existing one, as AWS does.
+func (s *Server) createStateMachine(ctx context.Context, body []byte) (interface{}, error) {
+	var in createStateMachineInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := required("name", in.Name); err != nil {
+		return nil, err
+	}
+	if err := required("definition", in.Definition); err != nil {
+		return nil, err
+	}
+	if !validName(in.Name) {
+		return nil, newError(errInvalidName, "invalid name: %q", in.Name)
+	}
+	if in.Type == "" {
+		in.Type = typeStandard
+	}
+	if in.Type != typeStandard && in.Type != typeExpress {
+		return nil, newError(errValidation, "invalid type: %q", in.Type)
+	}
+
+	w, err := compiler.Compile(ctx, bytes.NewBufferString(in.Definition))
+	if err != nil {
+		return nil, newError(errInvalidDefinition, "%v", err)
+	}
+
+	sm := &stateMachine{
+		arn:          s.stateMachineArn(in.Name),
+		name:         in.Name,
+		typ:          in.Type,
+		definition:   in.Definition,
+		roleArn:      in.RoleArn,
+		creationDate: time.Now(),
+		workflow:     w,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.stateMachines[sm.arn]; ok {
+		if existing.typ != sm.typ || existing.definition != sm.definition || existing.roleArn != sm.roleArn {
+			return nil, newError(errStateMachineAlreadyExists, "state machine already exists: %s", sm.arn)
+		}
+		sm = existing
+	}
+	s.stateMachines[sm.arn] = sm
+
+	return createStateMachineOutput{
+		StateMachineArn: sm.arn,
+		CreationDate:    worker.Timestamp{Time: sm.creationDate},
+	}, nil
+}
+
+// lookupStateMachine returns the state machine of arn. s.mu must be held.
+func (s *Server) lookupStateMachine(arn string) (*stateMachine, error) {
+	if err := required("stateMachineArn", arn); err != nil {
+		return nil, err
+	}
+	if err := validArn(arn); err != nil {
+		return nil, err
+	}
+
+	sm, ok := s.stateMachines[arn]
+	if !ok {
+		return nil, newError(errStateMachineDoesNotExist, "state machine does not exist: %s", arn)
+	}
+	return sm, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/w-haibara/kakemoti/worker"
+)
+
+type sendTaskSuccessInput struct {
+	TaskToken string `json:"taskToken"`
+	Output    string `json:"output"`
+}
+
+type sendTaskFailureInput struct {
+	TaskToken string `json:"taskToken"`
+	Error     string `json:"error"`
+	Cause     string `json:"cause"`
+}
+
+type sendTaskHeartbeatInput struct {
+	TaskToken string `json:"taskToken"`
+}
+
+// emptyOutput is the output of the operations that return nothing.
+type emptyOutput struct{}
+
+func (s *Server) sendTaskSuccess(ctx context.Context, body []byte) (interface{}, error) {
+	var in sendTaskSuccessInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := required("taskToken", in.TaskToken); err != nil {
+		return nil, err
+	}
+	if err := required("output", in.Output); err != nil {
+		return nil, err
+	}
+
+	return emptyOutput{}, taskError(s.engine.SendTaskSuccess(in.TaskToken, []byte(in.Output)))
+}
+
+func (s *Server) sendTaskFailure(ctx context.Context, body []byte) (interface{}, error) {
+	var in sendTaskFailureInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := required("taskToken", in.TaskToken); err != nil {
+		return nil, err
+	}
+
+	return emptyOutput{}, taskError(s.engine.SendTaskFailure(in.TaskToken, in.Error, in.Cause))
+}
+
+func (s *Server) sendTaskHeartbeat(ctx context.Context, body []byte) (interface{}, error) {
+	var in sendTaskHeartbeatInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := required("taskToken", in.TaskToken); err != nil {
+		return nil, err
+	}
+
+	return emptyOutput{}, taskError(s.engine.SendTaskHeartbeat(in.TaskToken))
+}
+
+// taskError converts an error of the engine on a task token to the error of the API.
+func taskError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, worker.ErrTaskTokenNotFound):
+		return newError(errTaskDoesNotExist, "%v", err)
+	case errors.Is(err, worker.ErrInvalidTaskOutput):
+		return newError(errInvalidOutput, "%v", err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,24 @@
+package fn
+
+import "context"
+
+type heartbeatKey struct{}
+
+// WithHeartbeat returns a copy of ctx that carries the channel notified by SendHeartbeat.
+func WithHeartbeat(ctx context.Context, ch chan<- struct{}) context.Context {
+	return context.WithValue(ctx, heartbeatKey{}, ch)
+}
+
+// SendHeartbeat reports that the task running with ctx is still alive.
+// It never blocks, and does nothing if the task has no heartbeat.
+func SendHeartbeat(ctx context.Context) {
+	ch, ok := ctx.Value(heartbeatKey{}).(chan<- struct{})
+	if !ok {
+		return
+	}
+
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
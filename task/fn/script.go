@@ -1,9 +1,11 @@
 package fn
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"reflect"
@@ -15,6 +17,12 @@ const (
 	scriptInputPrefix  = "KAKEMOTI_IN"
 	scriptOutputPrefix = "KAKEMOTI_OUT"
 	scriptErrorPrefix  = "KAKEMOTI_ERR"
+	scriptHeartbeat    = "KAKEMOTI_HEARTBEAT"
+	// scriptMaxLineSize is the size of the longest line a script can write to the standard output.
+	// The output is read line by line to find the heartbeats, and a longer line fails the task.
+	scriptMaxLineSize = 1024 * 1024
+	// scriptMaxStderrSize is how much of the end of the standard error is kept for the cause of a failure.
+	scriptMaxStderrSize = 64 * 1024
 )
 
 func DoScriptTask(ctx context.Context, path string, in Obj) (Obj, string, error) {
@@ -33,14 +41,83 @@ func DoScriptTask(ctx context.Context, path string, in Obj) (Obj, string, error)
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, marshalArgs(args)...)
 
-	out, err := cmd.Output()
+	stderr := &tailBuffer{max: scriptMaxStderrSize}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, "", err
 	}
 
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), scriptMaxLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == scriptHeartbeat {
+			SendHeartbeat(ctx)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		_, _ = io.Copy(io.Discard, stdout)
+		_ = cmd.Wait()
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, "", fmt.Errorf("a line of the output is longer than %d bytes: %w", scriptMaxLineSize, err)
+		}
+		return nil, "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitErr.Stderr = stderr.Bytes()
+		}
+		if stderr.Len() > 0 {
+			return nil, "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(stderr.Bytes())))
+		}
+		return nil, "", err
+	}
+
+	output, stateserror := parseScriptOutput(lines)
+	return output, stateserror, nil
+}
+
+// tailBuffer is an io.Writer that keeps only the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > b.max {
+		p = p[len(p)-b.max:]
+	}
+	if over := len(b.buf) + len(p) - b.max; over > 0 {
+		b.buf = b.buf[over:]
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
+
+func (b *tailBuffer) Len() int {
+	return len(b.buf)
+}
+
+func parseScriptOutput(lines []string) (Obj, string) {
 	output := Obj{}
 	stateserror := ""
-	for _, line := range strings.Split(string(out), "\n") {
+	for _, line := range lines {
 		if strings.HasPrefix(line, scriptErrorPrefix+"=") {
 			stateserror = strings.TrimPrefix(line, scriptErrorPrefix+"=")
 			continue
@@ -60,7 +137,7 @@ func DoScriptTask(ctx context.Context, path string, in Obj) (Obj, string, error)
 		output[s[0]] = s[1]
 	}
 
-	return output, stateserror, nil
+	return output, stateserror
 }
 
 func marshalArgs(args interface{}) []string {
@@ -1,7 +1,13 @@
 package fn
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/k0kubun/pp"
@@ -138,3 +144,74 @@ func Test_marshalArgsToMap(t *testing.T) {
 		})
 	}
 }
+
+func TestDoScriptTask_heartbeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.sh")
+	script := "#!/bin/sh\necho KAKEMOTI_HEARTBEAT\necho KAKEMOTI_OUT_result=ok\n"
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	beats := make(chan struct{}, 1)
+	ctx := WithHeartbeat(context.Background(), beats)
+	got, stateserror, err := DoScriptTask(ctx, path, Obj{"args": []string{}})
+	if err != nil || stateserror != "" {
+		t.Fatalf("DoScriptTask() failed: %v, %s", err, stateserror)
+	}
+
+	if !reflect.DeepEqual(got, Obj{"result": "ok"}) {
+		t.Errorf("DoScriptTask() = %v", got)
+	}
+
+	select {
+	case <-beats:
+	default:
+		t.Error("no heartbeat was sent")
+	}
+}
+
+func TestDoScriptTask_error(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"stderr", "#!/bin/sh\necho oops >&2\nexit 1\n", "exit status 1: oops"},
+		{"no stderr", "#!/bin/sh\nexit 2\n", "exit status 2"},
+		{"stderr tail", "#!/bin/sh\nhead -c 100000 /dev/zero | tr '\\0' a >&2\necho end >&2\nexit 1\n", "aaaend"},
+		{"too long line", "#!/bin/sh\nhead -c 2000000 /dev/zero | tr '\\0' a\n", "bufio.Scanner: token too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "script.sh")
+			if err := os.WriteFile(path, []byte(tt.script), 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := DoScriptTask(context.Background(), path, Obj{"args": []string{}})
+			if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+				t.Fatalf("DoScriptTask() error = %v, want %q", err, tt.wantErr)
+			}
+			if len(err.Error()) > scriptMaxStderrSize+100 {
+				t.Errorf("DoScriptTask() error is %d bytes", len(err.Error()))
+			}
+
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && !strings.HasSuffix(err.Error(), strings.TrimSpace(string(exitErr.Stderr))) {
+				t.Errorf("ExitError.Stderr = %q", exitErr.Stderr)
+			}
+		})
+	}
+}
+
+func Test_tailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 4}
+	for _, s := range []string{"ab", "cd", "e", "fghij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+	if got := string(b.Bytes()); got != "ghij" {
+		t.Errorf("Bytes() = %q, want %q", got, "ghij")
+	}
+}
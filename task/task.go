@@ -24,11 +24,28 @@ func RegisterDefault() {
 }
 
 func Register(name string, fn Fn) {
-	fnMap[name] = fn
+	fnMap.Register(name, fn)
+}
+
+// DefaultFnMap returns a copy of the task functions registered by Register.
+func DefaultFnMap() FnMap {
+	m := make(FnMap, len(fnMap))
+	for name, fn := range fnMap {
+		m[name] = fn
+	}
+	return m
+}
+
+func (m FnMap) Register(name string, fn Fn) {
+	m[name] = fn
 }
 
 func Do(ctx context.Context, resourceType, resoucePath string, input interface{}) (interface{}, string, error) {
-	f, ok := fnMap[resourceType]
+	return fnMap.Do(ctx, resourceType, resoucePath, input)
+}
+
+func (m FnMap) Do(ctx context.Context, resourceType, resoucePath string, input interface{}) (interface{}, string, error) {
+	f, ok := m[resourceType]
 	if !ok {
 		return nil, "", fmt.Errorf("invalid resouce type: %s", resourceType)
 	}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+var (
+	ErrTaskTokenNotFound = errors.New("task token not found")
+	ErrInvalidTaskOutput = errors.New("invalid task output")
+	ErrTaskFailureSent   = errors.New("task failure sent")
+)
+
+const (
+	taskTokenContextPath  = "$$.Task.Token"
+	taskTokenContextField = "$.Task.Token"
+)
+
+// taskCallback is a SendTaskSuccess, SendTaskFailure or SendTaskHeartbeat call for a task token.
+type taskCallback struct {
+	output    interface{}
+	stateserr StatesError
+	heartbeat bool
+}
+
+// taskTokens holds the tasks that are waiting for their token to be returned.
+type taskTokens struct {
+	mu sync.Mutex
+	m  map[string]chan taskCallback
+}
+
+func newTaskTokens() *taskTokens {
+	return &taskTokens{m: make(map[string]chan taskCallback)}
+}
+
+func (t *taskTokens) register(token string) <-chan taskCallback {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan taskCallback, 1)
+	t.m[token] = ch
+	return ch
+}
+
+func (t *taskTokens) unregister(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.m, token)
+}
+
+func (t *taskTokens) send(token string, cb taskCallback) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch, ok := t.m[token]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskTokenNotFound, token)
+	}
+
+	if cb.heartbeat {
+		select {
+		case ch <- cb:
+		default:
+		}
+		return nil
+	}
+
+	// a task is completed only once, so the token is no longer valid
+	delete(t.m, token)
+
+	// a heartbeat not received yet is superseded by the completion, so that the send never blocks under the lock
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- cb
+	return nil
+}
+
+// SendTaskSuccess completes the task waiting for token with output, which must be a JSON text.
+func (e *Engine) SendTaskSuccess(token string, output []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(output, &v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTaskOutput, err)
+	}
+
+	return e.tokens.send(token, taskCallback{output: v})
+}
+
+// SendTaskFailure fails the task waiting for token with the error name and the cause.
+func (e *Engine) SendTaskFailure(token, errorName, cause string) error {
+	return e.tokens.send(token, taskCallback{
+		stateserr: StatesError{Name: errorName, Cause: cause, Err: ErrTaskFailureSent},
+	})
+}
+
+// SendTaskHeartbeat reports that the task waiting for token is still in progress,
+// which restarts the timer of its HeartbeatSeconds.
+func (e *Engine) SendTaskHeartbeat(token string) error {
+	return e.tokens.send(token, taskCallback{heartbeat: true})
+}
+
+// withTaskToken returns a copy of coj that has a new task token in $$.Task.Token.
+func (w Workflow) withTaskToken(coj *compiler.CtxObj) (*compiler.CtxObj, error) {
+	token, err := w.getEngine().newID()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := copyCtxObj(coj)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SetByString(taskTokenContextField, token)
+}
+
+func taskToken(coj *compiler.CtxObj) (string, error) {
+	v, ok := coj.GetByString(taskTokenContextPath)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not set", ErrTaskTokenNotFound, taskTokenContextPath)
+	}
+
+	token, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not a string", ErrTaskTokenNotFound, taskTokenContextPath)
+	}
+
+	return token, nil
+}
+
+// waitForTaskToken runs the task and waits until its token is returned through callbacks.
+// The output of the task itself is discarded.
+func (w Workflow) waitForTaskToken(ctx context.Context, token string, callbacks <-chan taskCallback, state compiler.TaskState, input interface{}) (interface{}, StatesError) {
+	if _, stateserr := w.evalTask(ctx, state, input); !stateserr.IsEmpty() {
+		return nil, stateserr
+	}
+
+	w.logger().WithFields(stateFields(state)).Println("wait for task token:", token)
+
+	for {
+		select {
+		case cb := <-callbacks:
+			if cb.heartbeat {
+				fn.SendHeartbeat(ctx)
+				continue
+			}
+			if !cb.stateserr.IsEmpty() {
+				return nil, cb.stateserr
+			}
+			return cb.output, NewStatesError("", nil)
+		case <-ctx.Done():
+			return nil, interruptedError(ctx.Err())
+		}
+	}
+}
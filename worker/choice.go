@@ -2,24 +2,32 @@ package worker
 
 import (
 	"context"
+	"errors"
 
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
-func (w Workflow) evalChoice(ctx context.Context, coj *compiler.CtxObj, state compiler.ChoiceState, input interface{}) (string, interface{}, statesError) {
-	for _, choice := range state.Choices {
-		ok, err := choice.Condition.Eval(coj, input)
+// evalChoice returns the Choice rule that matched, or the one of Default that has only Next.
+func (w Workflow) evalChoice(ctx context.Context, coj *compiler.CtxObj, state compiler.ChoiceState, input interface{}) (*compiler.Choice, interface{}, StatesError) {
+	for i, choice := range state.Choices {
+		ok, err := choice.Condition.Eval(ctx, coj, input)
+		if err != nil && ctx.Err() != nil {
+			return nil, nil, interruptedError(ctx.Err())
+		}
+		if errors.Is(err, compiler.ErrQueryEvaluationFailed) {
+			return nil, nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+		}
 		if err != nil {
-			return "", nil, NewStatesError("", err)
+			return nil, nil, NewStatesError("", err)
 		}
 		if ok {
-			return choice.Next, input, NewStatesError("", nil)
+			return &state.Choices[i], input, NewStatesError("", nil)
 		}
 	}
 
 	if state.Default == "" {
-		return "", nil, NewStatesError(StatesErrorBranchFailed, nil)
+		return nil, nil, NewStatesError(StatesErrorNoChoiceMatched, errors.New("no Choice rule matched and no Default is specified"))
 	}
 
-	return state.Default, input, NewStatesError("", nil)
+	return &compiler.Choice{Next: state.Default}, input, NewStatesError("", nil)
 }
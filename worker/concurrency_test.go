@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+// TestEngine_Execute_concurrent runs one compiled workflow many times at once.
+// Run it with -race to detect the executions or the branches sharing mutable values.
+func TestEngine_Execute_concurrent(t *testing.T) {
+	const executions = 200
+
+	asl := `{"StartAt": "P", "States": {
+		"P": {"Type": "Parallel", "ResultPath": "$.result", "Next": "Done", "Branches": [
+			{"StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "mutate:aaa", "ResultPath": "$.task", "Next": "F"},
+				"F": {"Type": "Pass", "Parameters": {
+					"n.$": "$.n", "task.$": "$.task", "sum.$": "States.MathAdd($.n, 1)", "exec.$": "$$.Execution.Input.n"}, "End": true}}},
+			{"StartAt": "M", "States": {
+				"M": {"Type": "Map", "ItemsPath": "$.items", "ResultPath": "$.items", "End": true, "Iterator": {"StartAt": "I", "States": {
+					"I": {"Type": "Pass", "Parameters": {"index.$": "$$.Map.Item.Index"}, "ResultPath": "$.meta", "End": true}}}}}},
+			{"StartAt": "R", "States": {
+				"R": {"Type": "Pass", "Result": {"static": [1, 2]}, "ResultPath": "$.items[0].r", "End": true}}}
+		]},
+		"Done": {"Type": "Pass", "ResultPath": "$.items[1].done", "Result": true, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	engine := NewEngine(WithTaskRegistry(task.FnMap{
+		// a careless task function that modifies its input
+		"mutate": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			in["mutated"] = path
+			return in, "", nil
+		},
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < executions; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			input := fmt.Sprintf(`{"n": %d, "items": [{"v": %d}, {"v": %d}]}`, i, i, i+1)
+			res, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(input))
+			if err != nil || res.Status != ExecutionStatusSucceeded {
+				t.Errorf("Execute() = %#v, %v", res, err)
+				return
+			}
+
+			want := fmt.Sprintf(`{"n": %[1]d, "items": [{"v": %[1]d}, {"v": %[2]d, "done": true}], "result": [
+				{"n": %[1]d, "sum": %[2]d, "exec": %[1]d, "task": {"n": %[1]d, "items": [{"v": %[1]d}, {"v": %[2]d}], "mutated": "aaa"}},
+				{"n": %[1]d, "items": [{"v": %[1]d, "meta": {"index": 0}}, {"v": %[2]d, "meta": {"index": 1}}]},
+				{"n": %[1]d, "items": [{"v": %[1]d, "r": {"static": [1, 2]}}, {"v": %[2]d}]}]}`, i, i+1)
+			var got, wantv interface{}
+			if err := json.Unmarshal(res.Output, &got); err != nil {
+				t.Errorf("json.Unmarshal() failed: %v", err)
+				return
+			}
+			if err := json.Unmarshal([]byte(want), &wantv); err != nil {
+				t.Errorf("json.Unmarshal() failed: %v", err)
+				return
+			}
+			if d := cmp.Diff(got, wantv); d != "" {
+				t.Errorf("Execute() output of execution %d differs: \n%s", i, d)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+// The fields of the context object maintained by the worker.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-contextobject.html
+const (
+	ctxExecutionID     = "$$.Execution.Id"
+	ctxExecution       = "$.Execution"
+	ctxExecutionFields = "$$.Execution"
+	ctxStateMachineID  = "$$.StateMachine.Id"
+	ctxStateMachine    = "$.StateMachine"
+	ctxState           = "$.State"
+	ctxStateEntered    = "$$.State.EnteredTime"
+)
+
+// timeFormat is the format of the timestamps in the context object, such as $$.Execution.StartTime.
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// withExecution returns a copy of coj that has $$.Execution and $$.StateMachine of w.
+// The fields given by the caller, or inherited from the parent of a branch, are kept as they are,
+// and the missing ones are filled in.
+func (w Workflow) withExecution(coj *compiler.CtxObj, input interface{}, startTime time.Time) (*compiler.CtxObj, error) {
+	c, err := copyCtxObj(coj)
+	if err != nil {
+		return nil, err
+	}
+
+	execution := map[string]interface{}{
+		"Id":        w.ID,
+		"Name":      w.ID,
+		"Input":     compiler.CopyJSON(input),
+		"StartTime": startTime.UTC().Format(timeFormat),
+	}
+	if v, ok := c.GetByString(ctxExecutionFields); ok {
+		given, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid $$.Execution: %v", v)
+		}
+		for k, v := range given {
+			execution[k] = v
+		}
+	}
+	c, err = c.SetByString(ctxExecution, execution)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.GetByString(ctxStateMachineID); !ok {
+		c, err = c.SetByString(ctxStateMachine, map[string]interface{}{
+			"Id":   w.ID,
+			"Name": w.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// withState returns a copy of coj that has $$.State of the state entered at enteredTime.
+func (w Workflow) withState(coj *compiler.CtxObj, state compiler.State, enteredTime time.Time, retryCount int) (*compiler.CtxObj, error) {
+	c, err := copyCtxObj(coj)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SetByString(ctxState, map[string]interface{}{
+		"Name":        state.Name(),
+		"EnteredTime": enteredTime.UTC().Format(timeFormat),
+		"RetryCount":  retryCount,
+	})
+}
+
+// stateEnteredTime returns $$.State.EnteredTime of coj.
+func stateEnteredTime(coj *compiler.CtxObj) (time.Time, bool) {
+	v, ok := coj.GetByString(ctxStateEntered)
+	if !ok {
+		return time.Time{}, false
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// copyCtxObj returns a copy of coj whose top-level fields can be set without affecting coj.
+// The variables are shared, since they are never modified.
+func copyCtxObj(coj *compiler.CtxObj) (*compiler.CtxObj, error) {
+	if coj == nil {
+		return new(compiler.CtxObj), nil
+	}
+	c, err := new(compiler.CtxObj).SetAll(coj.GetAll())
+	if err != nil {
+		return nil, err
+	}
+	return c.WithVariables(coj.Variables()), nil
+}
@@ -0,0 +1,535 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+// The statuses of the iterations in the result files of ResultWriter.
+const (
+	mapResultSucceeded = "SUCCEEDED"
+	mapResultFailed    = "FAILED"
+)
+
+// mapResult is the result of an iteration of a Map state.
+type mapResult struct {
+	input  interface{}
+	output interface{}
+	err    error
+}
+
+// s3Path returns the local path of an object, which is <s3 dir>/<bucket>/<key>.
+func (e *Engine) s3Path(bucket, key string) (string, error) {
+	if bucket == "" || bucket != filepath.Base(bucket) || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket: %q", bucket)
+	}
+
+	p := filepath.Join(e.s3Dir, bucket, filepath.FromSlash(key))
+	rel, err := filepath.Rel(filepath.Join(e.s3Dir, bucket), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+
+	return p, nil
+}
+
+// resolveResourceArgs returns the Parameters or the Arguments of ItemReader or ResultWriter,
+// such as {"Bucket": "...", "Key": "..."}.
+func resolveResourceArgs(ctx context.Context, coj *compiler.CtxObj, params *compiler.PayloadTemplate, args *compiler.JSONataTemplate, input interface{}) (map[string]string, error) {
+	var v interface{}
+	var err error
+	switch {
+	case params != nil:
+		v, err = params.Resolve(ctx, coj, input)
+	case args != nil:
+		v, err = args.Eval(ctx, compiler.NewJSONataVars(coj, input))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, _ := v.(map[string]interface{})
+	res := make(map[string]string, len(m))
+	for k, e := range m {
+		str, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("'%s' must be a string: %v", k, e)
+		}
+		res[k] = str
+	}
+
+	return res, nil
+}
+
+// itemIterator returns the items of a Map state one by one, so that the items in a large object are not loaded at once.
+type itemIterator interface {
+	// next returns the next item, or false if there are no more items.
+	next() (interface{}, bool, error)
+	close() error
+}
+
+// sliceItems is an itemIterator of the items in memory, such as the ones selected from the input by ItemsPath.
+type sliceItems struct {
+	items []interface{}
+	i     int
+}
+
+func (it *sliceItems) next() (interface{}, bool, error) {
+	if it.i >= len(it.items) {
+		return nil, false, nil
+	}
+	v := it.items[it.i]
+	it.i++
+	return v, true, nil
+}
+
+func (it *sliceItems) close() error {
+	return nil
+}
+
+// readItems opens the items of a Map state with its ItemReader.
+func (w Workflow) readItems(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}) (itemIterator, StatesError) {
+	reader := state.ItemReader
+	args, err := resolveResourceArgs(ctx, coj, reader.Parameters, reader.Arguments, input)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorItemReaderFailed, err)
+	}
+
+	var items itemIterator
+	switch reader.Resource {
+	case compiler.ItemReaderResourceListObjectsV2:
+		var objects []interface{}
+		objects, err = w.listObjects(args["Bucket"], args["Prefix"], reader.ReaderConfig.MaxItems)
+		items = &sliceItems{items: objects}
+	default:
+		items, err = w.openObjectItems(args["Bucket"], args["Key"], reader.ReaderConfig)
+	}
+	if err != nil {
+		return nil, NewStatesError(StatesErrorItemReaderFailed, err)
+	}
+
+	return items, NewStatesError("", nil)
+}
+
+// objectItems is an itemIterator of the items in a JSON, JSON Lines or CSV file, which are read as they are needed.
+type objectItems struct {
+	f        *os.File
+	key      string
+	maxItems int
+	n        int
+	done     bool
+	read     func() (interface{}, bool, error)
+}
+
+func (it *objectItems) next() (interface{}, bool, error) {
+	if it.done || (it.maxItems > 0 && it.n >= it.maxItems) {
+		return nil, false, nil
+	}
+
+	v, ok, err := it.read()
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %v", it.key, err)
+	}
+	if !ok {
+		it.done = true
+		return nil, false, nil
+	}
+	it.n++
+	return v, true, nil
+}
+
+func (it *objectItems) close() error {
+	return it.f.Close()
+}
+
+// openObjectItems opens a JSON, JSON Lines or CSV file to read the items in it.
+func (w Workflow) openObjectItems(bucket, key string, config compiler.ReaderConfig) (itemIterator, error) {
+	p, err := w.getEngine().s3Path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &objectItems{f: f, key: key, maxItems: config.MaxItems}
+	switch config.InputType {
+	case compiler.InputTypeJSON:
+		it.read, err = jsonArrayReader(f)
+	case compiler.InputTypeJSONL:
+		it.read = jsonLinesReader(f)
+	case compiler.InputTypeCSV:
+		it.read, err = csvReader(f, config)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+
+	return it, nil
+}
+
+// jsonArrayReader returns a function that decodes the elements of a JSON array one by one.
+func jsonArrayReader(r io.Reader) (func() (interface{}, bool, error), error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, errors.New("the JSON must be an array")
+	}
+
+	return func() (interface{}, bool, error) {
+		if !dec.More() {
+			// the array must be closed, or the file is truncated
+			if _, err := dec.Token(); err != nil {
+				return nil, false, fmt.Errorf("invalid JSON: %v", err)
+			}
+			return nil, false, nil
+		}
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, false, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return v, true, nil
+	}, nil
+}
+
+// jsonLinesReader returns a function that decodes the lines one by one, skipping the empty lines.
+func jsonLinesReader(r io.Reader) func() (interface{}, bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024)
+	line := 0
+
+	return func() (interface{}, bool, error) {
+		for scanner.Scan() {
+			line++
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+
+			var v interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+				return nil, false, fmt.Errorf("invalid JSON at line %d: %v", line, err)
+			}
+			return v, true, nil
+		}
+		return nil, false, scanner.Err()
+	}
+}
+
+// csvReader returns a function that reads the rows of a CSV file one by one, as objects whose keys are the headers.
+func csvReader(r io.Reader, config compiler.ReaderConfig) (func() (interface{}, bool, error), error) {
+	reader := csv.NewReader(r)
+	reader.Comma = config.Delimiter()
+	reader.ReuseRecord = true
+
+	headers := config.CSVHeaders
+	if config.CSVHeaderLocation != compiler.CSVHeaderLocationGiven {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the header: %v", err)
+		}
+		headers = append([]string(nil), record...)
+	}
+
+	return func() (interface{}, bool, error) {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if len(record) != len(headers) {
+			return nil, false, fmt.Errorf("the number of the fields does not match the headers: %v", record)
+		}
+
+		row := make(map[string]interface{}, len(headers))
+		for i, header := range headers {
+			row[header] = record[i]
+		}
+		return row, true, nil
+	}, nil
+}
+
+// listObjects returns the metadata of the objects in a bucket, whose keys start with prefix.
+func (w Workflow) listObjects(bucket, prefix string, maxItems int) ([]interface{}, error) {
+	root, err := w.getEngine().s3Path(bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	items := []interface{}{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		items = append(items, map[string]interface{}{
+			"Key":          key,
+			"Size":         float64(info.Size()),
+			"LastModified": info.ModTime().UTC().Format(timeFormat),
+			"StorageClass": "STANDARD",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].(map[string]interface{})["Key"].(string) < items[j].(map[string]interface{})["Key"].(string)
+	})
+	if maxItems > 0 && len(items) > maxItems {
+		items = items[:maxItems]
+	}
+
+	return items, nil
+}
+
+// itemBatcher groups the inputs of the iterations into the batches of ItemBatcher, as they are read.
+type itemBatcher struct {
+	config   *compiler.ItemBatcher
+	base     map[string]interface{}
+	baseSize int
+	// pending is the input that did not fit in the last batch, which is the first one of the next batch
+	pending    interface{}
+	hasPending bool
+}
+
+func newItemBatcher(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}) (*itemBatcher, StatesError) {
+	config := state.ItemBatcher
+
+	base := map[string]interface{}{}
+	var batchInput interface{}
+	var err error
+	switch {
+	case config.BatchInput != nil:
+		batchInput, err = config.BatchInput.Resolve(ctx, coj, input)
+	case config.BatchInputExpr != nil:
+		batchInput, err = config.BatchInputExpr.Eval(ctx, compiler.NewJSONataVars(coj, input))
+	}
+	if err != nil {
+		if errors.Is(err, compiler.ErrQueryEvaluationFailed) {
+			return nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+		}
+		return nil, NewStatesError("", err)
+	}
+	if batchInput != nil {
+		base["BatchInput"] = batchInput
+	}
+
+	b, err := json.Marshal(base)
+	if err != nil {
+		return nil, NewStatesError("", err)
+	}
+
+	// the size of {"BatchInput": ..., "Items": []}
+	return &itemBatcher{config: config, base: base, baseSize: len(b) + len(`,"Items":[]`)}, NewStatesError("", nil)
+}
+
+// next returns the next batch of the inputs returned by nextInput, and the number of the inputs in it.
+func (b *itemBatcher) next(nextInput func() (interface{}, bool, StatesError)) (interface{}, int, bool, StatesError) {
+	var batch []interface{}
+	size := b.baseSize
+	for {
+		var item interface{}
+		if b.hasPending {
+			item, b.pending, b.hasPending = b.pending, nil, false
+		} else {
+			v, ok, stateserr := nextInput()
+			if !stateserr.IsEmpty() {
+				return nil, 0, false, stateserr
+			}
+			if !ok {
+				break
+			}
+			item = v
+		}
+
+		v, err := json.Marshal(item)
+		if err != nil {
+			return nil, 0, false, NewStatesError("", err)
+		}
+		itemSize := len(v) + 1
+
+		// an item larger than MaxInputBytesPerBatch is a batch by itself
+		if b.config.MaxInputBytesPerBatch > 0 && len(batch) > 0 && size+itemSize > b.config.MaxInputBytesPerBatch {
+			b.pending, b.hasPending = item, true
+			break
+		}
+		batch = append(batch, item)
+		size += itemSize
+		if b.config.MaxItemsPerBatch > 0 && len(batch) >= b.config.MaxItemsPerBatch {
+			break
+		}
+	}
+
+	if len(batch) == 0 {
+		return nil, 0, false, NewStatesError("", nil)
+	}
+
+	v := make(map[string]interface{}, len(b.base)+1)
+	for k, e := range b.base {
+		v[k] = e
+	}
+	v["Items"] = batch
+	return v, len(batch), true, NewStatesError("", nil)
+}
+
+// toleratedFailureExceeded reports whether more items failed than the thresholds of the state.
+// The items in a failed batch are all counted as failed.
+// The percentage is not checked if total is negative, which means that the items are not all read yet.
+func toleratedFailureExceeded(state compiler.MapState, failed, total int) bool {
+	if state.ToleratedFailureCount == nil && state.ToleratedFailurePercentage == nil {
+		return failed > 0
+	}
+	if v := state.ToleratedFailureCount; v != nil && failed > *v {
+		return true
+	}
+	if v := state.ToleratedFailurePercentage; v != nil && total > 0 && float64(failed)*100 > *v*float64(total) {
+		return true
+	}
+	return false
+}
+
+// writeResults writes the results of the iterations with ResultWriter, and returns the output of the state:
+// {"MapRunArn": "...", "ResultWriterDetails": {"Bucket": "...", "Key": "<Prefix>/<map run id>/manifest.json"}}
+func (w Workflow) writeResults(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}, results []*mapResult) (interface{}, StatesError) {
+	writer := state.ResultWriter
+	args, err := resolveResourceArgs(ctx, coj, writer.Parameters, writer.Arguments, input)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+	}
+
+	runID, err := w.getEngine().newID()
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, err)
+	}
+	mapRunArn := fmt.Sprintf("%s/%s:%s", w.ID, state.Name(), runID)
+
+	bucket := args["Bucket"]
+	prefix := path.Join(args["Prefix"], runID)
+	dir, err := w.getEngine().s3Path(bucket, prefix)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+	}
+
+	entries := map[string][]interface{}{
+		mapResultSucceeded: {},
+		mapResultFailed:    {},
+	}
+	for _, result := range results {
+		in, err := json.Marshal(result.input)
+		if err != nil {
+			return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+		}
+		entry := map[string]interface{}{
+			"Input": string(in),
+		}
+
+		if result.err != nil {
+			serr := newIterationError(result.err)
+			entry["Status"] = mapResultFailed
+			for k, v := range serr.errorOutput() {
+				entry[k] = v
+			}
+			entries[mapResultFailed] = append(entries[mapResultFailed], entry)
+			continue
+		}
+
+		out, err := json.Marshal(result.output)
+		if err != nil {
+			return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+		}
+		entry["Status"] = mapResultSucceeded
+		entry["Output"] = string(out)
+		entries[mapResultSucceeded] = append(entries[mapResultSucceeded], entry)
+	}
+
+	files := map[string][]interface{}{
+		mapResultSucceeded: {},
+		mapResultFailed:    {},
+		"PENDING":          {},
+	}
+	for _, status := range []string{mapResultSucceeded, mapResultFailed} {
+		if len(entries[status]) == 0 {
+			continue
+		}
+
+		name := status + "_0.json"
+		size, err := writeJSONFile(filepath.Join(dir, name), entries[status])
+		if err != nil {
+			return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+		}
+		files[status] = append(files[status], map[string]interface{}{
+			"Key":  path.Join(prefix, name),
+			"Size": float64(size),
+		})
+	}
+
+	manifest := map[string]interface{}{
+		"DestinationBucket": bucket,
+		"MapRunArn":         mapRunArn,
+		"ResultFiles":       files,
+	}
+	if _, err := writeJSONFile(filepath.Join(dir, "manifest.json"), manifest); err != nil {
+		return nil, NewStatesError(StatesErrorResultWriterFailed, err)
+	}
+
+	return map[string]interface{}{
+		"MapRunArn": mapRunArn,
+		"ResultWriterDetails": map[string]interface{}{
+			"Bucket": bucket,
+			"Key":    path.Join(prefix, "manifest.json"),
+		},
+	}, NewStatesError("", nil)
+}
+
+func writeJSONFile(name string, v interface{}) (int, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(name, b, 0600); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func TestEngine_Execute_distributedMap(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bucket/items.json":      `[{"n": 1}, {"n": 2}, {"n": 3}]`,
+		"bucket/items.jsonl":     "{\"n\": 1}\n\n{\"n\": 2}\n{\"n\": 3}\n",
+		"bucket/items.csv":       "id,name\n1,a\n2,b\n3,c\n",
+		"bucket/broken.jsonl":    "{\"n\": 1}\n{",
+		"bucket/headless.tsv":    "1\ta\n2\tb\n",
+		"bucket/logs/2022/a.log": "aaa",
+		"bucket/logs/2022/b.log": "bb",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithS3Dir(dir),
+	)
+
+	pass := `{"Type": "Pass", "End": true}`
+	tests := []struct {
+		name       string
+		reader     string
+		others     string
+		iterator   string
+		wantStatus ExecutionStatus
+		want       string
+	}{
+		{
+			"json",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON", "MaxItems": 2}, "Parameters": {"Bucket": "bucket", "Key": "items.json"}}`,
+			``,
+			pass,
+			ExecutionStatusSucceeded, `[{"n":1},{"n":2}]`,
+		},
+		{
+			"json lines",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSONL"}, "Parameters": {"Bucket": "bucket", "Key.$": "$.key"}}`,
+			``,
+			pass,
+			ExecutionStatusSucceeded, `[{"n":1},{"n":2},{"n":3}]`,
+		},
+		{
+			"csv",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "FIRST_ROW"}, "Parameters": {"Bucket": "bucket", "Key": "items.csv"}}`,
+			``,
+			pass,
+			ExecutionStatusSucceeded, `[{"id":"1","name":"a"},{"id":"2","name":"b"},{"id":"3","name":"c"}]`,
+		},
+		{
+			"csv(given headers)",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "CSV", "CSVHeaderLocation": "GIVEN", "CSVHeaders": ["id", "name"], "CSVDelimiter": "TAB"},
+				"Parameters": {"Bucket": "bucket", "Key": "headless.tsv"}}`,
+			``,
+			pass,
+			ExecutionStatusSucceeded, `[{"id":"1","name":"a"},{"id":"2","name":"b"}]`,
+		},
+		{
+			"list objects",
+			`{"Resource": "arn:aws:states:::s3:listObjectsV2", "Parameters": {"Bucket": "bucket", "Prefix": "logs/"}}`,
+			``,
+			`{"Type": "Pass", "Parameters": {"Key.$": "$.Key", "Size.$": "$.Size"}, "End": true}`,
+			ExecutionStatusSucceeded, `[{"Key":"logs/2022/a.log","Size":3},{"Key":"logs/2022/b.log","Size":2}]`,
+		},
+		{
+			"batch",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON"}, "Parameters": {"Bucket": "bucket", "Key": "items.json"}}`,
+			`"ItemBatcher": {"MaxItemsPerBatch": 2, "BatchInput": {"key.$": "$.key"}},`,
+			pass,
+			ExecutionStatusSucceeded, `[{"BatchInput":{"key":"items.jsonl"},"Items":[{"n":1},{"n":2}]},{"BatchInput":{"key":"items.jsonl"},"Items":[{"n":3}]}]`,
+		},
+		{
+			"batch(bytes)",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON"}, "Parameters": {"Bucket": "bucket", "Key": "items.json"}}`,
+			`"ItemBatcher": {"MaxInputBytesPerBatch": 30},`,
+			pass,
+			ExecutionStatusSucceeded, `[{"Items":[{"n":1},{"n":2}]},{"Items":[{"n":3}]}]`,
+		},
+		{
+			// the broken line is never read, since the items are read as the iterations start
+			"read lazily",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSONL"}, "Parameters": {"Bucket": "bucket", "Key": "broken.jsonl"}}`,
+			`"MaxConcurrency": 1,`,
+			`{"Type": "Fail", "Error": "Custom.Error"}`,
+			ExecutionStatusFailed, `Custom.Error`,
+		},
+		{
+			"broken",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSONL"}, "Parameters": {"Bucket": "bucket", "Key": "broken.jsonl"}}`,
+			``,
+			pass,
+			ExecutionStatusFailed, ``,
+		},
+		{
+			"not found",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON"}, "Parameters": {"Bucket": "bucket", "Key": "missing.json"}}`,
+			``,
+			pass,
+			ExecutionStatusFailed, ``,
+		},
+		{
+			"outside of the bucket",
+			`{"Resource": "arn:aws:states:::s3:getObject", "ReaderConfig": {"InputType": "JSON"}, "Parameters": {"Bucket": "bucket", "Key": "../other/items.json"}}`,
+			``,
+			pass,
+			ExecutionStatusFailed, ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", ` + tt.others + ` "ItemReader": ` + tt.reader + `, "End": true,
+				"Iterator": {"StartAt": "P", "States": {"P": ` + tt.iterator + `}}}}}`
+
+			w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			res, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`{"key": "items.jsonl"}`))
+			if err != nil {
+				t.Fatal("Execute() failed:", err)
+			}
+			if res.Status != tt.wantStatus {
+				t.Fatalf("Status = %s, want %s: %s: %s", res.Status, tt.wantStatus, res.Error, res.Cause)
+			}
+			if res.Status == ExecutionStatusFailed {
+				want := StatesErrorItemReaderFailed
+				if tt.want != "" {
+					want = tt.want
+				}
+				if res.Error != want {
+					t.Errorf("Error = %s, want %s: %s", res.Error, want, res.Cause)
+				}
+				return
+			}
+			if string(res.Output) != tt.want {
+				t.Errorf("Output = %s, want %s", res.Output, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_Execute_resultWriter(t *testing.T) {
+	dir := t.TempDir()
+	tasks := task.FnMap{
+		"fail_on": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			if in["name"] == path {
+				return nil, "Custom.Error", nil
+			}
+			return in, "", nil
+		},
+	}
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithClock(func() time.Time { return time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC) }),
+		WithTaskRegistry(tasks),
+		WithS3Dir(dir),
+	)
+
+	tests := []struct {
+		name       string
+		tolerated  string
+		wantStatus ExecutionStatus
+		wantError  string
+		wantFiles  map[string]interface{}
+	}{
+		{
+			"tolerated",
+			`"ToleratedFailureCount": 1,`,
+			ExecutionStatusSucceeded, "",
+			map[string]interface{}{
+				"SUCCEEDED_0.json": []interface{}{
+					map[string]interface{}{"Input": `{"name":"a"}`, "Output": `{"name":"a"}`, "Status": "SUCCEEDED"},
+					map[string]interface{}{"Input": `{"name":"c"}`, "Output": `{"name":"c"}`, "Status": "SUCCEEDED"},
+				},
+				"FAILED_0.json": []interface{}{
+					map[string]interface{}{"Input": `{"name":"b"}`, "Error": "Custom.Error", "Cause": "fn() failed: Custom.Error", "Status": "FAILED"},
+				},
+			},
+		},
+		{
+			"tolerated(percentage)",
+			`"ToleratedFailurePercentage": 30,`,
+			ExecutionStatusFailed, StatesErrorExceedToleratedFailureThreshold, nil,
+		},
+		{
+			"not tolerated",
+			``,
+			ExecutionStatusFailed, "Custom.Error", nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemsPath": "$.items", ` + tt.tolerated + `
+				"ResultWriter": {"Resource": "arn:aws:states:::s3:putObject", "Parameters": {"Bucket": "results", "Prefix.$": "$.prefix"}}, "End": true,
+				"Iterator": {"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "fail_on:b", "End": true}}}}}}`
+			w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			prefix := "out/" + tt.name
+			input, err := json.Marshal(map[string]interface{}{
+				"prefix": prefix,
+				"items":  []interface{}{map[string]string{"name": "a"}, map[string]string{"name": "b"}, map[string]string{"name": "c"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := engine.Execute(context.Background(), nil, w, bytes.NewBuffer(input))
+			if err != nil {
+				t.Fatal("Execute() failed:", err)
+			}
+			if res.Status != tt.wantStatus || res.Error != tt.wantError {
+				t.Fatalf("Execute() = %s, %s: %s", res.Status, res.Error, res.Cause)
+			}
+			if tt.wantFiles == nil {
+				return
+			}
+
+			want := `{"MapRunArn":"id/M:id","ResultWriterDetails":{"Bucket":"results","Key":"` + prefix + `/id/manifest.json"}}`
+			if string(res.Output) != want {
+				t.Errorf("Output = %s, want %s", res.Output, want)
+			}
+
+			manifest := readJSONFile(t, filepath.Join(dir, "results", prefix, "id", "manifest.json"))
+			if d := cmp.Diff(manifest.(map[string]interface{})["DestinationBucket"], "results"); d != "" {
+				t.Errorf("manifest: \n%s", d)
+			}
+			for name, want := range tt.wantFiles {
+				got := readJSONFile(t, filepath.Join(dir, "results", prefix, "id", name))
+				if d := cmp.Diff(got, want); d != "" {
+					t.Errorf("%s: \n%s", name, d)
+				}
+			}
+		})
+	}
+}
+
+func readJSONFile(t *testing.T, name string) interface{} {
+	t.Helper()
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
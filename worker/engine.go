@@ -0,0 +1,352 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+)
+
+var ErrInvalidInput = errors.New("invalid execution input")
+
+type ExecutionStatus string
+
+const (
+	ExecutionStatusSucceeded ExecutionStatus = "SUCCEEDED"
+	ExecutionStatusFailed    ExecutionStatus = "FAILED"
+	ExecutionStatusTimedOut  ExecutionStatus = "TIMED_OUT"
+	ExecutionStatusAborted   ExecutionStatus = "ABORTED"
+)
+
+// Result is the outcome of an execution.
+// Error and Cause are set unless the execution succeeded.
+type Result struct {
+	ID        string          `json:"ID"`
+	Status    ExecutionStatus `json:"Status"`
+	Output    json.RawMessage `json:"Output,omitempty"`
+	Error     string          `json:"Error,omitempty"`
+	Cause     string          `json:"Cause,omitempty"`
+	StartDate time.Time       `json:"StartDate"`
+	StopDate  time.Time       `json:"StopDate"`
+	err       error
+}
+
+// Err returns the error that ended the execution, or nil if it succeeded.
+func (r Result) Err() error {
+	return r.err
+}
+
+// Engine executes compiled workflows.
+// It never terminates the process: every failure is reported through the Result or the returned error.
+type Engine struct {
+	logger    *log.Logger
+	newID     func() (string, error)
+	now       func() time.Time
+	tasks     task.FnMap
+	tokens    *taskTokens
+	s3Dir     string
+	store     ExecutionStore
+	histories *executionHistories
+	// history tells whether the histories of the executions are recorded
+	history bool
+}
+
+type Option func(*Engine)
+
+func WithLogger(logger *log.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
+
+func WithIDGenerator(newID func() (string, error)) Option {
+	return func(e *Engine) {
+		e.newID = newID
+	}
+}
+
+func WithClock(now func() time.Time) Option {
+	return func(e *Engine) {
+		e.now = now
+	}
+}
+
+func WithTaskRegistry(tasks task.FnMap) Option {
+	return func(e *Engine) {
+		e.tasks = tasks
+	}
+}
+
+// WithS3Dir sets the directory that stands in for Amazon S3 in ItemReader and ResultWriter of Map states.
+// Its subdirectories are the buckets, and the files in them are the objects. It is the current directory by default.
+func WithS3Dir(dir string) Option {
+	return func(e *Engine) {
+		e.s3Dir = dir
+	}
+}
+
+// WithExecutionStore makes the engine checkpoint the executions in the store, so that they can be resumed.
+// The executions are not checkpointed by default.
+func WithExecutionStore(store ExecutionStore) Option {
+	return func(e *Engine) {
+		e.store = store
+	}
+}
+
+// WithHistory makes the engine record the histories of the executions, which are returned by History.
+// The histories of the last limit finished executions are kept, in addition to the ones of the running executions.
+// The histories are not recorded by default, so that they do not use the memory of a long-running process.
+func WithHistory(limit int) Option {
+	return func(e *Engine) {
+		e.history = true
+		e.histories = newExecutionHistories(limit)
+	}
+}
+
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{
+		logger:    log.StandardLogger(),
+		newID:     newUUID,
+		now:       time.Now,
+		tasks:     task.DefaultFnMap(),
+		tokens:    newTaskTokens(),
+		s3Dir:     ".",
+		histories: newExecutionHistories(0),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func newUUID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+func (e *Engine) newWorkflow(w *compiler.Workflow) (*Workflow, error) {
+	id, err := e.newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Workflow{Workflow: w, ID: id, engine: e}, nil
+}
+
+type startedKey struct{}
+
+// WithStarted returns a copy of ctx that makes Execute call started with the StartDate of the execution,
+// as soon as the execution starts.
+func WithStarted(ctx context.Context, started func(startDate time.Time)) context.Context {
+	return context.WithValue(ctx, startedKey{}, started)
+}
+
+func (e *Engine) Execute(ctx context.Context, coj *compiler.CtxObj, w *compiler.Workflow, input *bytes.Buffer) (res Result, err error) {
+	if coj == nil {
+		coj = new(compiler.CtxObj)
+	}
+
+	if input == nil || strings.TrimSpace(input.String()) == "" {
+		input = bytes.NewBuffer(EmptyJSON)
+	}
+
+	var in interface{}
+	if err := json.Unmarshal(input.Bytes(), &in); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	workflow, err := e.newWorkflow(w)
+	if err != nil {
+		return Result{}, err
+	}
+	// the caller can name the execution by $$.Execution.Id, such as the ARN given by StartExecution
+	if id, ok := coj.GetByString(ctxExecutionID); ok {
+		s, ok := id.(string)
+		if !ok || s == "" {
+			return Result{}, fmt.Errorf("invalid $$.Execution.Id: %v", id)
+		}
+		workflow.ID = s
+	}
+	workflow.store = e.store
+	workflow.startDate = e.now()
+	if e.history {
+		workflow.history = newHistory(e.now)
+		e.histories.set(workflow.ID, workflow.history)
+	}
+
+	ctx = workflow.history.withHistoryCursor(ctx)
+	workflow.history.addExecutionStarted(ctx, in)
+
+	res = Result{
+		ID:        workflow.ID,
+		StartDate: workflow.startDate,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			res = e.failed(res, NewStatesError(StatesErrorRuntime, fmt.Errorf("panic: %v", r)))
+		}
+		workflow.history.addExecutionResult(ctx, res)
+		e.histories.finish(workflow.ID)
+		e.release(workflow, res)
+	}()
+
+	if started, ok := ctx.Value(startedKey{}).(func(time.Time)); ok {
+		started(workflow.startDate)
+		// the executions started in this one, such as by a task, are not reported
+		ctx = context.WithValue(ctx, startedKey{}, nil)
+	}
+
+	// $$.Execution.StartTime is the same as the StartDate of the result
+	coj, err = workflow.withExecution(coj, in, res.StartDate)
+	if err != nil {
+		return e.failed(res, NewStatesError(StatesErrorRuntime, err)), nil
+	}
+
+	out, execErr := workflow.Exec(ctx, coj, in)
+	return e.finish(ctx, res, out, execErr), nil
+}
+
+// Resume continues an unfinished execution from its checkpoint in the execution store.
+// w must be the workflow that the execution was started with.
+func (e *Engine) Resume(ctx context.Context, w *compiler.Workflow, id string) (res Result, err error) {
+	if e.store == nil {
+		return Result{}, ErrNoExecutionStore
+	}
+
+	cp, err := e.store.Load(ctx, id)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// the events before the restart are lost, unless the execution is resumed by the same engine
+	history, ok := e.histories.get(cp.ID)
+	if !ok && e.history {
+		history = newHistory(e.now)
+		e.histories.set(cp.ID, history)
+	}
+
+	workflow := &Workflow{Workflow: w, ID: cp.ID, engine: e, store: e.store, startDate: cp.StartDate, history: history}
+	branch, err := workflow.nextBranchFromString(cp.StateName)
+	if err != nil {
+		return Result{}, fmt.Errorf("the checkpoint does not match the workflow: %w", err)
+	}
+
+	var input, c interface{}
+	if err := json.Unmarshal(cp.Input, &input); err != nil {
+		return Result{}, fmt.Errorf("broken checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(cp.Context, &c); err != nil {
+		return Result{}, fmt.Errorf("broken checkpoint: %w", err)
+	}
+	coj, err := new(compiler.CtxObj).SetAll(c)
+	if err != nil {
+		return Result{}, fmt.Errorf("broken checkpoint: %w", err)
+	}
+	var vars *compiler.Variables
+	vars, err = vars.Assign(cp.Variables)
+	if err != nil {
+		return Result{}, fmt.Errorf("broken checkpoint: %w", err)
+	}
+
+	ctx = history.withHistoryCursor(ctx)
+
+	res = Result{
+		ID:        workflow.ID,
+		StartDate: workflow.startDate,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			res = e.failed(res, NewStatesError(StatesErrorRuntime, fmt.Errorf("panic: %v", r)))
+		}
+		history.addExecutionResult(ctx, res)
+		e.histories.finish(workflow.ID)
+		e.release(workflow, res)
+	}()
+
+	out, execErr := workflow.execFrom(ctx, coj, vars, branch, input, stateProgress{enteredTime: cp.EnteredTime, retryCount: cp.RetryCount, retryAttempts: cp.RetryAttempts})
+	return e.finish(ctx, res, out, execErr), nil
+}
+
+// History returns the history of an execution run by the engine, which is recorded only with WithHistory.
+func (e *Engine) History(id string) (*History, error) {
+	if !e.history {
+		return nil, ErrNoHistory
+	}
+
+	h, ok := e.histories.get(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	return h, nil
+}
+
+// Unfinished returns the checkpoints of the executions that can be resumed.
+func (e *Engine) Unfinished(ctx context.Context) ([]Checkpoint, error) {
+	if e.store == nil {
+		return nil, ErrNoExecutionStore
+	}
+	return e.store.List(ctx)
+}
+
+// release deletes the checkpoint of a finished execution.
+// The checkpoint of an aborted execution is kept, so that it can be resumed.
+func (e *Engine) release(w *Workflow, res Result) {
+	if w.store == nil || res.Status == ExecutionStatusAborted {
+		return
+	}
+	if err := w.store.Delete(context.Background(), w.ID); err != nil {
+		e.logger.WithField("id", w.ID).Warnln("failed to delete the checkpoint:", err)
+	}
+}
+
+// finish returns the result of an execution that ended with the output or the error.
+func (e *Engine) finish(ctx context.Context, res Result, out interface{}, execErr error) Result {
+	if execErr != nil && !errors.Is(execErr, ErrStateMachineTerminated) {
+		if ctx.Err() != nil {
+			res.Status = ExecutionStatusAborted
+		} else if errors.Is(execErr, context.DeadlineExceeded) {
+			res.Status = ExecutionStatusTimedOut
+		}
+		return e.failed(res, execErr)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return e.failed(res, NewStatesError(StatesErrorRuntime, err))
+	}
+
+	res.Status = ExecutionStatusSucceeded
+	res.Output = b
+	res.StopDate = e.now()
+	return res
+}
+
+func (e *Engine) failed(res Result, err error) Result {
+	if res.Status == "" {
+		res.Status = ExecutionStatusFailed
+	}
+	res.Error = StatesErrorRuntime
+	res.Cause = err.Error()
+
+	var serr StatesError
+	if errors.As(err, &serr) {
+		if serr.Name != "" || errors.Is(serr, ErrStateMachineFailed) {
+			res.Error = serr.Name
+		}
+		res.Cause = serr.Cause
+	}
+
+	res.StopDate = e.now()
+	res.err = err
+	return res
+}
@@ -0,0 +1,706 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	ifn "github.com/w-haibara/kakemoti/intrinsic/fn"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func TestEngine_Execute(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	flaky := 0
+	tasks := task.FnMap{
+		"flaky": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			flaky++
+			if flaky == 1 {
+				return nil, "Flaky", nil
+			}
+			return in, "", nil
+		},
+		"echo": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			return fn.Obj{"path": path, "in": in}, "", nil
+		},
+		"error": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			return nil, path, nil
+		},
+		"block": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			<-ctx.Done()
+			return nil, "", ctx.Err()
+		},
+		"beat": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			for i := 0; i < 6; i++ {
+				fn.SendHeartbeat(ctx)
+				time.Sleep(300 * time.Millisecond)
+			}
+			return fn.Obj{"path": path}, "", nil
+		},
+	}
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithClock(func() time.Time { return now }),
+		WithTaskRegistry(tasks),
+	)
+
+	tests := []struct {
+		name      string
+		asl       string
+		input     string
+		want      Result
+		wantError error
+	}{
+		{
+			"succeeded",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "echo:aaa", "End": true}}}`,
+			`{"x": 1}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"in":{"x":1},"path":"aaa"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"States.ALL does not catch States.Runtime",
+			`{"StartAt": "M", "States": {
+				"M": {"Type": "Map", "ItemsPath": "$.missing", "Iterator": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}},
+					"Retry": [{"ErrorEquals": ["States.ALL"]}], "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "S"}], "End": true},
+				"S": {"Type": "Succeed"}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorRuntime, Cause: "invalid length of path.Get(input) result (path=[$.missing])", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"failed",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "error:Custom.Error", "End": true}}}`,
+			``,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "Custom.Error", Cause: "fn() failed: Custom.Error", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"unknown resource",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "script:xxx", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorTaskFailed, Cause: "invalid resouce type: script", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"timed out",
+			`{"StartAt": "W", "TimeoutSeconds": 1, "States": {"W": {"Type": "Wait", "Seconds": 3, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusTimedOut, Error: StatesErrorTimeout, Cause: context.DeadlineExceeded.Error(), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"task timeout",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSeconds": 1, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorTimeout, Cause: ErrTaskTimedOut.Error(), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"task timeout(path)",
+			`{"StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSecondsPath": "$.timeout", "Catch": [{"ErrorEquals": ["States.Timeout"], "Next": "P"}], "End": true},
+				"P": {"Type": "Pass", "End": true}}}`,
+			`{"timeout": 1}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"Cause":"task timed out","Error":"States.Timeout"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"heartbeat",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "beat:aaa", "HeartbeatSeconds": 1, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"path":"aaa"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"heartbeat timeout",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSeconds": 5, "HeartbeatSeconds": 1, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorHeartbeatTimeout, Cause: ErrTaskHeartbeatTimedOut.Error(), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"heartbeat timeout(path)",
+			`{"StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "block:aaa", "HeartbeatSecondsPath": "$.heartbeat", "Catch": [{"ErrorEquals": ["States.HeartbeatTimeout"], "Next": "P"}], "End": true},
+				"P": {"Type": "Pass", "End": true}}}`,
+			`{"heartbeat": 1}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"Cause":"task heartbeat timed out","Error":"States.HeartbeatTimeout"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"context object",
+			`{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {
+				"execution.$": "$$.Execution", "stateMachine.$": "$$.StateMachine", "state.$": "$$.State"}, "End": true}}}`,
+			`{"x": 1}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"execution":{"Id":"id","Input":{"x":1},"Name":"id","StartTime":"2022-01-02T03:04:05.000Z"},` +
+				`"state":{"EnteredTime":"2022-01-02T03:04:05.000Z","Name":"P","RetryCount":0},"stateMachine":{"Id":"id","Name":"id"}}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"context object(retry)",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "flaky:aaa", "Parameters": {"retry.$": "$$.State.RetryCount"},
+				"Retry": [{"ErrorEquals": ["Flaky"], "IntervalSeconds": 1}], "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"retry":1}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"context object(nested map)",
+			`{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemsPath": "$.outer", "End": true, "Iterator": {"StartAt": "N", "States": {
+				"N": {"Type": "Map", "ItemsPath": "$.inner", "End": true, "Iterator": {"StartAt": "P", "States": {
+					"P": {"Type": "Pass", "Parameters": {"index.$": "$$.Map.Item.Index", "execution.$": "$$.Execution.Id"}, "End": true}}}}}}}}}`,
+			`{"outer": [{"inner": [1, 2]}]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`[[{"execution":"id","index":0},{"execution":"id","index":1}]]`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"intrinsic(array)",
+			`{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {
+				"chunks.$": "States.ArrayPartition($.items, 2)", "length.$": "States.ArrayLength($.items)"}, "End": true}}}`,
+			`{"items": [1, 2, 3]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"chunks":[[1,2],[3]],"length":3}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"intrinsic(array failure)",
+			`{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {"item.$": "States.ArrayGetItem($.items, 3)"}, "End": true}}}`,
+			`{"items": [1, 2, 3]}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorIntrinsicFailure,
+				Cause: "fn() failed: invalid arguments: the index is out of range: 3: intrinsic function failed", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"fail(path)",
+			`{"StartAt": "F", "States": {"F": {"Type": "Fail", "ErrorPath": "$.error", "CausePath": "$.cause"}}}`,
+			`{"error": "CustomError", "cause": "custom cause"}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "CustomError", Cause: "custom cause", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"fail(intrinsic)",
+			`{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "CustomError", "CausePath": "States.Format('{} failed', $.name)"}}}`,
+			`{"name": "job"}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "CustomError", Cause: "job failed", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"fail(caught in parallel)",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Parallel", "Branches": [{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "CustomError"}}}],
+					"Catch": [{"ErrorEquals": ["OtherError"], "Next": "X"}], "End": true},
+				"X": {"Type": "Pass", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "CustomError", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"parallel(fail fast)",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Parallel", "End": true, "Branches": [
+					{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": 60, "End": true}}},
+					{"StartAt": "B", "States": {"B": {"Type": "Task", "Resource": "block:x", "End": true}}},
+					{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "error:Custom.Error", "End": true}}}]}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "Custom.Error", Cause: "fn() failed: Custom.Error", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"parallel(caught by States.BranchFailed)",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Parallel", "Branches": [{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "CustomError", "Cause": "failed"}}}],
+					"Catch": [{"ErrorEquals": ["States.BranchFailed"], "Next": "X"}], "End": true},
+				"X": {"Type": "Pass", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"Cause":"failed","Error":"CustomError"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"parallel(caught by the original error)",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Parallel", "Branches": [
+					{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": 60, "End": true}}},
+					{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "CustomError", "Cause": "failed"}}}],
+					"Catch": [{"ErrorEquals": ["CustomError"], "Next": "X"}], "End": true},
+				"X": {"Type": "Pass", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"Cause":"failed","Error":"CustomError"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata",
+			`{"QueryLanguage": "JSONata", "StartAt": "P", "States": {
+				"P": {"Type": "Pass", "Output": {"n": "{% $states.input.n * 2 %}", "name": "{% $states.context.State.Name %}"}, "Next": "T"},
+				"T": {"Type": "Task", "Resource": "echo:aaa", "Arguments": {"n": "{% $states.input.n %}"}, "Output": "{% $states.result.in %}", "Next": "C"},
+				"C": {"Type": "Choice", "Choices": [{"Condition": "{% $states.input.n > 5 %}", "Next": "X"}], "Default": "S"},
+				"X": {"Type": "Fail", "Error": "TooLarge"},
+				"S": {"Type": "Succeed"}}}`,
+			`{"n": 2}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"n":4}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(per state)",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Pass", "Parameters": {"x.$": "$.x"}, "Next": "J"},
+				"J": {"Type": "Pass", "QueryLanguage": "JSONata", "Output": "{% $sum($states.input.x) %}", "End": true}}}`,
+			`{"x": [1, 2, 3]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`6`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(catch)",
+			`{"QueryLanguage": "JSONata", "StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "error:Custom.Error", "Catch": [{"ErrorEquals": ["States.ALL"], "Output": "{% $states.errorOutput.Error %}", "Next": "P"}], "End": true},
+				"P": {"Type": "Pass", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`"Custom.Error"`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(undefined output)",
+			`{"QueryLanguage": "JSONata", "StartAt": "P", "States": {"P": {"Type": "Pass", "Output": "{% $states.input.missing %}", "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorQueryEvaluationError,
+				Cause: "query evaluation failed: JSONata expression returned no value: $states.input.missing", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(fail)",
+			`{"QueryLanguage": "JSONata", "StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "{% $states.input.error %}", "Cause": "{% $states.input.name & ' failed' %}"}}}`,
+			`{"error": "CustomError", "name": "job"}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: "CustomError", Cause: "job failed", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(map and parallel)",
+			`{"QueryLanguage": "JSONata", "StartAt": "M", "States": {
+				"M": {"Type": "Map", "Items": "{% $states.input.items %}", "Next": "P", "Iterator": {"StartAt": "I", "States": {
+					"I": {"Type": "Pass", "Output": "{% $states.input * $states.context.Map.Item.Index %}", "End": true}}}},
+				"P": {"Type": "Parallel", "End": true, "Output": "{% $states.result[0] %}", "Branches": [{"StartAt": "B", "States": {
+					"B": {"Type": "Pass", "Output": "{% $sum($states.input) %}", "End": true}}}]}}}`,
+			`{"items": [3, 4, 5]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`14`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(timeout seconds)",
+			`{"QueryLanguage": "JSONata", "StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSeconds": "{% $states.input.timeout %}",
+					"Catch": [{"ErrorEquals": ["States.Timeout"], "Next": "P"}], "End": true},
+				"P": {"Type": "Pass", "End": true}}}`,
+			`{"timeout": 1}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"Cause":"task timed out","Error":"States.Timeout"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(heartbeat seconds)",
+			`{"QueryLanguage": "JSONata", "StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSeconds": 5, "HeartbeatSeconds": "{% $states.input.heartbeat %}", "End": true}}}`,
+			`{"heartbeat": 1}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorHeartbeatTimeout, Cause: ErrTaskHeartbeatTimedOut.Error(), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(invalid heartbeat seconds)",
+			`{"QueryLanguage": "JSONata", "StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "block:aaa", "TimeoutSeconds": 5, "HeartbeatSeconds": "{% $states.input.heartbeat %}", "End": true}}}`,
+			`{"heartbeat": 5}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorQueryEvaluationError,
+				Cause: "query evaluation failed: HeartbeatSeconds must be smaller than TimeoutSeconds", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(wait)",
+			`{"QueryLanguage": "JSONata", "StartAt": "W", "States": {
+				"W": {"Type": "Wait", "Seconds": "{% $states.input.seconds %}", "Next": "X"},
+				"X": {"Type": "Wait", "Timestamp": "{% $states.input.timestamp %}", "End": true}}}`,
+			`{"seconds": 0, "timestamp": "2000-01-01T00:00:00Z"}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"seconds":0,"timestamp":"2000-01-01T00:00:00Z"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"jsonata(invalid wait seconds)",
+			`{"QueryLanguage": "JSONata", "StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": "{% $states.input.seconds %}", "End": true}}}`,
+			`{"seconds": "1"}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorQueryEvaluationError,
+				Cause: "invalid Seconds: query evaluation failed: must be an integer not less than 0: 1", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables",
+			`{"StartAt": "A", "States": {
+				"A": {"Type": "Pass", "Result": {"x": 1, "name": "job"}, "Assign": {"x.$": "$.x", "name.$": "$.name", "static": [1]}, "Next": "B"},
+				"B": {"Type": "Pass", "Assign": {"x.$": "States.MathAdd($x, 1)", "old.$": "$x"}, "Next": "C"},
+				"C": {"Type": "Choice", "Choices": [{"Variable": "$x", "NumericEquals": 2, "Next": "D"}]},
+				"D": {"Type": "Pass", "Parameters": {"x.$": "$x", "old.$": "$old", "message.$": "States.Format('{} {}', $name, $static[0])"}, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"message":"job 1","old":1,"x":2}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(jsonata)",
+			`{"QueryLanguage": "JSONata", "StartAt": "A", "States": {
+				"A": {"Type": "Task", "Resource": "echo:aaa", "Assign": {"path": "{% $states.result.path %}", "n": "{% $states.input.n %}"}, "Output": "{% $exists($path) %}", "Next": "C"},
+				"C": {"Type": "Choice", "Choices": [{"Condition": "{% $n > 1 %}", "Next": "D"}]},
+				"D": {"Type": "Pass", "Output": {"path": "{% $path %}", "n": "{% $n %}", "input": "{% $states.input %}"}, "End": true}}}`,
+			`{"n": 2}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"input":false,"n":2,"path":"aaa"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(choice rule)",
+			`{"StartAt": "C", "States": {
+				"C": {"Type": "Choice", "Assign": {"level": "default"}, "Default": "D", "Choices": [
+					{"Variable": "$.n", "NumericGreaterThan": 10, "Assign": {"level": "high", "n.$": "$.n"}, "Next": "D"},
+					{"Variable": "$.n", "NumericGreaterThan": 5, "Next": "D"}]},
+				"D": {"Type": "Pass", "Parameters": {"level.$": "$level"}, "End": true}}}`,
+			`{"n": 11}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"level":"high"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(choice rule without Assign)",
+			`{"StartAt": "C", "States": {
+				"C": {"Type": "Choice", "Assign": {"level": "default"}, "Choices": [
+					{"Variable": "$.n", "NumericGreaterThan": 10, "Assign": {"level": "high"}, "Next": "D"},
+					{"Variable": "$.n", "NumericGreaterThan": 5, "Next": "D"}]},
+				"D": {"Type": "Pass", "Parameters": {"level.$": "$level"}, "End": true}}}`,
+			`{"n": 6}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"level":"default"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(choice rule jsonata)",
+			`{"QueryLanguage": "JSONata", "StartAt": "C", "States": {
+				"C": {"Type": "Choice", "Output": "{% 'state' %}", "Choices": [
+					{"Condition": "{% $states.input.n > 10 %}", "Assign": {"doubled": "{% $states.input.n * 2 %}"}, "Output": "{% 'rule' %}", "Next": "D"}]},
+				"D": {"Type": "Pass", "Output": {"doubled": "{% $doubled %}", "input": "{% $states.input %}"}, "End": true}}}`,
+			`{"n": 11}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"doubled":22,"input":"rule"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(catch)",
+			`{"StartAt": "T", "States": {
+				"T": {"Type": "Task", "Resource": "error:Custom.Error", "Assign": {"x": 1},
+					"Catch": [{"ErrorEquals": ["States.ALL"], "Assign": {"error.$": "$.Error"}, "Next": "P"}], "End": true},
+				"P": {"Type": "Pass", "Parameters": {"error.$": "$error"}, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded, Output: []byte(`{"error":"Custom.Error"}`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(scope)",
+			`{"StartAt": "A", "States": {
+				"A": {"Type": "Pass", "Assign": {"outer": "o"}, "Next": "M"},
+				"M": {"Type": "Map", "ItemsPath": "$.items", "Next": "P", "Iterator": {"StartAt": "I", "States": {
+					"I": {"Type": "Pass", "Assign": {"inner.$": "$"}, "Next": "J"},
+					"J": {"Type": "Pass", "Parameters": {"outer.$": "$outer", "inner.$": "$inner"}, "End": true}}}},
+				"P": {"Type": "Parallel", "End": true, "Branches": [{"StartAt": "B", "States": {
+					"B": {"Type": "Pass", "Assign": {"outer": "x"}, "End": true}}}]}}}`,
+			`{"items": [1, 2]}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorRuntime,
+				Cause: "the variable of an outer scope is read-only: outer", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"variables(undefined)",
+			`{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {"x.$": "$missing"}, "End": true}}}`,
+			`{}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorParameterPathFailure,
+				Cause: "FilterByParameters(state, input) failed: [x.$]=[$missing]: undefined variable: missing", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"item selector",
+			`{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemsPath": "$.items", "End": true,
+				"ItemSelector": {"index.$": "$$.Map.Item.Index", "value.$": "$$.Map.Item.Value", "name.$": "$.name"},
+				"ItemProcessor": {"ProcessorConfig": {"Mode": "INLINE"}, "StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}}}}`,
+			`{"name": "n", "items": ["a", "b"]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded,
+				Output: []byte(`[{"index":0,"name":"n","value":"a"},{"index":1,"name":"n","value":"b"}]`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"item selector(parameters)",
+			`{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemsPath": "$.items", "End": true,
+				"Parameters": {"index.$": "$$.Map.Item.Index", "value.$": "$$.Map.Item.Value", "name.$": "$.name"},
+				"Iterator": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}}}}`,
+			`{"name": "n", "items": ["a", "b"]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded,
+				Output: []byte(`[{"index":0,"name":"n","value":"a"},{"index":1,"name":"n","value":"b"}]`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"item selector(jsonata)",
+			`{"QueryLanguage": "JSONata", "StartAt": "M", "States": {"M": {"Type": "Map", "Items": "{% $states.input.items %}", "End": true,
+				"ItemSelector": {"index": "{% $states.context.Map.Item.Index %}", "value": "{% $states.context.Map.Item.Value %}", "name": "{% $states.input.name %}"},
+				"ItemProcessor": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}}}}`,
+			`{"name": "n", "items": ["a", "b"]}`,
+			Result{ID: "id", Status: ExecutionStatusSucceeded,
+				Output: []byte(`[{"index":0,"name":"n","value":"a"},{"index":1,"name":"n","value":"b"}]`), StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"item processor(distributed)",
+			`{"StartAt": "A", "States": {
+				"A": {"Type": "Pass", "Assign": {"outer": "o"}, "Next": "M"},
+				"M": {"Type": "Map", "ItemsPath": "$.items", "End": true,
+					"ItemProcessor": {"ProcessorConfig": {"Mode": "DISTRIBUTED", "ExecutionType": "STANDARD"}, "StartAt": "P", "States": {
+						"P": {"Type": "Pass", "Parameters": {"outer.$": "$outer"}, "End": true}}}}}}`,
+			`{"items": [1]}`,
+			Result{ID: "id", Status: ExecutionStatusFailed, Error: StatesErrorParameterPathFailure,
+				Cause: "FilterByParameters(state, input) failed: [outer.$]=[$outer]: undefined variable: outer", StartDate: now, StopDate: now},
+			nil,
+		},
+		{
+			"invalid input",
+			`{"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}`,
+			`{`,
+			Result{},
+			ErrInvalidInput,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			w, err := compiler.Compile(ctx, bytes.NewBufferString(tt.asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			got, err := engine.Execute(ctx, nil, w, bytes.NewBufferString(tt.input))
+			if !errors.Is(err, tt.wantError) {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.wantError)
+			}
+
+			if d := cmp.Diff(got, tt.want, cmp.AllowUnexported(Result{}), cmp.Comparer(func(e1, e2 error) bool { return true })); d != "" {
+				t.Errorf("Execute() failed: \n%s", d)
+			}
+		})
+	}
+}
+
+func TestEngine_Execute_statesError(t *testing.T) {
+	ctx := context.Background()
+	asl := `{"StartAt": "Choice State", "States": {"Choice State": {"Type": "Choice", "Choices": [{"Variable": "$.bool", "BooleanEquals": true, "Next": "End"}]}, "End": {"Type": "Succeed"}}}`
+	w, err := compiler.Compile(ctx, bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	res, err := NewEngine().Execute(ctx, nil, w, bytes.NewBufferString(`{"bool": false}`))
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+
+	var serr StatesError
+	if !errors.As(res.Err(), &serr) {
+		t.Fatalf("errors.As(%#v) failed", res.Err())
+	}
+	if serr.Name != StatesErrorNoChoiceMatched || serr.StateName != "Choice State" {
+		t.Errorf("got = %#v", serr)
+	}
+
+	var perr *StatesError
+	if !errors.As(res.Err(), &perr) || perr.Name != StatesErrorNoChoiceMatched {
+		t.Errorf("errors.As(*StatesError) failed: %#v", perr)
+	}
+}
+
+func TestEngine_SendTask(t *testing.T) {
+	tokens := make(chan string, 1)
+	tasks := task.FnMap{
+		"notify": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			tokens <- in["token"].(string)
+			return fn.Obj{}, "", nil
+		},
+	}
+	engine := NewEngine(WithTaskRegistry(tasks))
+
+	asl := `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "notify:aaa.waitForTaskToken",
+		"Parameters": {"token.$": "$$.Task.Token"}, "HeartbeatSeconds": 1, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	tests := []struct {
+		name       string
+		send       func(token string) error
+		wantStatus ExecutionStatus
+		wantOutput string
+		wantError  string
+		wantCause  string
+	}{
+		{
+			"success",
+			func(token string) error {
+				return engine.SendTaskSuccess(token, []byte(`{"approved": true}`))
+			},
+			ExecutionStatusSucceeded, `{"approved":true}`, "", "",
+		},
+		{
+			"failure",
+			func(token string) error {
+				return engine.SendTaskFailure(token, "Rejected", "not approved")
+			},
+			ExecutionStatusFailed, "", "Rejected", "not approved",
+		},
+		{
+			"heartbeat",
+			func(token string) error {
+				for i := 0; i < 3; i++ {
+					time.Sleep(500 * time.Millisecond)
+					if err := engine.SendTaskHeartbeat(token); err != nil {
+						return err
+					}
+				}
+				return engine.SendTaskSuccess(token, []byte(`"ok"`))
+			},
+			ExecutionStatusSucceeded, `"ok"`, "", "",
+		},
+		{
+			"heartbeat timeout",
+			func(token string) error {
+				return nil
+			},
+			ExecutionStatusFailed, "", StatesErrorHeartbeatTimeout, ErrTaskHeartbeatTimedOut.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make(chan Result, 1)
+			go func() {
+				res, err := engine.Execute(context.Background(), nil, w, nil)
+				if err != nil {
+					t.Error("Execute() failed:", err)
+				}
+				results <- res
+			}()
+
+			token := <-tokens
+			if err := tt.send(token); err != nil {
+				t.Fatal("send failed:", err)
+			}
+
+			res := <-results
+			if res.Status != tt.wantStatus || string(res.Output) != tt.wantOutput || res.Error != tt.wantError || res.Cause != tt.wantCause {
+				t.Errorf("Execute() = %#v", res)
+			}
+
+			if err := engine.SendTaskSuccess(token, []byte(`{}`)); !errors.Is(err, ErrTaskTokenNotFound) {
+				t.Errorf("SendTaskSuccess() error = %v, want %v", err, ErrTaskTokenNotFound)
+			}
+		})
+	}
+}
+
+// The task returns its token by itself, before the engine starts waiting for it.
+func TestEngine_SendTask_heartbeatThenSuccess(t *testing.T) {
+	var engine *Engine
+	tasks := task.FnMap{
+		"notify": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			token := in["token"].(string)
+			if err := engine.SendTaskHeartbeat(token); err != nil {
+				return nil, "", err
+			}
+			if err := engine.SendTaskSuccess(token, []byte(`"ok"`)); err != nil {
+				return nil, "", err
+			}
+			return fn.Obj{}, "", nil
+		},
+	}
+	engine = NewEngine(WithTaskRegistry(tasks))
+
+	asl := `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "notify:aaa.waitForTaskToken",
+		"Parameters": {"token.$": "$$.Task.Token"}, "HeartbeatSeconds": 5, "TimeoutSeconds": 8, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	results := make(chan Result, 1)
+	go func() {
+		res, err := engine.Execute(context.Background(), nil, w, nil)
+		if err != nil {
+			t.Error("Execute() failed:", err)
+		}
+		results <- res
+	}()
+
+	select {
+	case res := <-results:
+		if res.Status != ExecutionStatusSucceeded || string(res.Output) != `"ok"` {
+			t.Errorf("Execute() = %#v", res)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the execution is blocked")
+	}
+}
+
+// The variables are visible to a task waiting for its token, and are kept after it returns.
+func TestEngine_SendTask_variables(t *testing.T) {
+	var engine *Engine
+	tasks := task.FnMap{
+		"notify": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			if err := engine.SendTaskSuccess(in["token"].(string), []byte(fmt.Sprintf(`{"x": %v}`, in["x"]))); err != nil {
+				return nil, "", err
+			}
+			return fn.Obj{}, "", nil
+		},
+	}
+	engine = NewEngine(WithTaskRegistry(tasks))
+
+	asl := `{"StartAt": "A", "States": {
+		"A": {"Type": "Pass", "Assign": {"x": 42, "y": "kept"}, "Next": "T"},
+		"T": {"Type": "Task", "Resource": "notify:aaa.waitForTaskToken",
+			"Parameters": {"token.$": "$$.Task.Token", "x.$": "$x"}, "Assign": {"z.$": "$.x"}, "Next": "P"},
+		"P": {"Type": "Pass", "Parameters": {"x.$": "$x", "y.$": "$y", "z.$": "$z"}, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	res, err := engine.Execute(context.Background(), nil, w, nil)
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+	if res.Status != ExecutionStatusSucceeded || string(res.Output) != `{"x":42,"y":"kept","z":42}` {
+		t.Errorf("Execute() = %#v", res)
+	}
+}
+
+func TestEngine_Execute_intrinsicContext(t *testing.T) {
+	ctx := ifn.WithUUIDGenerator(context.Background(), func() (string, error) { return "uuid", nil })
+
+	asl := `{"StartAt": "P", "States": {"P": {"Type": "Pass", "Parameters": {
+		"id.$": "States.UUID()", "random.$": "States.MathRandom(0, 1000)", "seeded.$": "States.MathRandom(0, 1000, 7)"}, "End": true}}}`
+	w, err := compiler.Compile(ctx, bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		ctx := ifn.WithRandSource(ctx, rand.NewSource(1))
+		res, err := NewEngine().Execute(ctx, nil, w, nil)
+		if err != nil || res.Status != ExecutionStatusSucceeded {
+			t.Fatalf("Execute() = %#v, %v", res, err)
+		}
+		outputs = append(outputs, string(res.Output))
+	}
+
+	if outputs[0] != outputs[1] || !strings.Contains(outputs[0], `"id":"uuid"`) {
+		t.Errorf("Execute() is not deterministic: %s, %s", outputs[0], outputs[1])
+	}
+}
@@ -2,11 +2,57 @@ package worker
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
-func (w Workflow) evalFail(ctx context.Context, state compiler.FailState, input interface{}) (interface{}, statesError) {
-	return input, NewStatesError("", fmt.Errorf("Fail: %w", ErrStateMachineTerminated))
+func (w Workflow) evalFail(ctx context.Context, coj *compiler.CtxObj, state compiler.FailState, input interface{}) (interface{}, StatesError) {
+	name := state.Error
+	if state.ErrorExpr != nil {
+		v, err := state.ErrorExpr.EvalString(ctx, compiler.NewJSONataVars(coj, input))
+		if err != nil {
+			return nil, newResolveError(err)
+		}
+		name = v
+	}
+	if state.ErrorPath != nil {
+		v, err := state.ErrorPath.ResolveString(ctx, coj, input)
+		if err != nil {
+			return nil, newResolveError(err)
+		}
+		name = v
+	}
+
+	cause := state.Cause
+	if state.CauseExpr != nil {
+		v, err := state.CauseExpr.EvalString(ctx, compiler.NewJSONataVars(coj, input))
+		if err != nil {
+			return nil, newResolveError(err)
+		}
+		cause = v
+	}
+	if state.CausePath != nil {
+		v, err := state.CausePath.ResolveString(ctx, coj, input)
+		if err != nil {
+			return nil, newResolveError(err)
+		}
+		cause = v
+	}
+
+	return nil, StatesError{
+		Name:  name,
+		Cause: cause,
+		Err:   ErrStateMachineFailed,
+	}
+}
+
+func newResolveError(err error) StatesError {
+	if errors.Is(err, compiler.ErrIntrinsicFunctionFailed) {
+		return NewStatesError(StatesErrorIntrinsicFailure, err)
+	}
+	if errors.Is(err, compiler.ErrQueryEvaluationFailed) {
+		return NewStatesError(StatesErrorQueryEvaluationError, err)
+	}
+	return NewStatesError(StatesErrorRuntime, err)
 }
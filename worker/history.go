@@ -0,0 +1,441 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+// ErrNoHistory is returned by Engine.History if the engine is not made with WithHistory.
+var ErrNoHistory = errors.New("the histories of the executions are not recorded")
+
+// HistoryEventType is the type of an event in the history of an execution.
+// ref: https://docs.aws.amazon.com/step-functions/latest/apireference/API_HistoryEvent.html
+type HistoryEventType string
+
+const (
+	HistoryEventExecutionStarted   HistoryEventType = "ExecutionStarted"
+	HistoryEventExecutionSucceeded HistoryEventType = "ExecutionSucceeded"
+	HistoryEventExecutionFailed    HistoryEventType = "ExecutionFailed"
+	HistoryEventExecutionAborted   HistoryEventType = "ExecutionAborted"
+	HistoryEventExecutionTimedOut  HistoryEventType = "ExecutionTimedOut"
+
+	HistoryEventTaskScheduled HistoryEventType = "TaskScheduled"
+	HistoryEventTaskStarted   HistoryEventType = "TaskStarted"
+	HistoryEventTaskSucceeded HistoryEventType = "TaskSucceeded"
+	HistoryEventTaskFailed    HistoryEventType = "TaskFailed"
+	HistoryEventTaskTimedOut  HistoryEventType = "TaskTimedOut"
+
+	HistoryEventMapStateStarted       HistoryEventType = "MapStateStarted"
+	HistoryEventMapStateSucceeded     HistoryEventType = "MapStateSucceeded"
+	HistoryEventMapStateFailed        HistoryEventType = "MapStateFailed"
+	HistoryEventMapStateAborted       HistoryEventType = "MapStateAborted"
+	HistoryEventMapIterationStarted   HistoryEventType = "MapIterationStarted"
+	HistoryEventMapIterationSucceeded HistoryEventType = "MapIterationSucceeded"
+	HistoryEventMapIterationFailed    HistoryEventType = "MapIterationFailed"
+	HistoryEventMapIterationAborted   HistoryEventType = "MapIterationAborted"
+
+	HistoryEventParallelStateStarted   HistoryEventType = "ParallelStateStarted"
+	HistoryEventParallelStateSucceeded HistoryEventType = "ParallelStateSucceeded"
+	HistoryEventParallelStateFailed    HistoryEventType = "ParallelStateFailed"
+	HistoryEventParallelStateAborted   HistoryEventType = "ParallelStateAborted"
+)
+
+// StateEnteredEventType returns the type of the event recorded when a state of the type is entered, such as TaskStateEntered.
+func StateEnteredEventType(stateType string) HistoryEventType {
+	return HistoryEventType(stateType + "StateEntered")
+}
+
+// StateExitedEventType returns the type of the event recorded when a state of the type is exited, such as TaskStateExited.
+func StateExitedEventType(stateType string) HistoryEventType {
+	return HistoryEventType(stateType + "StateExited")
+}
+
+// HistoryEvent is an event in the history of an execution, in the same shape as the ones returned by GetExecutionHistory.
+// Only the details of its type are set.
+type HistoryEvent struct {
+	Timestamp       Timestamp        `json:"timestamp"`
+	Type            HistoryEventType `json:"type"`
+	ID              int64            `json:"id"`
+	PreviousEventID int64            `json:"previousEventId"`
+
+	ExecutionStartedEventDetails   *ExecutionStartedEventDetails   `json:"executionStartedEventDetails,omitempty"`
+	ExecutionSucceededEventDetails *ExecutionSucceededEventDetails `json:"executionSucceededEventDetails,omitempty"`
+	ExecutionFailedEventDetails    *ErrorEventDetails              `json:"executionFailedEventDetails,omitempty"`
+	ExecutionAbortedEventDetails   *ErrorEventDetails              `json:"executionAbortedEventDetails,omitempty"`
+	ExecutionTimedOutEventDetails  *ErrorEventDetails              `json:"executionTimedOutEventDetails,omitempty"`
+
+	StateEnteredEventDetails *StateEnteredEventDetails `json:"stateEnteredEventDetails,omitempty"`
+	StateExitedEventDetails  *StateExitedEventDetails  `json:"stateExitedEventDetails,omitempty"`
+
+	TaskScheduledEventDetails *TaskScheduledEventDetails `json:"taskScheduledEventDetails,omitempty"`
+	TaskStartedEventDetails   *TaskEventDetails          `json:"taskStartedEventDetails,omitempty"`
+	TaskSucceededEventDetails *TaskEventDetails          `json:"taskSucceededEventDetails,omitempty"`
+	TaskFailedEventDetails    *TaskEventDetails          `json:"taskFailedEventDetails,omitempty"`
+	TaskTimedOutEventDetails  *TaskEventDetails          `json:"taskTimedOutEventDetails,omitempty"`
+
+	MapStateStartedEventDetails       *MapStateStartedEventDetails `json:"mapStateStartedEventDetails,omitempty"`
+	MapIterationStartedEventDetails   *MapIterationEventDetails    `json:"mapIterationStartedEventDetails,omitempty"`
+	MapIterationSucceededEventDetails *MapIterationEventDetails    `json:"mapIterationSucceededEventDetails,omitempty"`
+	MapIterationFailedEventDetails    *MapIterationEventDetails    `json:"mapIterationFailedEventDetails,omitempty"`
+	MapIterationAbortedEventDetails   *MapIterationEventDetails    `json:"mapIterationAbortedEventDetails,omitempty"`
+}
+
+// HistoryEventDataDetails tells whether the data of an event is truncated, which it never is.
+type HistoryEventDataDetails struct {
+	Truncated bool `json:"truncated"`
+}
+
+type ExecutionStartedEventDetails struct {
+	Input        string                   `json:"input"`
+	InputDetails *HistoryEventDataDetails `json:"inputDetails,omitempty"`
+}
+
+type ExecutionSucceededEventDetails struct {
+	Output        string                   `json:"output"`
+	OutputDetails *HistoryEventDataDetails `json:"outputDetails,omitempty"`
+}
+
+// ErrorEventDetails are the details of ExecutionFailed, ExecutionAborted and ExecutionTimedOut.
+type ErrorEventDetails struct {
+	Error string `json:"error,omitempty"`
+	Cause string `json:"cause,omitempty"`
+}
+
+type StateEnteredEventDetails struct {
+	Name         string                   `json:"name"`
+	Input        string                   `json:"input"`
+	InputDetails *HistoryEventDataDetails `json:"inputDetails,omitempty"`
+}
+
+type StateExitedEventDetails struct {
+	Name          string                   `json:"name"`
+	Output        string                   `json:"output"`
+	OutputDetails *HistoryEventDataDetails `json:"outputDetails,omitempty"`
+}
+
+// TaskScheduledEventDetails are the details of TaskScheduled.
+// The resource type and the resource are the function of the task and its argument, such as "script" and the path.
+type TaskScheduledEventDetails struct {
+	ResourceType       string `json:"resourceType"`
+	Resource           string `json:"resource"`
+	Region             string `json:"region"`
+	Parameters         string `json:"parameters"`
+	TimeoutInSeconds   *int64 `json:"timeoutInSeconds,omitempty"`
+	HeartbeatInSeconds *int64 `json:"heartbeatInSeconds,omitempty"`
+}
+
+// TaskEventDetails are the details of TaskStarted, TaskSucceeded, TaskFailed and TaskTimedOut.
+type TaskEventDetails struct {
+	ResourceType  string                   `json:"resourceType"`
+	Resource      string                   `json:"resource"`
+	Output        string                   `json:"output,omitempty"`
+	OutputDetails *HistoryEventDataDetails `json:"outputDetails,omitempty"`
+	Error         string                   `json:"error,omitempty"`
+	Cause         string                   `json:"cause,omitempty"`
+}
+
+type MapStateStartedEventDetails struct {
+	Length int `json:"length"`
+}
+
+// MapIterationEventDetails are the details of the MapIteration events.
+type MapIterationEventDetails struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+// historyRegion is the region of the tasks in TaskScheduled.
+const historyRegion = "local"
+
+// Timestamp is encoded in the seconds since the Unix epoch, as the AWS JSON protocol does.
+type Timestamp struct {
+	time.Time
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(t.UnixNano()/int64(time.Millisecond)) / 1000)
+}
+
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	var sec float64
+	if err := json.Unmarshal(b, &sec); err != nil {
+		return err
+	}
+	whole, frac := math.Modf(sec)
+	t.Time = time.Unix(int64(whole), int64(math.Round(frac*1000))*int64(time.Millisecond)).UTC()
+	return nil
+}
+
+// History is the events of an execution, including the ones of the branches of its Parallel and Map states.
+type History struct {
+	mu     sync.Mutex
+	events []HistoryEvent
+	now    func() time.Time
+}
+
+func newHistory(now func() time.Time) *History {
+	return &History{now: now}
+}
+
+// Events returns a copy of the events recorded so far.
+func (h *History) Events() []HistoryEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	events := make([]HistoryEvent, len(h.events))
+	copy(events, h.events)
+	return events
+}
+
+// MarshalJSON encodes the history in the same shape as the output of GetExecutionHistory.
+func (h *History) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"events": h.Events(),
+	})
+}
+
+// historyCursor is the last event of a branch, which is the previous event of the next one in the branch.
+type historyCursor struct {
+	last int64
+}
+
+type historyCursorKey struct{}
+
+// withHistoryCursor returns the context of a branch, whose first event follows the last event of the parent.
+// The first event of an execution follows the last event in the history, which exists if the execution is resumed.
+func (h *History) withHistoryCursor(ctx context.Context) context.Context {
+	if h == nil {
+		return ctx
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cursor := &historyCursor{last: int64(len(h.events))}
+	if parent, ok := ctx.Value(historyCursorKey{}).(*historyCursor); ok {
+		cursor.last = parent.last
+	}
+	return context.WithValue(ctx, historyCursorKey{}, cursor)
+}
+
+// add records the event with the next ID, linked to the last event of the branch of ctx.
+func (h *History) add(ctx context.Context, event HistoryEvent) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event.ID = int64(len(h.events)) + 1
+	event.Timestamp = Timestamp{h.now()}
+	if cursor, ok := ctx.Value(historyCursorKey{}).(*historyCursor); ok {
+		event.PreviousEventID = cursor.last
+		cursor.last = event.ID
+	}
+	h.events = append(h.events, event)
+}
+
+// historyData encodes the data of an event, such as the input and the output of a state.
+func historyData(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(b)
+}
+
+func (h *History) addExecutionStarted(ctx context.Context, input interface{}) {
+	h.add(ctx, HistoryEvent{
+		Type: HistoryEventExecutionStarted,
+		ExecutionStartedEventDetails: &ExecutionStartedEventDetails{
+			Input:        historyData(input),
+			InputDetails: &HistoryEventDataDetails{},
+		},
+	})
+}
+
+// addExecutionResult records the event of the end of the execution.
+func (h *History) addExecutionResult(ctx context.Context, res Result) {
+	details := &ErrorEventDetails{Error: res.Error, Cause: res.Cause}
+	switch res.Status {
+	case ExecutionStatusSucceeded:
+		h.add(ctx, HistoryEvent{
+			Type: HistoryEventExecutionSucceeded,
+			ExecutionSucceededEventDetails: &ExecutionSucceededEventDetails{
+				Output:        string(res.Output),
+				OutputDetails: &HistoryEventDataDetails{},
+			},
+		})
+	case ExecutionStatusAborted:
+		h.add(ctx, HistoryEvent{Type: HistoryEventExecutionAborted, ExecutionAbortedEventDetails: details})
+	case ExecutionStatusTimedOut:
+		h.add(ctx, HistoryEvent{Type: HistoryEventExecutionTimedOut, ExecutionTimedOutEventDetails: details})
+	default:
+		h.add(ctx, HistoryEvent{Type: HistoryEventExecutionFailed, ExecutionFailedEventDetails: details})
+	}
+}
+
+func (h *History) addStateEntered(ctx context.Context, state compiler.State, input interface{}) {
+	h.add(ctx, HistoryEvent{
+		Type: StateEnteredEventType(state.Common().Type),
+		StateEnteredEventDetails: &StateEnteredEventDetails{
+			Name:         state.Name(),
+			Input:        historyData(input),
+			InputDetails: &HistoryEventDataDetails{},
+		},
+	})
+}
+
+func (h *History) addStateExited(ctx context.Context, state compiler.State, output interface{}) {
+	h.add(ctx, HistoryEvent{
+		Type: StateExitedEventType(state.Common().Type),
+		StateExitedEventDetails: &StateExitedEventDetails{
+			Name:          state.Name(),
+			Output:        historyData(output),
+			OutputDetails: &HistoryEventDataDetails{},
+		},
+	})
+}
+
+func (h *History) addTaskScheduled(ctx context.Context, state compiler.TaskState, input interface{}) {
+	details := &TaskScheduledEventDetails{
+		ResourceType: state.Resouce.Type,
+		Resource:     state.Resouce.Path,
+		Region:       historyRegion,
+		Parameters:   historyData(input),
+	}
+	if state.TimeoutSeconds != nil {
+		v := int64(*state.TimeoutSeconds)
+		details.TimeoutInSeconds = &v
+	}
+	if state.HeartbeatSeconds != nil {
+		v := int64(*state.HeartbeatSeconds)
+		details.HeartbeatInSeconds = &v
+	}
+
+	h.add(ctx, HistoryEvent{Type: HistoryEventTaskScheduled, TaskScheduledEventDetails: details})
+	h.add(ctx, HistoryEvent{
+		Type:                    HistoryEventTaskStarted,
+		TaskStartedEventDetails: &TaskEventDetails{ResourceType: state.Resouce.Type, Resource: state.Resouce.Path},
+	})
+}
+
+// addTaskResult records TaskSucceeded, or TaskFailed or TaskTimedOut if the task failed.
+func (h *History) addTaskResult(ctx context.Context, state compiler.TaskState, output interface{}, stateserr StatesError) {
+	details := &TaskEventDetails{ResourceType: state.Resouce.Type, Resource: state.Resouce.Path}
+	if stateserr.IsEmpty() {
+		details.Output = historyData(output)
+		details.OutputDetails = &HistoryEventDataDetails{}
+		h.add(ctx, HistoryEvent{Type: HistoryEventTaskSucceeded, TaskSucceededEventDetails: details})
+		return
+	}
+
+	errorOutput := stateserr.errorOutput()
+	details.Error, _ = errorOutput["Error"].(string)
+	details.Cause, _ = errorOutput["Cause"].(string)
+	switch details.Error {
+	case StatesErrorTimeout, StatesErrorHeartbeatTimeout:
+		h.add(ctx, HistoryEvent{Type: HistoryEventTaskTimedOut, TaskTimedOutEventDetails: details})
+	default:
+		h.add(ctx, HistoryEvent{Type: HistoryEventTaskFailed, TaskFailedEventDetails: details})
+	}
+}
+
+func (h *History) addMapIteration(ctx context.Context, typ HistoryEventType, state compiler.MapState, index int) {
+	details := &MapIterationEventDetails{Name: state.Name(), Index: index}
+	event := HistoryEvent{Type: typ}
+	switch typ {
+	case HistoryEventMapIterationStarted:
+		event.MapIterationStartedEventDetails = details
+	case HistoryEventMapIterationSucceeded:
+		event.MapIterationSucceededEventDetails = details
+	case HistoryEventMapIterationFailed:
+		event.MapIterationFailedEventDetails = details
+	case HistoryEventMapIterationAborted:
+		event.MapIterationAbortedEventDetails = details
+	}
+	h.add(ctx, event)
+}
+
+// addStateResult records the end of a Map or a Parallel state, which is one of succeeded, failed and aborted.
+func (h *History) addStateResult(ctx context.Context, succeeded, failed, aborted HistoryEventType, stateserr StatesError) {
+	switch {
+	case stateserr.IsEmpty():
+		h.add(ctx, HistoryEvent{Type: succeeded})
+	case ctx.Err() != nil:
+		h.add(ctx, HistoryEvent{Type: aborted})
+	default:
+		h.add(ctx, HistoryEvent{Type: failed})
+	}
+}
+
+// executionHistories holds the histories of the executions run by an engine.
+// The histories of the running executions are always kept, and the ones of the finished executions
+// are kept up to limit, from the one that finished last.
+type executionHistories struct {
+	mu       sync.Mutex
+	m        map[string]*History
+	limit    int
+	finished []finishedHistory
+}
+
+type finishedHistory struct {
+	id      string
+	history *History
+}
+
+func newExecutionHistories(limit int) *executionHistories {
+	return &executionHistories{m: make(map[string]*History), limit: limit}
+}
+
+func (hs *executionHistories) get(id string) (*History, bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	h, ok := hs.m[id]
+	return h, ok
+}
+
+func (hs *executionHistories) set(id string, h *History) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.m[id] = h
+}
+
+// finish marks the history of the execution as finished, and drops the oldest finished ones over the limit.
+func (hs *executionHistories) finish(id string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	h, ok := hs.m[id]
+	if !ok {
+		return
+	}
+
+	// a resumed execution finishes again with the same history
+	for i, f := range hs.finished {
+		if f.history == h {
+			hs.finished = append(hs.finished[:i], hs.finished[i+1:]...)
+			break
+		}
+	}
+	hs.finished = append(hs.finished, finishedHistory{id: id, history: h})
+
+	for len(hs.finished) > hs.limit {
+		oldest := hs.finished[0]
+		hs.finished[0] = finishedHistory{}
+		hs.finished = hs.finished[1:]
+		if hs.m[oldest.id] == oldest.history {
+			delete(hs.m, oldest.id)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func TestEngine_History(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithClock(func() time.Time { return now }),
+		WithHistory(1),
+		WithTaskRegistry(task.FnMap{
+			"echo": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+				return in, "", nil
+			},
+			"error": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+				return nil, path, nil
+			},
+		}),
+	)
+
+	type event struct {
+		typ  HistoryEventType
+		id   int64
+		prev int64
+	}
+	tests := []struct {
+		name string
+		asl  string
+		want []event
+	}{
+		{
+			"task",
+			`{"StartAt": "P", "States": {
+				"P": {"Type": "Pass", "Next": "T"},
+				"T": {"Type": "Task", "Resource": "echo:x", "End": true}}}`,
+			[]event{
+				{HistoryEventExecutionStarted, 1, 0},
+				{"PassStateEntered", 2, 1},
+				{"PassStateExited", 3, 2},
+				{"TaskStateEntered", 4, 3},
+				{HistoryEventTaskScheduled, 5, 4},
+				{HistoryEventTaskStarted, 6, 5},
+				{HistoryEventTaskSucceeded, 7, 6},
+				{"TaskStateExited", 8, 7},
+				{HistoryEventExecutionSucceeded, 9, 8},
+			},
+		},
+		{
+			"task failed",
+			`{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "error:Custom.Error", "End": true}}}`,
+			[]event{
+				{HistoryEventExecutionStarted, 1, 0},
+				{"TaskStateEntered", 2, 1},
+				{HistoryEventTaskScheduled, 3, 2},
+				{HistoryEventTaskStarted, 4, 3},
+				{HistoryEventTaskFailed, 5, 4},
+				{HistoryEventExecutionFailed, 6, 5},
+			},
+		},
+		{
+			"map",
+			`{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemsPath": "$.items", "MaxConcurrency": 1, "End": true,
+				"Iterator": {"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}}}}`,
+			[]event{
+				{HistoryEventExecutionStarted, 1, 0},
+				{"MapStateEntered", 2, 1},
+				{HistoryEventMapStateStarted, 3, 2},
+				{HistoryEventMapIterationStarted, 4, 3},
+				{"PassStateEntered", 5, 4},
+				{"PassStateExited", 6, 5},
+				{HistoryEventMapIterationSucceeded, 7, 6},
+				{HistoryEventMapIterationStarted, 8, 3},
+				{"PassStateEntered", 9, 8},
+				{"PassStateExited", 10, 9},
+				{HistoryEventMapIterationSucceeded, 11, 10},
+				{HistoryEventMapStateSucceeded, 12, 3},
+				{"MapStateExited", 13, 12},
+				{HistoryEventExecutionSucceeded, 14, 13},
+			},
+		},
+		{
+			"parallel",
+			`{"StartAt": "P", "States": {"P": {"Type": "Parallel", "End": true, "Branches": [
+				{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "E"}}}]}}}`,
+			[]event{
+				{HistoryEventExecutionStarted, 1, 0},
+				{"ParallelStateEntered", 2, 1},
+				{HistoryEventParallelStateStarted, 3, 2},
+				{"FailStateEntered", 4, 3},
+				{HistoryEventParallelStateFailed, 5, 3},
+				{HistoryEventExecutionFailed, 6, 5},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := compiler.Compile(context.Background(), bytes.NewBufferString(tt.asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			if _, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`{"items": [{}, {}]}`)); err != nil {
+				t.Fatal("Execute() failed:", err)
+			}
+
+			h, err := engine.History("id")
+			if err != nil {
+				t.Fatal("History() failed:", err)
+			}
+
+			var got []event
+			for _, e := range h.Events() {
+				got = append(got, event{e.Type, e.ID, e.PreviousEventID})
+				if !e.Timestamp.Equal(now) {
+					t.Errorf("Timestamp = %s, want %s", e.Timestamp, now)
+				}
+			}
+			if d := cmp.Diff(got, tt.want, cmp.AllowUnexported(event{})); d != "" {
+				t.Errorf("Events() = \n%s", d)
+			}
+		})
+	}
+
+	if _, err := engine.History("xxx"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("History() error = %v, want %v", err, ErrExecutionNotFound)
+	}
+}
+
+func TestEngine_History_limit(t *testing.T) {
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(`{"StartAt": "P", "States": {"P": {"Type": "Pass", "End": true}}}`))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	var n int
+	newID := func() (string, error) {
+		n++
+		return fmt.Sprintf("id%d", n), nil
+	}
+
+	engine := NewEngine(WithIDGenerator(newID), WithHistory(2))
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Execute(context.Background(), nil, w, nil); err != nil {
+			t.Fatal("Execute() failed:", err)
+		}
+	}
+
+	// the history of the oldest execution is dropped
+	if _, err := engine.History("id1"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("History(id1) error = %v, want %v", err, ErrExecutionNotFound)
+	}
+	for _, id := range []string{"id2", "id3"} {
+		if _, err := engine.History(id); err != nil {
+			t.Errorf("History(%s) failed: %v", id, err)
+		}
+	}
+
+	// the histories are not recorded by default
+	engine = NewEngine(WithIDGenerator(newID))
+	res, err := engine.Execute(context.Background(), nil, w, nil)
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+	if _, err := engine.History(res.ID); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("History() error = %v, want %v", err, ErrNoHistory)
+	}
+}
+
+func TestHistory_MarshalJSON(t *testing.T) {
+	now := time.Date(2022, 1, 2, 3, 4, 5, 123000000, time.UTC)
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithClock(func() time.Time { return now }),
+		WithHistory(1),
+	)
+
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(`{"StartAt": "F", "States": {"F": {"Type": "Fail", "Error": "E", "Cause": "C"}}}`))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+	if _, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`{"a": 1}`)); err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+
+	h, err := engine.History("id")
+	if err != nil {
+		t.Fatal("History() failed:", err)
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal("json.Marshal() failed:", err)
+	}
+
+	want := `{"events":[` +
+		`{"timestamp":1641092645.123,"type":"ExecutionStarted","id":1,"previousEventId":0,"executionStartedEventDetails":{"input":"{\"a\":1}","inputDetails":{"truncated":false}}},` +
+		`{"timestamp":1641092645.123,"type":"FailStateEntered","id":2,"previousEventId":1,"stateEnteredEventDetails":{"name":"F","input":"{\"a\":1}","inputDetails":{"truncated":false}}},` +
+		`{"timestamp":1641092645.123,"type":"ExecutionFailed","id":3,"previousEventId":2,"executionFailedEventDetails":{"error":"E","cause":"C"}}]}`
+	if string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+
+	var got struct {
+		Events []HistoryEvent `json:"events"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal("json.Unmarshal() failed:", err)
+	}
+	if d := cmp.Diff(got.Events, h.Events()); d != "" {
+		t.Errorf("json.Unmarshal() = \n%s", d)
+	}
+}
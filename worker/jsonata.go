@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+// evalStateWithJSONata evaluates a state whose QueryLanguage is JSONata.
+// Arguments and Output take the place of InputPath, Parameters, ResultSelector, ResultPath and OutputPath.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/transforming-data.html
+func (w Workflow) evalStateWithJSONata(ctx context.Context, coj *compiler.CtxObj, state compiler.State, rawinput interface{}) (interface{}, string, *compiler.Variables, StatesError) {
+	vars := compiler.NewJSONataVars(coj, rawinput)
+	common := state.Common()
+
+	input := rawinput
+	if state.FieldsType() >= compiler.FieldsType5 && common.Arguments != nil {
+		v, err := common.Arguments.Eval(ctx, vars)
+		if err != nil {
+			return nil, "", nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+		}
+		input = v
+	}
+
+	state, stateerr := evalJSONataFields(ctx, state, vars)
+	if !stateerr.IsEmpty() {
+		return nil, "", nil, stateerr
+	}
+
+	result, next, rule, stateerr := w.evalState(ctx, coj, state, input)
+	if errors.Is(stateerr, ErrStateMachineTerminated) {
+		return result, "", nil, stateerr
+	}
+	if !stateerr.IsEmpty() {
+		return nil, "", nil, stateerr
+	}
+
+	// the Choice rule that matched has its own Assign and Output
+	assign, outputTmpl := common.Assign, common.Output
+	if rule != nil && rule.Assign != nil {
+		assign = rule.Assign
+	}
+	if rule != nil && rule.Output != nil {
+		outputTmpl = rule.Output
+	}
+
+	// Assign and Output are evaluated with the same variables
+	vars = vars.WithStates("result", result)
+
+	variables, stateerr := w.assignWithJSONata(ctx, coj, assign, vars)
+	if !stateerr.IsEmpty() {
+		return nil, "", nil, stateerr
+	}
+
+	if state.FieldsType() < compiler.FieldsType2 || outputTmpl == nil {
+		return result, next, variables, NewStatesError("", nil)
+	}
+
+	output, err := outputTmpl.Eval(ctx, vars)
+	if err != nil {
+		return nil, "", nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+	}
+
+	return output, next, variables, NewStatesError("", nil)
+}
+
+// catchWithJSONata returns the output of a catcher of a JSONata state, which is the error output unless Output is specified.
+func (w Workflow) catchWithJSONata(ctx context.Context, coj *compiler.CtxObj, state compiler.State, catch compiler.Catch, input, errorOutput interface{}) (interface{}, string, *compiler.Variables, error) {
+	vars := compiler.NewJSONataVars(coj, input).WithStates("errorOutput", errorOutput)
+
+	variables, serr := w.assignWithJSONata(ctx, coj, catch.Assign, vars)
+	if !serr.IsEmpty() {
+		return nil, "", nil, serr.withStateName(state.Name())
+	}
+
+	if catch.Output == nil {
+		return errorOutput, catch.Next, variables, nil
+	}
+
+	v, err := catch.Output.Eval(ctx, vars)
+	if err != nil {
+		return nil, "", nil, NewStatesError(StatesErrorQueryEvaluationError, err).withStateName(state.Name())
+	}
+
+	return v, catch.Next, variables, nil
+}
+
+// evalJSONataFields returns a copy of the state whose fields given by JSONata expressions,
+// such as TimeoutSeconds of a Task state and Seconds of a Wait state, are replaced by their values.
+func evalJSONataFields(ctx context.Context, state compiler.State, vars compiler.JSONataVars) (compiler.State, StatesError) {
+	var err error
+	switch v := state.(type) {
+	case compiler.TaskState:
+		if v.TimeoutSecondsExpr != nil {
+			if v.TimeoutSeconds, err = evalJSONataSeconds(ctx, v.TimeoutSecondsExpr, vars, 1); err != nil {
+				return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("invalid TimeoutSeconds: %w", err))
+			}
+			v.TimeoutSecondsExpr = nil
+		}
+		if v.HeartbeatSecondsExpr != nil {
+			if v.HeartbeatSeconds, err = evalJSONataSeconds(ctx, v.HeartbeatSecondsExpr, vars, 1); err != nil {
+				return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("invalid HeartbeatSeconds: %w", err))
+			}
+			v.HeartbeatSecondsExpr = nil
+		}
+		if v.TimeoutSeconds != nil && v.HeartbeatSeconds != nil && *v.HeartbeatSeconds >= *v.TimeoutSeconds {
+			return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("%w: HeartbeatSeconds must be smaller than TimeoutSeconds", compiler.ErrQueryEvaluationFailed))
+		}
+		return v, NewStatesError("", nil)
+	case compiler.WaitState:
+		if v.SecondsExpr != nil {
+			if v.Seconds, err = evalJSONataSeconds(ctx, v.SecondsExpr, vars, 0); err != nil {
+				return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("invalid Seconds: %w", err))
+			}
+			v.SecondsExpr = nil
+		}
+		if v.TimestampExpr != nil {
+			str, err := v.TimestampExpr.EvalString(ctx, vars)
+			if err != nil {
+				return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("invalid Timestamp: %w", err))
+			}
+			t, err := compiler.NewTimestamp(str)
+			if err != nil {
+				return nil, NewStatesError(StatesErrorQueryEvaluationError, fmt.Errorf("invalid Timestamp: %w: %v", compiler.ErrQueryEvaluationFailed, err))
+			}
+			v.Timestamp = &t
+			v.TimestampExpr = nil
+		}
+		return v, NewStatesError("", nil)
+	default:
+		return state, NewStatesError("", nil)
+	}
+}
+
+// evalJSONataSeconds evaluates a JSONata expression of seconds, which must be an integer not less than min.
+func evalJSONataSeconds(ctx context.Context, tmpl *compiler.JSONataTemplate, vars compiler.JSONataVars, min int) (*int, error) {
+	v, err := tmpl.Eval(ctx, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f < float64(min) || f > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: must be an integer not less than %d: %v", compiler.ErrQueryEvaluationFailed, min, v)
+	}
+	n := int(f)
+	return &n, nil
+}
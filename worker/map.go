@@ -4,77 +4,382 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
+	"sync/atomic"
 
 	"github.com/w-haibara/kakemoti/compiler"
 	"golang.org/x/sync/errgroup"
 )
 
-type mapOutputs struct {
-	mu sync.Mutex
-	v  []interface{}
+func (w Workflow) evalMap(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}) (interface{}, StatesError) {
+	iter, err := w.newWorkflow(&state.Iterator)
+	if err != nil {
+		return nil, NewStatesError("", err)
+	}
+
+	var items itemIterator
+	if state.ItemReader != nil {
+		v, stateserr := w.readItems(ctx, coj, state, input)
+		if !stateserr.IsEmpty() {
+			return nil, stateserr
+		}
+		items = v
+	} else {
+		v, stateserr := mapItems(ctx, coj, state, input)
+		if !stateserr.IsEmpty() {
+			return nil, stateserr
+		}
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, NewStatesError("", fmt.Errorf("input for Map must be an array: [%v]", v))
+		}
+		items = &sliceItems{items: arr}
+	}
+	defer items.close()
+
+	iterations, stateserr := newMapIterations(ctx, coj, state, input, items)
+	if !stateserr.IsEmpty() {
+		return nil, stateserr
+	}
+
+	// the child executions of a distributed Map can not access the variables of the parent
+	scope := coj.Variables()
+	if state.ProcessorConfig.Mode == compiler.ProcessorModeDistributed {
+		scope = nil
+	}
+
+	// the number of the items read by ItemReader is not known until they are all read
+	event := HistoryEvent{Type: HistoryEventMapStateStarted}
+	if n, ok := iterations.length(); ok {
+		event.MapStateStartedEventDetails = &MapStateStartedEventDetails{Length: n}
+	}
+	w.history.add(ctx, event)
+
+	// next is called only by runIterations, so results are never appended concurrently
+	var results []*mapResult
+	next := func() (mapIteration, bool, StatesError) {
+		it, ok, stateserr := iterations.next()
+		if ok {
+			it.result = &mapResult{input: it.input}
+			results = append(results, it.result)
+		}
+		return it, ok, stateserr
+	}
+
+	stateserr = runIterations(ctx, state, next, func(ctx context.Context, it mapIteration) error {
+		ctx = w.history.withHistoryCursor(ctx)
+		w.history.addMapIteration(ctx, HistoryEventMapIterationStarted, state, it.index)
+
+		err := w.iterate(ctx, iter, coj, scope, it.index, it.item, it.input, it.result)
+		switch {
+		case err == nil:
+			w.history.addMapIteration(ctx, HistoryEventMapIterationSucceeded, state, it.index)
+		case ctx.Err() != nil:
+			w.history.addMapIteration(ctx, HistoryEventMapIterationAborted, state, it.index)
+		default:
+			w.history.addMapIteration(ctx, HistoryEventMapIterationFailed, state, it.index)
+		}
+		return err
+	})
+	if !stateserr.IsEmpty() {
+		return nil, stateserr
+	}
+
+	if state.ResultWriter != nil {
+		return w.writeResults(ctx, coj, state, input, results)
+	}
+
+	// the error outputs of the tolerated failures take the place of their outputs
+	outputs := make([]interface{}, len(results))
+	for i, result := range results {
+		if result.err != nil {
+			outputs[i] = newIterationError(result.err).errorOutput()
+			continue
+		}
+		outputs[i] = result.output
+	}
+
+	return outputs, NewStatesError("", nil)
 }
 
-func (w Workflow) evalMap(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}) (interface{}, statesError) {
-	iter, err := NewWorkflow(&state.Iterator)
-	if err != nil {
-		return nil, statesError{"", err}
+// mapIteration is an iteration of a Map state, which runs for an item or a batch of items.
+type mapIteration struct {
+	index int
+	// item is $$.Map.Item.Value, which is the batch itself with ItemBatcher
+	item  interface{}
+	input interface{}
+	// size is the number of the items, which are all counted as failed if the iteration fails
+	size   int
+	result *mapResult
+}
+
+// mapIterations builds the iterations of a Map state from the items as they are read.
+// The inputs are selected from the items by ItemSelector before they are batched.
+type mapIterations struct {
+	ctx     context.Context
+	coj     *compiler.CtxObj
+	state   compiler.MapState
+	input   interface{}
+	items   itemIterator
+	batcher *itemBatcher
+	// nextItemIndex is the index of the next item, and nextIteration is the one of the next iteration
+	nextItemIndex int
+	nextIteration int
+}
+
+func newMapIterations(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}, items itemIterator) (*mapIterations, StatesError) {
+	m := &mapIterations{ctx: ctx, coj: coj, state: state, input: input, items: items}
+	if state.ItemBatcher != nil {
+		b, stateserr := newItemBatcher(ctx, coj, state, input)
+		if !stateserr.IsEmpty() {
+			return nil, stateserr
+		}
+		m.batcher = b
+	}
+	return m, NewStatesError("", nil)
+}
+
+// length returns the number of the iterations if it is known before they are run.
+func (m *mapIterations) length() (int, bool) {
+	if v, ok := m.items.(*sliceItems); ok && m.batcher == nil {
+		return len(v.items), true
 	}
+	return 0, false
+}
 
-	v, err := compiler.UnjoinByPath(coj, input, &state.ItemsPath.Path)
+func (m *mapIterations) next() (mapIteration, bool, StatesError) {
+	it := mapIteration{index: m.nextIteration, size: 1}
+
+	if m.batcher != nil {
+		batch, size, ok, stateserr := m.batcher.next(func() (interface{}, bool, StatesError) {
+			_, input, ok, stateserr := m.nextItem()
+			return input, ok, stateserr
+		})
+		if !stateserr.IsEmpty() || !ok {
+			return mapIteration{}, false, stateserr
+		}
+		it.item, it.input, it.size = batch, batch, size
+	} else {
+		item, input, ok, stateserr := m.nextItem()
+		if !stateserr.IsEmpty() || !ok {
+			return mapIteration{}, false, stateserr
+		}
+		it.item, it.input = item, input
+	}
+
+	m.nextIteration++
+	return it, true, NewStatesError("", nil)
+}
+
+// nextItem reads the next item, and returns it with the input selected from it.
+func (m *mapIterations) nextItem() (interface{}, interface{}, bool, StatesError) {
+	item, ok, err := m.items.next()
 	if err != nil {
-		return nil, NewStatesError("", err)
+		return nil, nil, false, NewStatesError(StatesErrorItemReaderFailed, err)
 	}
-	items, ok := v.([]interface{})
 	if !ok {
-		return nil, NewStatesError("", fmt.Errorf("input for Map must be an array: [%v]", v))
+		return nil, nil, false, NewStatesError("", nil)
 	}
 
-	var outputs mapOutputs
-	outputs.v = make([]interface{}, len(items))
-	eg := new(errgroup.Group)
-	count := 0
-	for i := range items {
-		i := i
+	index := m.nextItemIndex
+	m.nextItemIndex++
+
+	input, stateserr := m.selectItem(index, item)
+	if !stateserr.IsEmpty() {
+		return nil, nil, false, stateserr
+	}
+	return item, input, true, NewStatesError("", nil)
+}
+
+// selectItem returns the input of an iteration built by ItemSelector, or the item itself without ItemSelector.
+func (m *mapIterations) selectItem(index int, item interface{}) (interface{}, StatesError) {
+	if m.state.ItemSelector == nil && m.state.ItemSelectorExpr == nil {
+		return item, NewStatesError("", nil)
+	}
+	return selectItem(m.ctx, m.coj, m.state, m.input, index, item)
+}
+
+// iterate runs an iteration of a Map state, and stores its output or its error in result.
+func (w Workflow) iterate(ctx context.Context, iter *Workflow, coj *compiler.CtxObj, scope *compiler.Variables, index int, item, input interface{}, result *mapResult) error {
+	c, err := mapItemCtxObj(coj, index, item)
+	if err != nil {
+		result.err = err
+		return err
+	}
+
+	o, err := iter.exec(ctx, c, scope.NewScope(), input)
+	if !errors.Is(err, ErrStateMachineTerminated) && err != nil {
+		result.err = err
+		return err
+	}
+	result.output = o
+
+	return nil
+}
+
+// defaultDistributedMaxConcurrency is the number of the child executions of a distributed Map
+// that run at a time if MaxConcurrency is 0, which is the same as the limit of AWS.
+const defaultDistributedMaxConcurrency = 10000
+
+// runIterations runs the iterations returned by next, at most MaxConcurrency at a time
+// (unlimited if it is 0 in an inline Map). The next iteration is read as soon as one finishes,
+// and the remaining iterations are cancelled once the failures exceed the tolerated failure threshold.
+func runIterations(ctx context.Context, state compiler.MapState, next func() (mapIteration, bool, StatesError), iterate func(ctx context.Context, it mapIteration) error) StatesError {
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, egctx := errgroup.WithContext(cancelCtx)
+
+	maxConcurrency := state.MaxConcurrency
+	if maxConcurrency == 0 && state.ProcessorConfig.Mode == compiler.ProcessorModeDistributed {
+		maxConcurrency = defaultDistributedMaxConcurrency
+	}
+	var sem chan struct{}
+	if maxConcurrency > 0 {
+		sem = make(chan struct{}, maxConcurrency)
+	}
+
+	// the failures and the total are counted in items, and the total is known when all of them are read
+	var failed, total int64
+	var allRead int32
+	tolerated := state.ToleratedFailureCount != nil || state.ToleratedFailurePercentage != nil
+
+loop:
+	for {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-egctx.Done():
+				break loop
+			}
+		}
+		if egctx.Err() != nil {
+			break
+		}
+
+		it, ok, stateserr := next()
+		if !stateserr.IsEmpty() {
+			// the iterations in progress are cancelled by the error of the group
+			eg.Go(func() error { return stateserr })
+			break
+		}
+		if !ok {
+			atomic.StoreInt32(&allRead, 1)
+			break
+		}
+		atomic.AddInt64(&total, int64(it.size))
+
 		eg.Go(func() error {
-			c := new(compiler.CtxObj)
-			c1, err := c.SetByString("$.Map.Item.Index", i)
-			if err != nil {
-				return err
+			if sem != nil {
+				defer func() { <-sem }()
 			}
-			c2, err := c1.SetByString("$.Map.Item.Value", items[i])
-			if err != nil {
-				return err
+
+			err := iterate(egctx, it)
+			if err == nil {
+				return nil
+			}
+
+			f := int(atomic.AddInt64(&failed, int64(it.size)))
+			n := -1
+			if atomic.LoadInt32(&allRead) == 1 {
+				n = int(atomic.LoadInt64(&total))
 			}
-			c3, err := c2.SetAll(coj.GetAll())
-			if err != nil {
-				return err
+			if !toleratedFailureExceeded(state, f, n) {
+				return nil
 			}
 
-			o, err := iter.Exec(ctx, c3, items[i])
-			if !errors.Is(err, ErrStateMachineTerminated) && err != nil {
-				return err
+			// the iterations are cancelled before the slot is released, so that no more items are read
+			cancel()
+			if !tolerated {
+				return newIterationError(err)
 			}
+			return NewStatesError(StatesErrorExceedToleratedFailureThreshold, fmt.Errorf("%d items failed", f))
+		})
+	}
 
-			outputs.mu.Lock()
-			outputs.v[i] = o
-			outputs.mu.Unlock()
+	if err := eg.Wait(); err != nil {
+		return newIterationError(err)
+	}
 
-			return nil
-		})
+	// the iterations which are not started must not be taken as succeeded
+	if err := ctx.Err(); err != nil {
+		return interruptedError(err)
+	}
 
-		count++
-		if count > state.MaxConcurrency {
-			count = 0
-			if err := eg.Wait(); err != nil {
-				return nil, NewStatesError("", err)
-			}
+	// the percentage of the failures is checked when all the items are read
+	if f, n := int(failed), int(total); tolerated && toleratedFailureExceeded(state, f, n) {
+		return NewStatesError(StatesErrorExceedToleratedFailureThreshold, fmt.Errorf("%d of %d items failed", f, n))
+	}
+
+	return NewStatesError("", nil)
+}
+
+// mapItems returns the value to iterate over, which is selected by ItemsPath or Items.
+func mapItems(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}) (interface{}, StatesError) {
+	if !state.IsJSONata() {
+		v, err := compiler.UnjoinByPath(coj, input, &state.ItemsPath.Path)
+		if err != nil {
+			return nil, NewStatesError("", err)
 		}
+		return v, NewStatesError("", nil)
 	}
 
-	if err := eg.Wait(); err != nil {
-		return nil, NewStatesError("", err)
+	if state.Items == nil {
+		return input, NewStatesError("", nil)
 	}
 
-	return outputs.v, NewStatesError("", nil)
+	v, err := state.Items.Eval(ctx, compiler.NewJSONataVars(coj, input))
+	if err != nil {
+		return nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+	}
+	return v, NewStatesError("", nil)
+}
+
+// mapItemCtxObj returns the context object of an iteration, which has $$.Map.Item.
+// The item of the iteration takes precedence over the one inherited from an outer Map.
+func mapItemCtxObj(coj *compiler.CtxObj, index int, item interface{}) (*compiler.CtxObj, error) {
+	c, err := copyCtxObj(coj)
+	if err != nil {
+		return nil, err
+	}
+	return c.SetByString("$.Map", map[string]interface{}{
+		"Item": map[string]interface{}{
+			"Index": index,
+			"Value": item,
+		},
+	})
+}
+
+// selectItem returns the input of an iteration built by ItemSelector,
+// in which $ is the input of the state and $$.Map.Item is the item.
+// ref: https://docs.aws.amazon.com/step-functions/latest/dg/input-output-itemselector.html
+func selectItem(ctx context.Context, coj *compiler.CtxObj, state compiler.MapState, input interface{}, index int, item interface{}) (interface{}, StatesError) {
+	c, err := mapItemCtxObj(coj, index, item)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, err)
+	}
+
+	if state.IsJSONata() {
+		v, err := state.ItemSelectorExpr.Eval(ctx, compiler.NewJSONataVars(c, input))
+		if err != nil {
+			return nil, NewStatesError(StatesErrorQueryEvaluationError, err)
+		}
+		return v, NewStatesError("", nil)
+	}
+
+	v, err := state.ItemSelector.Resolve(ctx, c, input)
+	if err != nil {
+		if errors.Is(err, compiler.ErrIntrinsicFunctionFailed) {
+			return nil, NewStatesError(StatesErrorIntrinsicFailure, err)
+		}
+		return nil, NewStatesError(StatesErrorParameterPathFailure, fmt.Errorf("ItemSelector failed: %v", err))
+	}
+	return v, NewStatesError("", nil)
+}
+
+// newIterationError propagates the error of a failed iteration, so that it can be caught by its name.
+func newIterationError(err error) StatesError {
+	var serr StatesError
+	if errors.As(err, &serr) {
+		return serr
+	}
+	return NewStatesError("", err)
 }
@@ -0,0 +1,227 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func TestEngine_Execute_mapConcurrency(t *testing.T) {
+	var running, peak int32
+	tasks := task.FnMap{
+		"track": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			return in, "", nil
+		},
+	}
+	engine := NewEngine(WithTaskRegistry(tasks))
+
+	tests := []struct {
+		maxConcurrency int
+		want           int32
+	}{
+		{0, 6},
+		{1, 1},
+		{2, 2},
+		{10, 6},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&peak, 0)
+
+		asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "MaxConcurrency": ` + strconv.Itoa(tt.maxConcurrency) + `, "End": true,
+			"Iterator": {"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "track:x", "End": true}}}}}}`
+		w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+		if err != nil {
+			t.Fatal("compiler.Compile() failed:", err)
+		}
+
+		res, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`[{}, {}, {}, {}, {}, {}]`))
+		if err != nil {
+			t.Fatal("Execute() failed:", err)
+		}
+		if res.Status != ExecutionStatusSucceeded {
+			t.Fatalf("Status = %s: %s: %s", res.Status, res.Error, res.Cause)
+		}
+		if got := atomic.LoadInt32(&peak); got != tt.want {
+			t.Errorf("MaxConcurrency %d: %d iterations ran at a time, want %d", tt.maxConcurrency, got, tt.want)
+		}
+	}
+}
+
+func TestEngine_Execute_mapFailFast(t *testing.T) {
+	tasks := task.FnMap{
+		"fail_on": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			if in["name"] == path {
+				return nil, "Custom.Error", nil
+			}
+			<-ctx.Done()
+			return nil, "", ctx.Err()
+		},
+	}
+	engine := NewEngine(WithTaskRegistry(tasks))
+
+	tests := []struct {
+		name      string
+		tolerated string
+		wantError string
+	}{
+		{"not tolerated", ``, "Custom.Error"},
+		{"tolerated", `"ToleratedFailureCount": 0,`, StatesErrorExceedToleratedFailureThreshold},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the other iterations never finish unless they are cancelled
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "MaxConcurrency": 2, ` + tt.tolerated + ` "End": true,
+				"Iterator": {"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "fail_on:b", "End": true}}}}}}`
+			w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			res, err := engine.Execute(ctx, nil, w, bytes.NewBufferString(`[{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}]`))
+			if err != nil {
+				t.Fatal("Execute() failed:", err)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("the iterations were not cancelled")
+			}
+			if res.Status != ExecutionStatusFailed || res.Error != tt.wantError {
+				t.Errorf("Execute() = %s, %s: %s", res.Status, res.Error, res.Cause)
+			}
+		})
+	}
+}
+
+func TestEngine_Execute_mapTolerated(t *testing.T) {
+	tasks := task.FnMap{
+		"fail_on": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			if in["name"] == path {
+				return nil, "Custom.Error", nil
+			}
+			return in, "", nil
+		},
+	}
+	engine := NewEngine(WithTaskRegistry(tasks))
+
+	asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "ToleratedFailurePercentage": 50, "End": true,
+		"ItemProcessor": {"ProcessorConfig": {"Mode": "INLINE"}, "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "fail_on:b", "End": true}}}}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	res, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`[{"name": "a"}, {"name": "b"}]`))
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+
+	want := `[{"name":"a"},{"Cause":"fn() failed: Custom.Error","Error":"Custom.Error"}]`
+	if res.Status != ExecutionStatusSucceeded || string(res.Output) != want {
+		t.Errorf("Execute() = %s, %s, want %s", res.Status, res.Output, want)
+	}
+}
+
+// The items in a failed batch are all counted as failed.
+func TestEngine_Execute_mapBatchTolerated(t *testing.T) {
+	tasks := task.FnMap{
+		"fail_first": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			items, _ := in["Items"].([]interface{})
+			if len(items) > 0 && items[0] == float64(1) {
+				return nil, "Custom.Error", nil
+			}
+			return in, "", nil
+		},
+	}
+	engine := NewEngine(WithTaskRegistry(tasks))
+
+	tests := []struct {
+		name       string
+		tolerated  string
+		wantStatus ExecutionStatus
+	}{
+		{"count", `"ToleratedFailureCount": 1,`, ExecutionStatusFailed},
+		{"count(all items)", `"ToleratedFailureCount": 2,`, ExecutionStatusSucceeded},
+		{"percentage", `"ToleratedFailurePercentage": 50,`, ExecutionStatusFailed},
+		{"percentage(all items)", `"ToleratedFailurePercentage": 67,`, ExecutionStatusSucceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the batches are [1, 2] and [3], and the first one fails
+			asl := `{"StartAt": "M", "States": {"M": {"Type": "Map", "ItemBatcher": {"MaxItemsPerBatch": 2}, ` + tt.tolerated + ` "End": true,
+				"ItemProcessor": {"ProcessorConfig": {"Mode": "DISTRIBUTED", "ExecutionType": "STANDARD"}, "StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "fail_first:x", "End": true}}}}}}`
+			w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+			if err != nil {
+				t.Fatal("compiler.Compile() failed:", err)
+			}
+
+			res, err := engine.Execute(context.Background(), nil, w, bytes.NewBufferString(`[1, 2, 3]`))
+			if err != nil {
+				t.Fatal("Execute() failed:", err)
+			}
+			if res.Status != tt.wantStatus {
+				t.Errorf("Status = %s, want %s: %s: %s", res.Status, tt.wantStatus, res.Error, res.Cause)
+			}
+			if res.Status == ExecutionStatusFailed && res.Error != StatesErrorExceedToleratedFailureThreshold {
+				t.Errorf("Error = %s, want %s", res.Error, StatesErrorExceedToleratedFailureThreshold)
+			}
+		})
+	}
+}
+
+// A distributed Map runs at most defaultDistributedMaxConcurrency iterations at a time without MaxConcurrency.
+func Test_runIterations_distributedMaxConcurrency(t *testing.T) {
+	state := compiler.MapState{ProcessorConfig: compiler.ProcessorConfig{Mode: compiler.ProcessorModeDistributed}}
+
+	n := defaultDistributedMaxConcurrency + 1
+	var read int
+	next := func() (mapIteration, bool, StatesError) {
+		if read >= n {
+			return mapIteration{}, false, NewStatesError("", nil)
+		}
+		read++
+		return mapIteration{index: read - 1, size: 1}, true, NewStatesError("", nil)
+	}
+
+	var running int32
+	release := make(chan struct{})
+	done := make(chan StatesError, 1)
+	go func() {
+		done <- runIterations(context.Background(), state, next, func(ctx context.Context, it mapIteration) error {
+			atomic.AddInt32(&running, 1)
+			<-release
+			return nil
+		})
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&running) < defaultDistributedMaxConcurrency && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&running); got != defaultDistributedMaxConcurrency {
+		t.Errorf("%d iterations ran at a time, want %d", got, defaultDistributedMaxConcurrency)
+	}
+
+	close(release)
+	if serr := <-done; !serr.IsEmpty() {
+		t.Errorf("runIterations() = %v", serr)
+	}
+}
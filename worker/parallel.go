@@ -14,19 +14,23 @@ type parallelOutputs struct {
 	v  []interface{}
 }
 
-func (w Workflow) evalParallel(ctx context.Context, coj *compiler.CtxObj, state compiler.ParallelState, input interface{}) (interface{}, statesError) {
-	var eg errgroup.Group
+// evalParallel runs the branches concurrently. The first branch that fails stops the others, and fails the state.
+func (w Workflow) evalParallel(ctx context.Context, coj *compiler.CtxObj, state compiler.ParallelState, input interface{}) (interface{}, StatesError) {
+	eg, ctx := errgroup.WithContext(ctx)
 	var outputs parallelOutputs
 	outputs.v = make([]interface{}, len(state.Branches))
 	for i := range state.Branches {
 		i := i
 		eg.Go(func() error {
-			w, err := NewWorkflow(&state.Branches[i])
+			ctx := w.history.withHistoryCursor(ctx)
+
+			w, err := w.newWorkflow(&state.Branches[i])
 			if err != nil {
 				return err
 			}
 
-			o, err := w.Exec(ctx, coj, input)
+			// the branch reads the variables of the state, but its own variables are discarded
+			o, err := w.exec(ctx, coj, coj.Variables().NewScope(), input)
 			if !errors.Is(err, ErrStateMachineTerminated) && err != nil {
 				return err
 			}
@@ -40,8 +44,37 @@ func (w Workflow) evalParallel(ctx context.Context, coj *compiler.CtxObj, state
 	}
 
 	if err := eg.Wait(); err != nil {
-		return nil, NewStatesError(StatesErrorBranchFailed, err)
+		return nil, newBranchError(err)
 	}
 
 	return outputs.v, NewStatesError("", nil)
 }
+
+// newBranchError returns the error of a Parallel state whose branch failed, which is the error of the branch itself,
+// as the error output and the result of the execution are the ones of the original error.
+// It is matched by States.BranchFailed in Retry and Catch as well as by its own name.
+func newBranchError(err error) StatesError {
+	var serr StatesError
+	if !errors.As(err, &serr) {
+		serr = NewStatesError("", err)
+	}
+	return StatesError{
+		Name:      serr.Name,
+		Cause:     serr.Cause,
+		StateName: serr.StateName,
+		Err:       branchError{serr},
+	}
+}
+
+// branchError marks the error of a failed branch, so that States.BranchFailed matches it.
+type branchError struct {
+	err StatesError
+}
+
+func (e branchError) Error() string {
+	return e.err.Error()
+}
+
+func (e branchError) Unwrap() error {
+	return e.err
+}
@@ -6,7 +6,7 @@ import (
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
-func (w Workflow) evalPass(ctx context.Context, state compiler.PassState, input interface{}) (interface{}, statesError) {
+func (w Workflow) evalPass(ctx context.Context, state compiler.PassState, input interface{}) (interface{}, StatesError) {
 	output := state.Result
 	if output == nil {
 		output = input
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+const (
+	defaultRetryIntervalSeconds = 1
+	defaultRetryMaxAttempts     = 3
+	defaultRetryBackoffRate     = 2.0
+	maxRetryDelaySeconds        = 31622400 // 1 year
+)
+
+// retryInterval finds the first retrier that matches the error, and returns the interval before the next attempt.
+// The attempt counter of the retrier is incremented, so the counters must be kept across the attempts of a state.
+// It returns false if no retrier matches or the matched retrier has no attempts left.
+// ref: https://states-language.net/#retrying-after-error
+func retryInterval(retriers []compiler.Retry, attempts []int, stateserr StatesError) (time.Duration, bool) {
+	for i, retrier := range retriers {
+		if _, ok := stateserr.match(retrier.ErrorEquals); !ok {
+			continue
+		}
+
+		maxAttempts := defaultRetryMaxAttempts
+		if retrier.MaxAttempts != nil {
+			maxAttempts = *retrier.MaxAttempts
+		}
+		if attempts[i] >= maxAttempts {
+			return 0, false
+		}
+
+		intervalSeconds := defaultRetryIntervalSeconds
+		if retrier.IntervalSeconds != nil {
+			intervalSeconds = *retrier.IntervalSeconds
+		}
+
+		backoffRate := defaultRetryBackoffRate
+		if retrier.BackoffRate != nil {
+			backoffRate = *retrier.BackoffRate
+		}
+
+		seconds := float64(intervalSeconds) * math.Pow(backoffRate, float64(attempts[i]))
+		if retrier.MaxDelaySeconds != nil && seconds > float64(*retrier.MaxDelaySeconds) {
+			seconds = float64(*retrier.MaxDelaySeconds)
+		}
+		if seconds > maxRetryDelaySeconds {
+			seconds = maxRetryDelaySeconds
+		}
+		if retrier.JitterStrategy == compiler.JitterStrategyFull {
+			seconds = rand.Float64() * seconds // #nosec G404
+		}
+
+		attempts[i]++
+
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+
+	return 0, false
+}
+
+// sleep pauses the current goroutine for at least the duration d, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
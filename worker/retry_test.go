@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func Test_retryInterval(t *testing.T) {
+	type attempt struct {
+		err  string
+		want time.Duration
+		ok   bool
+	}
+	tests := []struct {
+		name     string
+		retriers []compiler.Retry
+		attempts []attempt
+	}{
+		{
+			"default",
+			[]compiler.Retry{{ErrorEquals: []string{StatesErrorALL}}},
+			[]attempt{
+				{"A", 1 * time.Second, true},
+				{"A", 2 * time.Second, true},
+				{"A", 4 * time.Second, true},
+				{"A", 0, false},
+			},
+		},
+		{
+			"backoff",
+			[]compiler.Retry{{ErrorEquals: []string{"A"}, IntervalSeconds: intPtr(3), BackoffRate: float64Ptr(1.5), MaxAttempts: intPtr(4)}},
+			[]attempt{
+				{"A", 3 * time.Second, true},
+				{"A", 4500 * time.Millisecond, true},
+				{"A", 6750 * time.Millisecond, true},
+				{"A", 10125 * time.Millisecond, true},
+				{"A", 0, false},
+			},
+		},
+		{
+			"max delay",
+			[]compiler.Retry{{ErrorEquals: []string{"A"}, IntervalSeconds: intPtr(2), MaxDelaySeconds: intPtr(5), MaxAttempts: intPtr(4)}},
+			[]attempt{
+				{"A", 2 * time.Second, true},
+				{"A", 4 * time.Second, true},
+				{"A", 5 * time.Second, true},
+				{"A", 5 * time.Second, true},
+			},
+		},
+		{
+			"no attempts",
+			[]compiler.Retry{{ErrorEquals: []string{"A"}, MaxAttempts: intPtr(0)}, {ErrorEquals: []string{StatesErrorALL}}},
+			[]attempt{
+				{"A", 0, false},
+			},
+		},
+		{
+			"not matched",
+			[]compiler.Retry{{ErrorEquals: []string{"A"}}},
+			[]attempt{
+				{"B", 0, false},
+			},
+		},
+		{
+			"States.ALL does not match States.Runtime",
+			[]compiler.Retry{{ErrorEquals: []string{StatesErrorALL}}},
+			[]attempt{
+				{StatesErrorRuntime, 0, false},
+			},
+		},
+		{
+			"counters per retrier",
+			[]compiler.Retry{{ErrorEquals: []string{"A"}, MaxAttempts: intPtr(2)}, {ErrorEquals: []string{"B"}, IntervalSeconds: intPtr(10)}},
+			[]attempt{
+				{"A", 1 * time.Second, true},
+				{"B", 10 * time.Second, true},
+				{"A", 2 * time.Second, true},
+				{"B", 20 * time.Second, true},
+				{"A", 0, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counters := make([]int, len(tt.retriers))
+			got := make([]attempt, len(tt.attempts))
+			for i, a := range tt.attempts {
+				d, ok := retryInterval(tt.retriers, counters, NewStatesError(a.err, nil))
+				got[i] = attempt{a.err, d, ok}
+			}
+			if d := cmp.Diff(got, tt.attempts, cmp.AllowUnexported(attempt{})); d != "" {
+				t.Errorf("retryInterval() failed: \n%s", d)
+			}
+		})
+	}
+}
+
+func Test_retryInterval_jitter(t *testing.T) {
+	retriers := []compiler.Retry{{ErrorEquals: []string{"A"}, IntervalSeconds: intPtr(4), MaxAttempts: intPtr(100), JitterStrategy: compiler.JitterStrategyFull}}
+	counters := make([]int, len(retriers))
+	for i := 0; i < 10; i++ {
+		d, ok := retryInterval(retriers, counters, NewStatesError("A", nil))
+		if !ok {
+			t.Fatal("retryInterval() failed")
+		}
+		if max := time.Duration(4*(1<<i)) * time.Second; d < 0 || d > max {
+			t.Errorf("retryInterval() = %v, want [0, %v]", d, max)
+		}
+	}
+}
+
+func Test_sleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleep() error = %v, want %v", err, context.Canceled)
+	}
+	if err := sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("sleep() error = %v", err)
+	}
+}
+
+// An execution stopped while a state waits for a retry is aborted, rather than timed out and caught.
+func TestEngine_Execute_retryAborted(t *testing.T) {
+	asl := `{"StartAt": "P", "States": {
+		"P": {"Type": "Parallel", "Branches": [{"StartAt": "T", "States": {
+			"T": {"Type": "Task", "Resource": "error:Flaky", "End": true,
+				"Retry": [{"ErrorEquals": ["Flaky"], "IntervalSeconds": 3600}]}}}],
+			"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "S"}], "End": true},
+		"S": {"Type": "Succeed"}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	engine := NewEngine(WithTaskRegistry(task.FnMap{
+		"error": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+			return nil, path, nil
+		},
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	res, err := engine.Execute(ctx, nil, w, nil)
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+	if res.Status != ExecutionStatusAborted || !errors.Is(res.Err(), ErrExecutionAborted) {
+		t.Errorf("Execute() = %#v, %v", res, res.Err())
+	}
+}
+
+func TestEngine_Execute_retryEnteredTime(t *testing.T) {
+	asl := `{"StartAt": "T", "States": {"T": {"Type": "Task", "Resource": "flaky:x", "End": true,
+		"Parameters": {"entered.$": "$$.State.EnteredTime"},
+		"Retry": [{"ErrorEquals": ["Flaky"], "IntervalSeconds": 0}]}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	// the clock advances a second every time it is read
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	var entered []interface{}
+	engine := NewEngine(
+		WithClock(func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}),
+		WithTaskRegistry(task.FnMap{
+			"flaky": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+				entered = append(entered, in["entered"])
+				if len(entered) == 1 {
+					return nil, "Flaky", nil
+				}
+				return in, "", nil
+			},
+		}),
+	)
+
+	res, err := engine.Execute(context.Background(), nil, w, nil)
+	if err != nil || res.Status != ExecutionStatusSucceeded {
+		t.Fatalf("Execute() = %#v, %v", res, err)
+	}
+	if len(entered) != 2 || entered[0] != entered[1] {
+		t.Errorf("$$.State.EnteredTime of the attempts = %v", entered)
+	}
+}
@@ -1,62 +1,200 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
 
+// Error names predefined by the Amazon States Language.
+// ref: https://states-language.net/#appendix-a-predefined-error-codes
+//
+// A StatesError carries one of these names, or the custom name raised by a
+// Task or a Fail state.
 const (
-	StatesErrorALL                    = "States.ALL"
-	StatesErrorHeartbeatTimeout       = "States.HeartbeatTimeout"
-	StatesErrorTimeout                = "States.Timeout"
-	StatesErrorTaskFailed             = "States.TaskFailed"
-	StatesErrorPermissions            = "States.Permissions"
+	// StatesErrorALL is a wildcard that matches any error name in Retry and Catch.
+	StatesErrorALL = "States.ALL"
+	// StatesErrorHeartbeatTimeout is raised when a Task state failed to heartbeat
+	// for a time longer than the HeartbeatSeconds value.
+	StatesErrorHeartbeatTimeout = "States.HeartbeatTimeout"
+	// StatesErrorTimeout is raised when a Task state ran longer than its
+	// TimeoutSeconds value, or an execution exceeded its TimeoutSeconds.
+	StatesErrorTimeout = "States.Timeout"
+	// StatesErrorTaskFailed is raised when a Task state failed during the execution.
+	StatesErrorTaskFailed = "States.TaskFailed"
+	// StatesErrorPermissions is raised when a Task state failed because it had
+	// insufficient privileges to execute the specified code.
+	StatesErrorPermissions = "States.Permissions"
+	// StatesErrorResultPathMatchFailure is raised when a state's ResultPath field
+	// cannot be applied to the input the state received.
 	StatesErrorResultPathMatchFailure = "States.ResultPathMatchFailure"
-	StatesErrorParameterPathFailure   = "States.ParameterPathFailure"
-	StatesErrorBranchFailed           = "States.BranchFailed"
-	StatesErrorNoChoiceMatched        = "States.NoChoiceMatched"
-	StatesErrorIntrinsicFailure       = "States.IntrinsicFailure"
+	// StatesErrorParameterPathFailure is raised when a field in a state's
+	// Parameters whose name ends in ".$" tries to resolve an invalid path.
+	StatesErrorParameterPathFailure = "States.ParameterPathFailure"
+	// StatesErrorBranchFailed is raised when a branch of a Parallel state failed.
+	StatesErrorBranchFailed = "States.BranchFailed"
+	// StatesErrorNoChoiceMatched is raised when a Choice state failed to find a
+	// match for the condition field extracted from its input.
+	StatesErrorNoChoiceMatched = "States.NoChoiceMatched"
+	// StatesErrorIntrinsicFailure is raised when an intrinsic function in a
+	// payload template failed.
+	StatesErrorIntrinsicFailure = "States.IntrinsicFailure"
+	// StatesErrorQueryEvaluationError is raised when a JSONata expression failed,
+	// or resulted in a value of an invalid type.
+	StatesErrorQueryEvaluationError = "States.QueryEvaluationError"
+	// StatesErrorItemReaderFailed is raised when the ItemReader of a Map state
+	// failed to read the items.
+	StatesErrorItemReaderFailed = "States.ItemReaderFailed"
+	// StatesErrorResultWriterFailed is raised when the ResultWriter of a Map
+	// state failed to write the results.
+	StatesErrorResultWriterFailed = "States.ResultWriterFailed"
+	// StatesErrorExceedToleratedFailureThreshold is raised when more iterations
+	// of a Map state failed than its ToleratedFailureCount or ToleratedFailurePercentage.
+	StatesErrorExceedToleratedFailureThreshold = "States.ExceedToleratedFailureThreshold"
+	// StatesErrorRuntime is raised for failures that have no more specific name,
+	// such as an invalid InputPath or OutputPath.
+	StatesErrorRuntime = "States.Runtime"
 )
 
-type statesError struct {
-	statesErr string
-	err       error
+// StatesError is an error raised while running a state.
+//
+// Errors returned by Workflow.Exec and Result.Err can be inspected with errors.As:
+//
+//	var serr worker.StatesError
+//	if errors.As(err, &serr) && serr.Name == worker.StatesErrorTaskFailed {
+//		...
+//	}
+type StatesError struct {
+	// Name is the ASL error name, matched against ErrorEquals of Retry and Catch.
+	Name string
+	// Cause is a human-readable description of the error.
+	Cause string
+	// StateName is the name of the state that raised the error.
+	StateName string
+	// Err is the underlying Go error, if any.
+	Err error
 }
 
-func NewStatesError(statesErr string, err error) statesError {
-	return statesError{
-		statesErr: statesErr,
-		err:       err,
+func NewStatesError(name string, err error) StatesError {
+	cause := ""
+	if err != nil {
+		cause = err.Error()
+	}
+	return StatesError{
+		Name:  name,
+		Cause: cause,
+		Err:   err,
 	}
 }
 
-func (e statesError) StatesError() string {
-	return e.statesErr
-}
-
-func (e statesError) Error() string {
+func (e StatesError) Error() string {
 	if e.IsEmpty() {
 		return "nil"
 	}
-	s1 := e.statesErr
-	s2 := "nil"
-	if e.err != nil {
-		s2 = e.err.Error()
+	name := e.Name
+	if name == "" {
+		name = StatesErrorRuntime
+	}
+	s := name
+	if e.Cause != "" {
+		s += ": " + e.Cause
 	}
-	return fmt.Sprintf("StateError=[%s], Error=[%s]", s1, s2)
+	if e.StateName != "" {
+		s += fmt.Sprintf(" (state: %s)", e.StateName)
+	}
+	return s
 }
 
-func (e *statesError) IsEmpty() bool {
+func (e *StatesError) IsEmpty() bool {
 	if e == nil {
 		return true
 	}
-	return e.statesErr == "" && e.err == nil
+	return e.Name == "" && e.Cause == "" && e.Err == nil
+}
+
+func (e StatesError) Unwrap() error {
+	return e.Err
+}
+
+// As allows errors.As to extract the error into a *StatesError as well.
+func (e StatesError) As(target interface{}) bool {
+	if p, ok := target.(**StatesError); ok {
+		*p = &e
+		return true
+	}
+	return false
 }
 
-func (e statesError) Is(target error) bool {
-	return errors.Is(e.err, target)
+// withStateName sets the name of the state that raised the error, unless it is already known.
+func (e StatesError) withStateName(name string) StatesError {
+	if e.IsEmpty() || e.StateName != "" {
+		return e
+	}
+	e.StateName = name
+	return e
 }
 
-func (e statesError) As(target interface{}) bool {
-	return errors.As(e.err, target)
+// match reports whether one of the names in ErrorEquals matches the error, and returns the matched error.
+// The errors wrapped by e are matched as well, and States.BranchFailed matches the error of a failed branch.
+// States.ALL does not match States.Runtime, and nothing matches an abort.
+func (e StatesError) match(errorEquals []string) (StatesError, bool) {
+	if errors.Is(e, ErrExecutionAborted) {
+		return StatesError{}, false
+	}
+
+	for _, target := range errorEquals {
+		if target == StatesErrorALL {
+			if e.name() == StatesErrorRuntime {
+				continue
+			}
+			return e, true
+		}
+		if target == StatesErrorBranchFailed && errors.As(e, new(branchError)) {
+			return e, true
+		}
+
+		var err error = e
+		for err != nil {
+			var serr StatesError
+			if !errors.As(err, &serr) {
+				break
+			}
+			if serr.Name == target {
+				return serr, true
+			}
+			err = serr.Err
+		}
+	}
+
+	return StatesError{}, false
+}
+
+// errorOutput returns the error output passed to the state that a Catch transitions to.
+// ref: https://states-language.net/#error-output
+func (e StatesError) errorOutput() map[string]interface{} {
+	return map[string]interface{}{
+		"Error": e.name(),
+		"Cause": e.Cause,
+	}
+}
+
+// name returns the name of the error. An error without a name is States.Runtime, except the one of a Fail state.
+func (e StatesError) name() string {
+	if e.Name == "" && !errors.Is(e, ErrStateMachineFailed) {
+		return StatesErrorRuntime
+	}
+	return e.Name
+}
+
+// ErrExecutionAborted is the cause of a state interrupted because the execution,
+// or the enclosing Parallel or Map state, is stopped.
+var ErrExecutionAborted = errors.New("execution aborted")
+
+// interruptedError returns the error of a state interrupted by the error of its context.
+// It is States.Timeout if the execution timed out. Otherwise it is an abort, which no Retrier or Catcher matches.
+func interruptedError(err error) StatesError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewStatesError(StatesErrorTimeout, err)
+	}
+	return StatesError{Cause: ErrExecutionAborted.Error(), Err: fmt.Errorf("%w: %v", ErrExecutionAborted, err)}
 }
@@ -0,0 +1,211 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+var (
+	ErrExecutionNotFound = errors.New("execution not found")
+	ErrNoExecutionStore  = errors.New("no execution store")
+)
+
+// Checkpoint is the progress of an unfinished execution, saved at every transition.
+// The execution is resumed from the state, with the data, the context object and the variables at that time.
+type Checkpoint struct {
+	ID        string    `json:"ID"`
+	StartDate time.Time `json:"StartDate"`
+	// StateName is the state to be run next, and Input is its input.
+	StateName string          `json:"StateName"`
+	Input     json.RawMessage `json:"Input"`
+	// Context is the context object of the execution, which has $$.Execution and $$.StateMachine.
+	Context   json.RawMessage        `json:"Context"`
+	Variables map[string]interface{} `json:"Variables,omitempty"`
+	// EnteredTime is when the state was entered, which is kept as $$.State.EnteredTime after it is resumed.
+	// A Wait state waits only for the rest of its seconds.
+	EnteredTime time.Time `json:"EnteredTime"`
+	// RetryCount and RetryAttempts are the counters of the retriers of the state, which is retried after it is resumed.
+	RetryCount    int       `json:"RetryCount,omitempty"`
+	RetryAttempts []int     `json:"RetryAttempts,omitempty"`
+	UpdateDate    time.Time `json:"UpdateDate"`
+}
+
+// ExecutionStore keeps the checkpoints of the unfinished executions.
+// The checkpoint of an execution is deleted when it finishes, unless it is aborted.
+type ExecutionStore interface {
+	Save(ctx context.Context, cp Checkpoint) error
+	// Load returns ErrExecutionNotFound if there is no checkpoint of the execution.
+	Load(ctx context.Context, id string) (Checkpoint, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Checkpoint, error)
+}
+
+// FileStore is an ExecutionStore that saves each checkpoint in a JSON file named after the execution ID.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid execution ID: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Save writes the checkpoint to a temporary file and renames it, so that a crash never leaves a broken checkpoint.
+func (s *FileStore) Save(ctx context.Context, cp Checkpoint) error {
+	path, err := s.path(cp.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FileStore) Load(ctx context.Context, id string) (Checkpoint, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Checkpoint{}, fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return Checkpoint{}, fmt.Errorf("broken checkpoint: %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns the checkpoints of the unfinished executions, in the order of their start dates.
+func (s *FileStore) List(ctx context.Context) ([]Checkpoint, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cps []Checkpoint
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		cp, err := s.Load(ctx, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		cps = append(cps, cp)
+	}
+
+	sort.SliceStable(cps, func(i, j int) bool {
+		return cps[i].StartDate.Before(cps[j].StartDate)
+	})
+
+	return cps, nil
+}
+
+// stateProgress is the progress of a state kept in the checkpoint:
+// when the state was entered, and the counters of its retriers.
+type stateProgress struct {
+	enteredTime   time.Time
+	retryCount    int
+	retryAttempts []int
+}
+
+// checkpoint saves the progress of the execution before the state is run, if the workflow has an execution store.
+// Only the top-level workflow is checkpointed, so a Parallel or a Map state is run from the start after it is resumed.
+func (w Workflow) checkpoint(ctx context.Context, coj *compiler.CtxObj, vars *compiler.Variables, state compiler.State, input interface{}, progress stateProgress) error {
+	if w.store == nil {
+		return nil
+	}
+
+	in, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to save the checkpoint: %w", err)
+	}
+
+	c, err := json.Marshal(coj.GetAll())
+	if err != nil {
+		return fmt.Errorf("failed to save the checkpoint: %w", err)
+	}
+
+	cp := Checkpoint{
+		ID:            w.ID,
+		StartDate:     w.startDate,
+		StateName:     state.Name(),
+		Input:         in,
+		Context:       c,
+		Variables:     vars.All(),
+		EnteredTime:   progress.enteredTime,
+		RetryCount:    progress.retryCount,
+		RetryAttempts: progress.retryAttempts,
+		UpdateDate:    w.getEngine().now(),
+	}
+	if err := w.store.Save(ctx, cp); err != nil {
+		return fmt.Errorf("failed to save the checkpoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/w-haibara/kakemoti/compiler"
+	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(t.TempDir())
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cps := []Checkpoint{
+		{ID: "b", StartDate: now.Add(time.Second), StateName: "S", Input: json.RawMessage(`{}`), Context: json.RawMessage(`{}`), UpdateDate: now},
+		{ID: "a", StartDate: now, StateName: "S", Input: json.RawMessage(`{"x":1}`), Context: json.RawMessage(`{}`),
+			Variables: map[string]interface{}{"v": "w"}, RetryCount: 1, RetryAttempts: []int{1}, UpdateDate: now},
+	}
+	for _, cp := range cps {
+		if err := store.Save(ctx, cp); err != nil {
+			t.Fatal("Save() failed:", err)
+		}
+	}
+
+	got, err := store.Load(ctx, "a")
+	if err != nil {
+		t.Fatal("Load() failed:", err)
+	}
+	if d := cmp.Diff(got, cps[1]); d != "" {
+		t.Errorf("Load() = \n%s", d)
+	}
+
+	list, err := store.List(ctx)
+	if err != nil {
+		t.Fatal("List() failed:", err)
+	}
+	if d := cmp.Diff(list, []Checkpoint{cps[1], cps[0]}); d != "" {
+		t.Errorf("List() = \n%s", d)
+	}
+
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatal("Delete() failed:", err)
+	}
+	if _, err := store.Load(ctx, "a"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("Load() error = %v, want %v", err, ErrExecutionNotFound)
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Error("Delete() of a deleted checkpoint failed:", err)
+	}
+
+	if err := store.Save(ctx, Checkpoint{ID: "../x"}); err == nil {
+		t.Error("Save() succeeded with an invalid ID")
+	}
+}
+
+func TestEngine_Resume(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	asl := `{"StartAt": "A", "States": {
+		"A": {"Type": "Pass", "Assign": {"x": 1}, "Result": {"a": 1}, "Next": "B"},
+		"B": {"Type": "Task", "Resource": "gate:x", "End": true,
+			"Parameters": {"x.$": "$x", "in.$": "$", "id.$": "$$.Execution.Id", "retry.$": "$$.State.RetryCount"},
+			"Retry": [{"ErrorEquals": ["Flaky"], "IntervalSeconds": 3600, "MaxAttempts": 1}]}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	// the first engine stops while the task waits for a retry
+	engine := NewEngine(
+		WithIDGenerator(func() (string, error) { return "id", nil }),
+		WithExecutionStore(store),
+		WithTaskRegistry(task.FnMap{
+			"gate": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+				return nil, "Flaky", nil
+			},
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			if cp, err := store.Load(ctx, "id"); err == nil && cp.RetryCount == 1 {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	res, err := engine.Execute(ctx, nil, w, bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal("Execute() failed:", err)
+	}
+	if res.Status != ExecutionStatusAborted {
+		t.Fatalf("Status = %s, want %s", res.Status, ExecutionStatusAborted)
+	}
+
+	cp, err := store.Load(context.Background(), "id")
+	if err != nil {
+		t.Fatal("the checkpoint is not kept:", err)
+	}
+	if cp.StateName != "B" || string(cp.Input) != `{"a":1}` || cp.RetryCount != 1 || !cmp.Equal(cp.RetryAttempts, []int{1}) {
+		t.Errorf("checkpoint = %+v", cp)
+	}
+
+	// another engine resumes it after a restart
+	engine = NewEngine(
+		WithExecutionStore(store),
+		WithTaskRegistry(task.FnMap{
+			"gate": func(ctx context.Context, path string, in fn.Obj) (fn.Obj, string, error) {
+				return in, "", nil
+			},
+		}),
+	)
+
+	unfinished, err := engine.Unfinished(context.Background())
+	if err != nil || len(unfinished) != 1 {
+		t.Fatalf("Unfinished() = %v, %v", unfinished, err)
+	}
+
+	res, err = engine.Resume(context.Background(), w, "id")
+	if err != nil {
+		t.Fatal("Resume() failed:", err)
+	}
+	want := `{"id":"id","in":{"a":1},"retry":1,"x":1}`
+	if res.Status != ExecutionStatusSucceeded || string(res.Output) != want {
+		t.Errorf("Resume() = %s, %s, want %s: %s", res.Status, res.Output, want, res.Cause)
+	}
+	if !res.StartDate.Equal(cp.StartDate) {
+		t.Errorf("StartDate = %s, want %s", res.StartDate, cp.StartDate)
+	}
+
+	if _, err := store.Load(context.Background(), "id"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("the checkpoint of the finished execution is kept: %v", err)
+	}
+	if _, err := engine.Resume(context.Background(), w, "id"); !errors.Is(err, ErrExecutionNotFound) {
+		t.Errorf("Resume() error = %v, want %v", err, ErrExecutionNotFound)
+	}
+}
+
+// A Wait state resumed after a restart waits only for the rest of its seconds.
+func TestEngine_Resume_wait(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	asl := `{"StartAt": "W", "States": {"W": {"Type": "Wait", "Seconds": 3600, "End": true}}}`
+	w, err := compiler.Compile(context.Background(), bytes.NewBufferString(asl))
+	if err != nil {
+		t.Fatal("compiler.Compile() failed:", err)
+	}
+
+	entered := time.Now().Add(-time.Hour + 100*time.Millisecond)
+	cp := Checkpoint{ID: "id", StartDate: entered, StateName: "W", Input: json.RawMessage(`{}`), Context: json.RawMessage(`{}`), EnteredTime: entered, UpdateDate: entered}
+	if err := store.Save(context.Background(), cp); err != nil {
+		t.Fatal("Save() failed:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := NewEngine(WithExecutionStore(store)).Resume(ctx, w, "id")
+	if err != nil {
+		t.Fatal("Resume() failed:", err)
+	}
+	if res.Status != ExecutionStatusSucceeded {
+		t.Errorf("Resume() = %s: %s: %s", res.Status, res.Error, res.Cause)
+	}
+}
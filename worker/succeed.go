@@ -7,6 +7,6 @@ import (
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
-func (w Workflow) evalSucceed(ctx context.Context, state compiler.SucceedState, input interface{}) (interface{}, statesError) {
+func (w Workflow) evalSucceed(ctx context.Context, state compiler.SucceedState, input interface{}) (interface{}, StatesError) {
 	return input, NewStatesError("", fmt.Errorf("Succeed: %w", ErrStateMachineTerminated))
 }
@@ -3,14 +3,108 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"os"
+	"time"
 
 	"github.com/w-haibara/kakemoti/compiler"
-	"github.com/w-haibara/kakemoti/task"
+	"github.com/w-haibara/kakemoti/task/fn"
 )
 
-func (w Workflow) evalTask(ctx context.Context, state compiler.TaskState, input interface{}) (interface{}, statesError) {
-	out, stateserr, err := task.Do(ctx, state.Resouce.Type, state.Resouce.Path, input)
+var (
+	ErrTaskTimedOut          = errors.New("task timed out")
+	ErrTaskHeartbeatTimedOut = errors.New("task heartbeat timed out")
+)
+
+func (w Workflow) evalTaskWithTimeout(ctx context.Context, coj *compiler.CtxObj, state compiler.TaskState, input interface{}) (interface{}, StatesError) {
+	timeout, err := seconds(coj, input, state.TimeoutSeconds, state.TimeoutSecondsPath)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, fmt.Errorf("invalid TimeoutSeconds: %v", err))
+	}
+
+	heartbeat, err := seconds(coj, input, state.HeartbeatSeconds, state.HeartbeatSecondsPath)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, fmt.Errorf("invalid HeartbeatSeconds: %v", err))
+	}
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		taskCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	beats := make(chan struct{}, 1)
+	taskCtx = fn.WithHeartbeat(taskCtx, beats)
+
+	var (
+		output   interface{}
+		stateerr StatesError
+	)
+
+	// the token is valid only while the task is running
+	var (
+		token     string
+		callbacks <-chan taskCallback
+	)
+	if state.Resouce.WaitForTaskToken {
+		token, err = taskToken(coj)
+		if err != nil {
+			return nil, NewStatesError(StatesErrorRuntime, err)
+		}
+		tokens := w.getEngine().tokens
+		callbacks = tokens.register(token)
+		defer tokens.unregister(token)
+	}
+
+	succeed := make(chan bool, 1)
+	go func() {
+		if state.Resouce.WaitForTaskToken {
+			output, stateerr = w.waitForTaskToken(taskCtx, token, callbacks, state, input)
+		} else {
+			output, stateerr = w.evalTask(taskCtx, state, input)
+		}
+		succeed <- true
+	}()
+
+	// the heartbeat timer is restarted every time the task sends a heartbeat
+	var heartbeatTimeout <-chan time.Time
+	var timer *time.Timer
+	if heartbeat > 0 {
+		timer = time.NewTimer(heartbeat)
+		defer timer.Stop()
+		heartbeatTimeout = timer.C
+	}
+
+	for {
+		select {
+		case <-succeed:
+			if errors.Is(taskCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+				return nil, NewStatesError(StatesErrorTimeout, ErrTaskTimedOut)
+			}
+			return output, stateerr
+		case <-taskCtx.Done():
+			if ctx.Err() != nil {
+				return nil, interruptedError(ctx.Err())
+			}
+			return nil, NewStatesError(StatesErrorTimeout, ErrTaskTimedOut)
+		case <-beats:
+			if timer == nil {
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(heartbeat)
+		case <-heartbeatTimeout:
+			return nil, NewStatesError(StatesErrorHeartbeatTimeout, ErrTaskHeartbeatTimedOut)
+		}
+	}
+}
+
+func (w Workflow) evalTask(ctx context.Context, state compiler.TaskState, input interface{}) (interface{}, StatesError) {
+	// the input may be shared with other branches, and the task function may modify it
+	out, stateserr, err := w.getEngine().tasks.Do(ctx, state.Resouce.Type, state.Resouce.Path, compiler.CopyJSON(input))
 	if stateserr != "" {
 		return nil, NewStatesError(stateserr, err)
 	}
@@ -21,5 +115,41 @@ func (w Workflow) evalTask(ctx context.Context, state compiler.TaskState, input
 		return nil, NewStatesError(StatesErrorTaskFailed, err)
 	}
 
-	return out, NewStatesError(stateserr, nil)
+	return compiler.CopyJSON(out), NewStatesError(stateserr, nil)
+}
+
+// seconds returns the duration given by a field such as TimeoutSeconds, or its Path variant resolved against the input.
+// It returns 0 if neither of them is specified.
+func seconds(coj *compiler.CtxObj, input interface{}, v *int, path *compiler.Path) (time.Duration, error) {
+	if v != nil {
+		return time.Duration(*v) * time.Second, nil
+	}
+
+	if path == nil {
+		return 0, nil
+	}
+
+	p := *path
+	got, err := compiler.UnjoinByPath(coj, input, &p)
+	if err != nil {
+		return 0, err
+	}
+
+	var n float64
+	switch got := got.(type) {
+	case float64:
+		n = got
+	case int:
+		n = float64(got)
+	case int64:
+		n = float64(got)
+	default:
+		return 0, fmt.Errorf("invalid type of input.Path(path) result: %T", got)
+	}
+
+	if n <= 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("must be a positive integer: %v", n)
+	}
+
+	return time.Duration(n) * time.Second, nil
 }
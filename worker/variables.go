@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/w-haibara/kakemoti/compiler"
+)
+
+// assign returns the variables updated with the Assign field of a state or a catcher.
+// The values are evaluated with the variables before the state, so they can refer to each other's old values.
+// With JSONPath, "$" in the values is the result of the state.
+func (w Workflow) assign(ctx context.Context, coj *compiler.CtxObj, assign *compiler.Assign, result interface{}) (*compiler.Variables, StatesError) {
+	if assign == nil {
+		return coj.Variables(), NewStatesError("", nil)
+	}
+
+	values, err := assign.Resolve(ctx, coj, result)
+	if err != nil {
+		return nil, newAssignError(err)
+	}
+
+	return assignVariables(coj, values)
+}
+
+// assignWithJSONata is assign for JSONata, whose values are evaluated with vars such as $states.result.
+func (w Workflow) assignWithJSONata(ctx context.Context, coj *compiler.CtxObj, assign *compiler.Assign, vars compiler.JSONataVars) (*compiler.Variables, StatesError) {
+	if assign == nil {
+		return coj.Variables(), NewStatesError("", nil)
+	}
+
+	values, err := assign.Eval(ctx, vars)
+	if err != nil {
+		return nil, newAssignError(err)
+	}
+
+	return assignVariables(coj, values)
+}
+
+func assignVariables(coj *compiler.CtxObj, values map[string]interface{}) (*compiler.Variables, StatesError) {
+	vars, err := coj.Variables().Assign(values)
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, err)
+	}
+	return vars, NewStatesError("", nil)
+}
+
+func newAssignError(err error) StatesError {
+	if errors.Is(err, compiler.ErrIntrinsicFunctionFailed) {
+		return NewStatesError(StatesErrorIntrinsicFailure, err)
+	}
+	return NewStatesError(StatesErrorQueryEvaluationError, err)
+}
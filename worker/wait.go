@@ -5,20 +5,37 @@ import (
 	"fmt"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
 var timeformat = "2006-01-02T15:04:05Z"
 
-func (w Workflow) evalWait(ctx context.Context, coj *compiler.CtxObj, state compiler.WaitState, input interface{}) (interface{}, statesError) {
+func (w Workflow) evalWait(ctx context.Context, coj *compiler.CtxObj, state compiler.WaitState, input interface{}) (interface{}, StatesError) {
 	d, err := getDulation(ctx, coj, state, input)
 	if err != nil {
 		return nil, NewStatesError("", err)
 	}
 
-	log.WithFields(workflowFields(w)).Printf("Wait %s from %s", d, time.Now())
-	time.Sleep(d)
+	// the seconds are counted from $$.State.EnteredTime, which is earlier than now if the execution is resumed
+	if state.Seconds != nil || state.SecondsPath != nil {
+		if entered, ok := stateEnteredTime(coj); ok {
+			d -= w.getEngine().now().Sub(entered)
+		}
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	w.logger().WithFields(workflowFields(w)).Printf("Wait %s from %s", d, time.Now())
+
+	// the wait is interrupted when the execution or the enclosing Parallel state is stopped
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, interruptedError(ctx.Err())
+	}
 
 	return input, NewStatesError("", nil)
 }
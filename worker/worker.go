@@ -3,21 +3,18 @@ package worker
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/w-haibara/kakemoti/compiler"
 )
 
 var (
 	ErrStateMachineTerminated = errors.New("state machine terminated")
+	ErrStateMachineFailed     = errors.New("state machine failed")
 	ErrUnknownStateType       = errors.New("unknown state type")
 )
 
@@ -26,57 +23,80 @@ var (
 )
 
 func Exec(ctx context.Context, coj *compiler.CtxObj, w compiler.Workflow, input *bytes.Buffer) ([]byte, error) {
-	workflow, err := NewWorkflow(&w)
+	res, err := NewEngine().Execute(ctx, coj, &w, input)
 	if err != nil {
-		log.Println("Error:", err)
-	}
-
-	if input == nil || strings.TrimSpace(input.String()) == "" {
-		input = bytes.NewBuffer(EmptyJSON)
-	}
-
-	var in interface{}
-	if err := json.Unmarshal(input.Bytes(), &in); err != nil {
-		log.WithFields(errorFields(err)).Fatal()
-	}
-
-	out, err := workflow.Exec(ctx, coj, in)
-	if !errors.Is(err, ErrStateMachineTerminated) && err != nil {
-		log.WithFields(errorFields(err)).Fatal()
+		return nil, err
 	}
 
-	b, err := json.Marshal(out)
-	if err != nil {
-		log.WithFields(errorFields(err)).Fatal()
+	if res.Status != ExecutionStatusSucceeded {
+		return nil, res.Err()
 	}
 
-	return b, nil
+	return res.Output, nil
 }
 
 type Workflow struct {
 	*compiler.Workflow
-	ID string
+	ID     string
+	engine *Engine
+	// store saves the checkpoints of the workflow, which is nil unless it is the top-level workflow of an execution.
+	store     ExecutionStore
+	startDate time.Time
+	// history records the events of the execution, which is shared by the branches of the workflow.
+	history *History
 }
 
 func NewWorkflow(w *compiler.Workflow) (*Workflow, error) {
-	id, err := uuid.NewRandom()
+	return NewEngine().newWorkflow(w)
+}
+
+// newWorkflow creates a workflow for a branch or an iteration, run by the same engine.
+func (w Workflow) newWorkflow(branch *compiler.Workflow) (*Workflow, error) {
+	child, err := w.getEngine().newWorkflow(branch)
 	if err != nil {
 		return nil, err
 	}
-	return &Workflow{w, id.String()}, nil
+	child.history = w.history
+	return child, nil
+}
+
+func (w Workflow) getEngine() *Engine {
+	if w.engine == nil {
+		return NewEngine()
+	}
+	return w.engine
+}
+
+func (w Workflow) logger() *log.Logger {
+	return w.getEngine().logger
 }
 
 func (w Workflow) Exec(ctx context.Context, coj *compiler.CtxObj, input interface{}) (interface{}, error) {
+	return w.exec(ctx, coj, nil, input)
+}
+
+// exec runs the workflow with the scope of the variables, which is a new scope for a branch of a Parallel or a Map.
+func (w Workflow) exec(ctx context.Context, coj *compiler.CtxObj, vars *compiler.Variables, input interface{}) (interface{}, error) {
+	return w.execFrom(ctx, coj, vars, w.States[0], input, stateProgress{})
+}
+
+// execFrom runs the workflow from the first state of the branch, which continues from progress.
+func (w Workflow) execFrom(ctx context.Context, coj *compiler.CtxObj, vars *compiler.Variables, branch []compiler.State, input interface{}, progress stateProgress) (interface{}, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	if w.TimeoutSeconds > 0 {
 		ctx, cancel = context.WithTimeout(ctx, time.Second*time.Duration(w.TimeoutSeconds))
 	}
 	defer cancel()
 
+	coj, err := w.withExecution(coj, input, w.getEngine().now())
+	if err != nil {
+		return nil, NewStatesError(StatesErrorRuntime, err)
+	}
+
 	output := input
-	branch := w.States[0]
 	for {
-		out, b, err := w.evalBranch(ctx, coj, branch, output)
+		out, v, b, err := w.evalBranch(ctx, coj, vars, branch, output, progress)
+		progress = stateProgress{}
 		if errors.Is(err, ErrStateMachineTerminated) {
 			return out, err
 		}
@@ -84,7 +104,7 @@ func (w Workflow) Exec(ctx context.Context, coj *compiler.CtxObj, input interfac
 			return nil, err
 		}
 
-		output = out
+		output, vars = out, v
 
 		if b == nil {
 			break
@@ -96,24 +116,45 @@ func (w Workflow) Exec(ctx context.Context, coj *compiler.CtxObj, input interfac
 	return output, nil
 }
 
-func (w Workflow) evalBranch(ctx context.Context, coj *compiler.CtxObj, branch []compiler.State, input interface{}) (interface{}, []compiler.State, error) {
+// evalBranch evaluates the states in a branch, and returns the output and the variables after them.
+// The first state continues from progress, such as the one in a checkpoint.
+func (w Workflow) evalBranch(ctx context.Context, coj *compiler.CtxObj, vars *compiler.Variables, branch []compiler.State, input interface{}, progress stateProgress) (interface{}, *compiler.Variables, []compiler.State, error) {
 	output := input
-	for _, state := range branch {
-		out, next, err := w.evalStateWithRetryAndCatch(ctx, coj, state, output)
-		log.WithFields(stateFields(state)).
+	for i, state := range branch {
+		// no more states are started once the execution is stopped
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, interruptedError(err).withStateName(state.Name())
+		}
+
+		if i > 0 {
+			progress = stateProgress{}
+		}
+		// a resumed state keeps the time when it was entered before
+		if progress.enteredTime.IsZero() {
+			progress.enteredTime = w.getEngine().now()
+		}
+		if err := w.checkpoint(ctx, coj, vars, state, output, progress); err != nil {
+			return nil, nil, nil, NewStatesError(StatesErrorRuntime, err).withStateName(state.Name())
+		}
+
+		w.history.addStateEntered(ctx, state, output)
+		out, next, v, err := w.evalStateWithRetryAndCatch(ctx, coj, vars, state, output, progress)
+		w.logger().WithFields(stateFields(state)).
 			WithFields(log.Fields{
 				"_input":  input,
 				"_output": out,
 				"_err":    err,
 			}).Println()
 		if errors.Is(err, ErrStateMachineTerminated) {
-			return out, nil, err
+			w.history.addStateExited(ctx, state, out)
+			return out, vars, nil, err
 		}
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
+		w.history.addStateExited(ctx, state, out)
 
-		output = out
+		output, vars = out, v
 
 		if next == "" {
 			continue
@@ -121,137 +162,141 @@ func (w Workflow) evalBranch(ctx context.Context, coj *compiler.CtxObj, branch [
 
 		b, err := w.nextBranchFromString(next)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		if b != nil {
-			return out, b, nil
+			return out, vars, b, nil
 		}
 	}
 
 	branch, err := w.nextBranch(branch[len(branch)-1])
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	return output, branch, nil
+	return output, vars, branch, nil
 }
 
-func (w Workflow) evalStateWithRetryAndCatch(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}) (interface{}, string, error) {
-	origresult, next, origerr := w.evalStateWithFilter(ctx, coj, state, input)
-	if origerr.IsEmpty() {
-		return origresult, next, nil
+func (w Workflow) evalStateWithRetryAndCatch(ctx context.Context, coj *compiler.CtxObj, vars *compiler.Variables, state compiler.State, input interface{}, progress stateProgress) (interface{}, string, *compiler.Variables, error) {
+	var retriers []compiler.Retry
+	if state.FieldsType() >= compiler.FieldsType5 {
+		retriers = state.Common().Retry
 	}
-
-	log.WithFields(stateFields(state)).Printf("%s failed: %s", state.Name(), origerr.Error())
-
-	if state.FieldsType() < compiler.FieldsType5 {
-		return origresult, next, origerr
+	attempts := make([]int, len(retriers))
+	retryCount := 0
+	if len(progress.retryAttempts) == len(retriers) {
+		copy(attempts, progress.retryAttempts)
+		retryCount = progress.retryCount
 	}
 
-	result, next, stateserr := w.retry(ctx, coj, state, input, state.Common().Retry, origerr)
-	if stateserr.IsEmpty() {
-		return result, next, nil
+	// the context object of the execution is checkpointed, not the one of the state
+	execCoj := coj
+	// $$.State.EnteredTime is kept through the retries
+	enteredTime := progress.enteredTime
+	if enteredTime.IsZero() {
+		enteredTime = w.getEngine().now()
 	}
-
-	return w.catch(ctx, coj, state, input, origresult, origerr)
-}
-
-func (w Workflow) retry(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}, retry []compiler.Retry, stateserr statesError) (interface{}, string, statesError) {
-	for _, retry := range retry {
-		maxAttempts := 3
-		if retry.MaxAttempts != nil {
-			maxAttempts = *retry.MaxAttempts
+	for {
+		coj, err := w.withState(coj, state, enteredTime, retryCount)
+		if err != nil {
+			return nil, "", nil, NewStatesError(StatesErrorRuntime, err).withStateName(state.Name())
 		}
+		coj = coj.WithVariables(vars)
 
-		backoffRate := 2.0
-		if retry.BackoffRate != nil {
-			backoffRate = *retry.BackoffRate
+		result, next, v, stateserr := w.evalStateWithFilter(ctx, coj, state, input)
+		if stateserr.IsEmpty() {
+			return result, next, v, nil
 		}
+		stateserr = stateserr.withStateName(state.Name())
 
-		intervalSeconds := 1
-		if retry.IntervalSeconds != nil {
-			intervalSeconds = *retry.IntervalSeconds
-		}
+		w.logger().WithFields(stateFields(state)).Printf("%s failed: %s", state.Name(), stateserr.Error())
 
-		for count := 0; count < maxAttempts; count++ {
-			if !func() bool {
-				for _, target := range retry.ErrorEquals {
-					switch target {
-					case StatesErrorALL, stateserr.statesErr, "":
-						return true
-					}
-				}
-				return false
-			}() {
-				break
-			}
+		if state.FieldsType() < compiler.FieldsType5 {
+			return result, next, vars, stateserr
+		}
 
-			ind := float64(intervalSeconds)
-			if count > 0 {
-				ind += math.Pow(backoffRate, float64(count))
-			}
+		interval, ok := retryInterval(retriers, attempts, stateserr)
+		if !ok {
+			return w.catch(ctx, coj, state, input, stateserr)
+		}
 
-			log.WithFields(stateFields(state)).
-				WithFields(
-					log.Fields{
-						"retry-interval": ind,
-						"retry-count":    count,
-					}).Println("retry:", state.Name())
-			r, n, err := w.retryWithInterval(ctx, coj, state, input, ind)
-			if err.IsEmpty() {
-				return r, n, err
-			}
+		w.logger().WithFields(stateFields(state)).
+			WithFields(
+				log.Fields{
+					"retry-interval": interval.Seconds(),
+					"retry-attempts": attempts,
+				}).Println("retry:", state.Name())
 
-			log.WithFields(stateFields(state)).Printf("%s failed: %v", state.Name(), err)
+		// the retry is not forgotten even if the execution is resumed during the interval
+		if err := w.checkpoint(ctx, execCoj, vars, state, input, stateProgress{enteredTime: enteredTime, retryCount: retryCount + 1, retryAttempts: attempts}); err != nil {
+			return nil, "", nil, NewStatesError(StatesErrorRuntime, err).withStateName(state.Name())
+		}
 
-			if count == maxAttempts-1 {
-				return r, n, err
-			}
+		if err := sleep(ctx, interval); err != nil {
+			return nil, "", nil, interruptedError(err).withStateName(state.Name())
 		}
+		retryCount++
 	}
-
-	err := errors.New("retry() failed")
-	return nil, "", NewStatesError(err.Error(), err)
-}
-
-func (w Workflow) retryWithInterval(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}, interval float64) (interface{}, string, statesError) {
-	time.Sleep(time.Duration(interval) * time.Second)
-	return w.evalState(ctx, coj, state, input)
 }
 
-func (w Workflow) catch(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input, result interface{}, stateserr statesError) (interface{}, string, error) {
+// catch returns the output of the catcher that matches stateserr, and the variables assigned by the catcher.
+func (w Workflow) catch(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}, stateserr StatesError) (interface{}, string, *compiler.Variables, error) {
 	if state.FieldsType() < compiler.FieldsType5 {
-		return result, "", stateserr
+		return nil, "", nil, stateserr
 	}
 
 	common := state.Common()
 	for _, catch := range common.Catch {
-		for _, target := range catch.ErrorEquals {
-			if target != StatesErrorALL && target != stateserr.statesErr {
-				continue
-			}
+		matched, ok := stateserr.match(catch.ErrorEquals)
+		if !ok {
+			continue
+		}
 
-			if catch.ResultPath == nil {
-				return input, catch.Next, nil
-			}
+		if common.IsJSONata() {
+			return w.catchWithJSONata(ctx, coj, state, catch, input, matched.errorOutput())
+		}
 
-			v, err := compiler.JoinByPath(coj, input, result, catch.ResultPath)
-			if err != nil {
-				return nil, "", err
-			}
+		vars, serr := w.assign(ctx, coj, catch.Assign, matched.errorOutput())
+		if !serr.IsEmpty() {
+			return nil, "", nil, serr.withStateName(state.Name())
+		}
 
-			return v, catch.Next, nil
+		// the error output is discarded if ResultPath is null, and replaces the input if it is omitted
+		if catch.DiscardResult {
+			return input, catch.Next, vars, nil
+		}
+		if catch.ResultPath == nil {
+			return matched.errorOutput(), catch.Next, vars, nil
+		}
+
+		v, err := compiler.JoinByPath(coj, input, matched.errorOutput(), &catch.ResultPath.Path)
+		if err != nil {
+			return nil, "", nil, NewStatesError(StatesErrorResultPathMatchFailure, err).withStateName(state.Name())
 		}
 
+		return v, catch.Next, vars, nil
 	}
 
-	return result, "", stateserr
+	return nil, "", nil, stateserr
 }
 
-func (w Workflow) evalStateWithFilter(ctx context.Context, coj *compiler.CtxObj, state compiler.State, rawinput interface{}) (interface{}, string, statesError) {
-	log.WithFields(stateFields(state)).Println("eval state:", state.Name())
+func (w Workflow) evalStateWithFilter(ctx context.Context, coj *compiler.CtxObj, state compiler.State, rawinput interface{}) (interface{}, string, *compiler.Variables, StatesError) {
+	w.logger().WithFields(stateFields(state)).Println("eval state:", state.Name())
+
+	// the task token has to be in the context object before Parameters are evaluated
+	if v, ok := state.(compiler.TaskState); ok && v.Resouce.WaitForTaskToken {
+		c, err := w.withTaskToken(coj)
+		if err != nil {
+			return nil, "", nil, NewStatesError(StatesErrorRuntime, err)
+		}
+		coj = c
+	}
 
-	effectiveInput, stateerr := func() (interface{}, statesError) {
+	if state.Common().IsJSONata() {
+		return w.evalStateWithJSONata(ctx, coj, state, rawinput)
+	}
+
+	effectiveInput, stateerr := func() (interface{}, StatesError) {
 		v1, err := compiler.FilterByInputPath(coj, state, rawinput)
 		if err != nil {
 			return nil, NewStatesError("", fmt.Errorf("FilterByInputPath(state, rawinput) failed: %v", err))
@@ -268,18 +313,19 @@ func (w Workflow) evalStateWithFilter(ctx context.Context, coj *compiler.CtxObj,
 		return v2, NewStatesError("", nil)
 	}()
 	if !stateerr.IsEmpty() {
-		return nil, "", stateerr
+		return nil, "", nil, stateerr
 	}
 
-	result, next, stateerr := w.evalState(ctx, coj, state, effectiveInput)
+	result, next, rule, stateerr := w.evalState(ctx, coj, state, effectiveInput)
 	if errors.Is(stateerr, ErrStateMachineTerminated) {
-		return result, "", stateerr
+		return result, "", nil, stateerr
 	}
 	if !stateerr.IsEmpty() {
-		return nil, "", stateerr
+		return nil, "", nil, stateerr
 	}
 
-	effectiveResult, stateerr := func() (interface{}, statesError) {
+	var vars *compiler.Variables
+	effectiveResult, stateerr := func() (interface{}, StatesError) {
 		v1, err := compiler.FilterByResultSelector(ctx, coj, state, result)
 		if err != nil {
 			if errors.Is(err, compiler.ErrIntrinsicFunctionFailed) {
@@ -288,6 +334,17 @@ func (w Workflow) evalStateWithFilter(ctx context.Context, coj *compiler.CtxObj,
 			return nil, NewStatesError("", fmt.Errorf("FilterByResultSelector(state, result) failed: %v", err))
 		}
 
+		// the variables are assigned with the result selected by ResultSelector
+		assign := state.Common().Assign
+		if rule != nil && rule.Assign != nil {
+			assign = rule.Assign
+		}
+		v, serr := w.assign(ctx, coj, assign, v1)
+		if !serr.IsEmpty() {
+			return nil, serr
+		}
+		vars = v
+
 		v2, err := compiler.FilterByResultPath(coj, state, rawinput, v1)
 		if err != nil {
 			return nil, NewStatesError(StatesErrorResultPathMatchFailure, fmt.Errorf("FilterByResultPath(state, rawinput, result) failed: %v", err))
@@ -296,85 +353,64 @@ func (w Workflow) evalStateWithFilter(ctx context.Context, coj *compiler.CtxObj,
 		return v2, NewStatesError("", nil)
 	}()
 	if !stateerr.IsEmpty() {
-		return nil, "", stateerr
+		return nil, "", nil, stateerr
 	}
 
 	effectiveOutput, err := compiler.FilterByOutputPath(coj, state, effectiveResult)
 	if err != nil {
-		return nil, "", NewStatesError("", err)
+		return nil, "", nil, NewStatesError("", err)
 	}
 
-	return effectiveOutput, next, NewStatesError("", nil)
+	return effectiveOutput, next, vars, NewStatesError("", nil)
 }
 
-func (w Workflow) evalState(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}) (interface{}, string, statesError) {
+// evalState runs the state, and returns its result and the next state.
+// rule is the Choice rule that matched if the state is a Choice state, or nil.
+func (w Workflow) evalState(ctx context.Context, coj *compiler.CtxObj, state compiler.State, input interface{}) (interface{}, string, *compiler.Choice, StatesError) {
 	wg := new(sync.WaitGroup)
 
 	var (
 		next     string
 		output   interface{}
-		stateerr statesError
+		rule     *compiler.Choice
+		stateerr StatesError
 	)
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				output, next = nil, ""
+				stateerr = NewStatesError(StatesErrorRuntime, fmt.Errorf("panic: %v", r))
+			}
+		}()
 
 		switch v := state.(type) {
 		case compiler.PassState:
 			output, stateerr = w.evalPass(ctx, v, input)
 		case compiler.TaskState:
-			var (
-				o    interface{}
-				serr statesError
-			)
-
-			wg2 := new(sync.WaitGroup)
-			wg2.Add(1)
-			go func() {
-				defer wg2.Done()
-				o, serr = w.evalTask(ctx, v, input)
-			}()
-
-			succeed := make(chan bool, 1)
-			go func() {
-				wg2.Wait()
-				succeed <- true
-			}()
-
-			timeouted := make(chan bool, 1)
-			go func() {
-				s, ok := state.(compiler.TaskState)
-				if !ok {
-					return
-				}
-				d := s.HeartbeatSeconds
-				if d == nil {
-					return
-				}
-				time.Sleep(time.Duration(*d))
-				timeouted <- true
-			}()
-
-			select {
-			case <-succeed:
-				output = o
-				stateerr = serr
-			case <-timeouted:
-				stateerr = NewStatesError(StatesErrorHeartbeatTimeout, nil)
-			}
+			w.history.addTaskScheduled(ctx, v, input)
+			output, stateerr = w.evalTaskWithTimeout(ctx, coj, v, input)
+			w.history.addTaskResult(ctx, v, output, stateerr)
 		case compiler.ChoiceState:
-			next, output, stateerr = w.evalChoice(ctx, coj, v, input)
+			rule, output, stateerr = w.evalChoice(ctx, coj, v, input)
+			if rule != nil {
+				next = rule.Next
+			}
 		case compiler.WaitState:
 			output, stateerr = w.evalWait(ctx, coj, v, input)
 		case compiler.SucceedState:
 			output, stateerr = w.evalSucceed(ctx, v, input)
 		case compiler.FailState:
-			output, stateerr = w.evalFail(ctx, v, input)
+			output, stateerr = w.evalFail(ctx, coj, v, input)
 		case compiler.ParallelState:
+			w.history.add(ctx, HistoryEvent{Type: HistoryEventParallelStateStarted})
 			output, stateerr = w.evalParallel(ctx, coj, v, input)
+			w.history.addStateResult(ctx, HistoryEventParallelStateSucceeded, HistoryEventParallelStateFailed, HistoryEventParallelStateAborted, stateerr)
 		case compiler.MapState:
 			output, stateerr = w.evalMap(ctx, coj, v, input)
+			w.history.addStateResult(ctx, HistoryEventMapStateSucceeded, HistoryEventMapStateFailed, HistoryEventMapStateAborted, stateerr)
 		default:
 			panic(fmt.Sprintf("unknow state type: %#v", v))
 		}
@@ -394,9 +430,9 @@ func (w Workflow) evalState(ctx context.Context, coj *compiler.CtxObj, state com
 
 	select {
 	case <-succeed:
-		return output, next, stateerr
+		return output, next, rule, stateerr
 	case <-timeouted:
-		return nil, "", NewStatesError(StatesErrorTimeout, nil)
+		return nil, "", nil, interruptedError(ctx.Err())
 	}
 }
 
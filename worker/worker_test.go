@@ -25,15 +25,17 @@ var workflowExecTests = []workflowExecTestCase{
 	{"pass(ctxobj)", "pass_ctxobj", "_workflow/inputs/input1.json", "_workflow/outputs/output10.json"},
 	{"wait", "wait", "_workflow/inputs/input1.json", "_workflow/outputs/output1.json"},
 	{"succeed", "succeed", "_workflow/inputs/input1.json", "_workflow/outputs/output1.json"},
-	{"fail", "fail", "_workflow/inputs/input1.json", "_workflow/outputs/output1.json"},
 	{"choice", "choice", "_workflow/inputs/input2.json", "_workflow/outputs/output2.json"},
 	{"choice(fallback)", "choice_fallback", "_workflow/inputs/input2.json", "_workflow/outputs/output7.json"},
 	{"choice(boolean expr)", "choice_bool", "_workflow/inputs/input5.json", "_workflow/outputs/output8.json"},
 	{"choice(data test expr)", "choice_data_test", "_workflow/inputs/input6.json", "_workflow/outputs/output8.json"},
 	{"parallel", "parallel", "_workflow/inputs/input2.json", "_workflow/outputs/output3.json"},
+	{"parallel(catch)", "parallel_catch", "_workflow/inputs/input1.json", "_workflow/outputs/output17.json"},
 	{"task", "task", "_workflow/inputs/input1.json", "_workflow/outputs/output4.json"},
 	{"task(filter)", "task_filter", "_workflow/inputs/input3.json", "_workflow/outputs/output9.json"},
-	{"task(catch)", "task_catch", "_workflow/inputs/input1.json", "_workflow/inputs/input1.json"},
+	{"task(catch)", "task_catch", "_workflow/inputs/input1.json", "_workflow/outputs/output16.json"},
+	{"task(catch with ResultPath)", "task_catch_resultpath", "_workflow/inputs/input1.json", "_workflow/outputs/output18.json"},
+	{"task(catch with null ResultPath)", "task_catch_resultpath_null", "_workflow/inputs/input1.json", "_workflow/outputs/output1.json"},
 	{"task(retry)", "task_retry", "_workflow/inputs/input1.json", "_workflow/outputs/output8.json"},
 	{"task(ctxobj)", "task_ctxobj", "_workflow/inputs/input1.json", "_workflow/outputs/output10.json"},
 	{"map", "map", "_workflow/inputs/input7.json", "_workflow/outputs/output13.json"},
@@ -42,12 +44,14 @@ var workflowExecTests = []workflowExecTestCase{
 	{"map(ctxobj2)", "map_ctxobj2", "_workflow/inputs/input8.json", "_workflow/outputs/output15.json"},
 }
 
-func TestExec(t *testing.T) {
+func TestMain(m *testing.M) {
 	if err := os.Chdir("../"); err != nil {
-		t.Error(`os.Chdir("../"):`, err)
-		return
+		panic(err.Error())
 	}
+	os.Exit(m.Run())
+}
 
+func TestExec(t *testing.T) {
 	for _, tt := range workflowExecTests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
@@ -105,3 +109,48 @@ func TestExec(t *testing.T) {
 		})
 	}
 }
+
+type workflowExecFailedTestCase struct {
+	name, asl, inputFile, wantError, wantCause string
+}
+
+var workflowExecFailedTests = []workflowExecFailedTestCase{
+	{"fail", "fail", "_workflow/inputs/input1.json", "", ""},
+	{"fail(error)", "fail_error", "_workflow/inputs/input1.json", "CustomError", "This is a custom error"},
+}
+
+func TestExecFailed(t *testing.T) {
+	for _, tt := range workflowExecFailedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			asl, err := os.ReadFile(filepath.Join("_workflow", "asl", tt.asl+".asl.json"))
+			if err != nil {
+				t.Error("os.ReadFile() failed:", err)
+				return
+			}
+
+			w, err := compiler.Compile(ctx, bytes.NewBuffer(asl))
+			if err != nil {
+				t.Error("compiler.Compile() failed:", err)
+				return
+			}
+
+			input, err := os.ReadFile(tt.inputFile)
+			if err != nil {
+				t.Error("os.ReadFile() failed:", err)
+				return
+			}
+
+			res, err := NewEngine().Execute(ctx, nil, w, bytes.NewBuffer(input))
+			if err != nil {
+				t.Error("Execute() failed:", err)
+				return
+			}
+
+			if res.Status != ExecutionStatusFailed || res.Error != tt.wantError || res.Cause != tt.wantCause {
+				t.Errorf("got = [%s, %s, %s], want = [%s, %s, %s]", res.Status, res.Error, res.Cause, ExecutionStatusFailed, tt.wantError, tt.wantCause)
+			}
+		})
+	}
+}